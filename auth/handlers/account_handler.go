@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"errors"
+	"go-fundraising/auth/models"
+	"go-fundraising/auth/services"
+	"go-fundraising/auth/throttle"
+	"go-fundraising/configs"
+	"go-fundraising/mailer"
+	payment "go-fundraising/payment/services"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocql/gocql"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var actionTokenService = services.ActionTokenService{}
+var paymentService = payment.PaymentService{}
+
+func SendVerificationEmailHandler(c *gin.Context) {
+	raw, _ := c.Get("user_id")
+	user, err := userService.GetUserByID(c, raw.(gocql.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+	if user.EmailVerified {
+		c.JSON(http.StatusConflict, gin.H{"error": "email is already verified"})
+		return
+	}
+
+	if err := sendVerificationEmail(c, user); err != nil {
+		log.Println("❌ Failed to send verification email:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
+
+// VerifyEmailHandler accepts the token as ?token= from the emailed link or
+// as a JSON body.
+func VerifyEmailHandler(c *gin.Context) {
+	tokenStr := c.Query("token")
+	if tokenStr == "" {
+		var req struct {
+			Token string `json:"token" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+			return
+		}
+		tokenStr = req.Token
+	}
+
+	claims, err := actionTokenService.ConsumeActionToken(c, tokenStr, services.PurposeVerifyEmail)
+	if err != nil {
+		writeActionTokenError(c, err)
+		return
+	}
+
+	user, err := userService.GetUserByID(c, claims.UserID)
+	if err != nil || user.Email != claims.Email {
+		c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrInvalidActionToken.Error()})
+		return
+	}
+
+	if err := userService.MarkEmailVerified(c, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	// The address is proven now, so guest donations made with it are theirs.
+	claimed, err := paymentService.ClaimGuestDonations(c, user.ID, user.Username, user.Email)
+	if err != nil {
+		log.Println("❌ Failed to claim guest donations for", user.ID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":                "Email verified",
+		"claimed_guest_payments": claimed,
+	})
+}
+
+// ForgotPasswordHandler always answers the same way so it cannot be used to
+// find out which emails have accounts.
+func ForgotPasswordHandler(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
+		return
+	}
+
+	user, err := userService.GetUserByEmail(c, req.Email)
+	if err == nil {
+		if err := sendPasswordResetEmail(c, user); err != nil {
+			log.Println("❌ Failed to send password reset email:", err)
+		}
+	} else if !errors.Is(err, gocql.ErrNotFound) {
+		log.Println("❌ Failed to look up user for password reset:", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If an account exists for that email, a reset link has been sent"})
+}
+
+func ResetPasswordHandler(c *gin.Context) {
+	var req struct {
+		Token      string `json:"token" binding:"required"`
+		Password   string `json:"password" binding:"required"`
+		RePassword string `json:"re_password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if req.RePassword != req.Password {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password and RePassword are not matching"})
+		return
+	}
+
+	claims, err := actionTokenService.ConsumeActionToken(c, req.Token, services.PurposeResetPassword)
+	if err != nil {
+		writeActionTokenError(c, err)
+		return
+	}
+
+	user, err := userService.GetUserByID(c, claims.UserID)
+	if err != nil || services.PasswordHint(user.PasswordHash) != claims.PasswordHint {
+		c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrInvalidActionToken.Error()})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Password hashing failed"})
+		return
+	}
+	if err := userService.UpdatePasswordHash(c, user.ID, string(hashedPassword)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	if err := throttle.Default().Success(c, user.ID.String()); err != nil {
+		log.Println("❌ Failed to reset login attempts after password reset:", user.ID, err)
+	}
+
+	// Whoever knew the old password must not stay signed in.
+	if err := refreshTokenService.RevokeAllSessions(c, user.ID); err != nil {
+		log.Println("❌ Failed to revoke sessions after password reset:", user.ID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset"})
+}
+
+func writeActionTokenError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidActionToken), errors.Is(err, services.ErrActionTokenUsed):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check token"})
+	}
+}
+
+func sendVerificationEmail(c *gin.Context, user models.User) error {
+	tokenStr, err := actionTokenService.IssueActionToken(user, services.PurposeVerifyEmail, services.EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	link := configs.GetEnv("APP_HOST") + "/auth/verify-email?token=" + url.QueryEscape(tokenStr)
+	return mailer.Default().Send(c, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email",
+		Body: "Hi " + user.Username + ",\n\n" +
+			"Confirm your email address by opening this link within 24 hours:\n\n" +
+			link + "\n",
+	})
+}
+
+// sendPasswordResetEmail links to PASSWORD_RESET_URL, the page that collects
+// the new password and posts it with the token to /auth/password/reset.
+func sendPasswordResetEmail(c *gin.Context, user models.User) error {
+	tokenStr, err := actionTokenService.IssueActionToken(user, services.PurposeResetPassword, services.PasswordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	base := configs.GetEnv("PASSWORD_RESET_URL")
+	if base == "" {
+		base = configs.GetEnv("APP_HOST") + "/reset-password"
+	}
+	return mailer.Default().Send(c, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: "Hi " + user.Username + ",\n\n" +
+			"Someone asked to reset your password. If it was you, open this link within an hour:\n\n" +
+			base + "?token=" + url.QueryEscape(tokenStr) + "\n\n" +
+			"If it was not you, you can ignore this email.\n",
+	})
+}
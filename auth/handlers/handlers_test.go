@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-fundraising/auth/models"
+	"go-fundraising/auth/oidc"
+	"go-fundraising/auth/roles"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocql/gocql"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testServer serves the handlers under test together with the fake OIDC
+// issuer, at APP_HOST.
+var testServer *httptest.Server
+
+func TestMain(m *testing.M) {
+	var router http.Handler
+	testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		router.ServeHTTP(w, r)
+	}))
+
+	os.Setenv("APP_HOST", testServer.URL)
+	os.Setenv("OIDC_FAKE_ISSUER", "true")
+	os.Setenv("JWT_SECRET", "test-secret")
+	router = newTestRouter()
+
+	code := m.Run()
+	testServer.Close()
+	os.Exit(code)
+}
+
+func newTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Any("/oidc/fake/*path", gin.WrapH(http.StripPrefix("/oidc/fake", oidc.Fake())))
+
+	auth := r.Group("/auth")
+	auth.GET("/oidc/:provider/login", OIDCLoginHandler)
+	auth.POST("/oidc/:provider/link", testAuth, OIDCLinkHandler)
+	auth.POST("/oidc/:provider/reauth", testAuth, OIDCReauthHandler)
+	auth.GET("/oidc/:provider/callback", OIDCCallbackHandler)
+	auth.POST("/mfa/confirm", testAuth, ConfirmMFAHandler)
+	auth.POST("/mfa/disable", testAuth, DisableMFAHandler)
+	auth.POST("/mfa/recovery-codes", testAuth, RegenerateRecoveryCodesHandler)
+	auth.POST("/password/change", testAuth, ChangePasswordHandler)
+	auth.POST("/email/change", testAuth, RequestEmailChangeHandler)
+	auth.POST("/account/delete", testAuth, DeleteAccountHandler)
+	return r
+}
+
+// testAuth stands in for middleware.AuthMiddleware and takes the user from
+// the X-Test-User header.
+func testAuth(c *gin.Context) {
+	userID, err := gocql.ParseUUID(c.GetHeader("X-Test-User"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing token"})
+		return
+	}
+	c.Set("user_id", userID)
+}
+
+// newBrowser is a client with its own cookies that follows redirects.
+func newBrowser(t *testing.T) *http.Client {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Jar: jar}
+}
+
+// call sends a request as userID (unless it is zero) with an optional JSON
+// body and decodes the JSON response.
+func call(t *testing.T, client *http.Client, method, path string, userID gocql.UUID, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+
+	var reader *strings.Reader
+	if body != nil {
+		raw, _ := json.Marshal(body)
+		reader = strings.NewReader(string(raw))
+	} else {
+		reader = strings.NewReader("")
+	}
+	req, err := http.NewRequest(method, testServer.URL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if userID != (gocql.UUID{}) {
+		// Each user gets an address of its own so that failures in one
+		// test never throttle the client IP of another.
+		req.Header.Set("X-Test-User", userID.String())
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("10.%d.%d.%d", userID[0], userID[1], userID[2]))
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer res.Body.Close()
+
+	out := map[string]interface{}{}
+	_ = json.NewDecoder(res.Body).Decode(&out)
+	return res.StatusCode, out
+}
+
+// newTestUser registers a user with password "correct horse" and a unique
+// email.
+func newTestUser(t *testing.T, emailVerified bool) models.User {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := gocql.TimeUUID()
+	user := models.User{
+		ID:            id,
+		Username:      "user-" + id.String()[:8] + id.String()[9:13],
+		Email:         "user-" + id.String() + "@example.com",
+		EmailVerified: emailVerified,
+		PasswordHash:  string(hash),
+		Roles:         roles.Default(),
+		CreatedAt:     time.Now(),
+	}
+	if err := userService.NewUser(context.Background(), user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return user
+}
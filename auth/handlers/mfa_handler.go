@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"errors"
+	"go-fundraising/auth/services"
+	"go-fundraising/auth/throttle"
+	"go-fundraising/auth/totp"
+	"go-fundraising/configs"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocql/gocql"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var mfaService = services.MFAService{}
+
+func GetMFAStatusHandler(c *gin.Context) {
+	raw, _ := c.Get("user_id")
+	userID := raw.(gocql.UUID)
+
+	mfa, err := mfaService.GetMFA(c, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch two-factor status"})
+		return
+	}
+
+	remaining := 0
+	if mfa.Enabled {
+		remaining, _ = mfaService.CountRecoveryCodes(c, userID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":                  mfa.Enabled,
+		"enabled_at":               mfa.EnabledAt,
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// EnrollMFAHandler starts enrollment and returns the secret both raw and as
+// an otpauth URI for authenticator apps.
+func EnrollMFAHandler(c *gin.Context) {
+	raw, _ := c.Get("user_id")
+	user, err := userService.GetUserByID(c, raw.(gocql.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+
+	secret, err := mfaService.StartEnrollment(c, user.ID)
+	if err != nil {
+		if errors.Is(err, services.ErrMFAAlreadyEnabled) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start enrollment"})
+		return
+	}
+
+	issuer := configs.GetEnv("MFA_ISSUER")
+	if issuer == "" {
+		issuer = "Go Fundraising"
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(issuer, user.Username, secret),
+	})
+}
+
+func ConfirmMFAHandler(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code is required"})
+		return
+	}
+
+	raw, _ := c.Get("user_id")
+	userID := raw.(gocql.UUID)
+	if attemptsExceeded(c, userID) {
+		return
+	}
+
+	codes, err := mfaService.ConfirmEnrollment(c, userID, req.Code)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidMFACode) && failedAttempt(c, userID) {
+			return
+		}
+		writeMFAError(c, err)
+		return
+	}
+	succeededAttempt(c, userID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+func DisableMFAHandler(c *gin.Context) {
+	var req struct {
+		Password     string `json:"password" binding:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "password is required"})
+		return
+	}
+
+	raw, _ := c.Get("user_id")
+	user, err := userService.GetUserByID(c, raw.(gocql.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+	if attemptsExceeded(c, user.ID) {
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)) != nil {
+		if failedAttempt(c, user.ID) {
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+		return
+	}
+	if err := verifySecondFactor(c, user.ID, req.Code, req.RecoveryCode); err != nil {
+		if errors.Is(err, services.ErrInvalidMFACode) && failedAttempt(c, user.ID) {
+			return
+		}
+		writeMFAError(c, err)
+		return
+	}
+	succeededAttempt(c, user.ID)
+
+	if err := mfaService.Disable(c, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+func RegenerateRecoveryCodesHandler(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code is required"})
+		return
+	}
+
+	raw, _ := c.Get("user_id")
+	userID := raw.(gocql.UUID)
+	if attemptsExceeded(c, userID) {
+		return
+	}
+	if err := mfaService.VerifyCode(c, userID, req.Code); err != nil {
+		if errors.Is(err, services.ErrInvalidMFACode) && failedAttempt(c, userID) {
+			return
+		}
+		writeMFAError(c, err)
+		return
+	}
+	succeededAttempt(c, userID)
+
+	codes, err := mfaService.RegenerateRecoveryCodes(c, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// MFALoginHandler is the second step of login for users with two-factor
+// authentication: it trades the challenge token from LoginHandler and a code
+// or recovery code for access and refresh tokens.
+func MFALoginHandler(c *gin.Context) {
+	var req struct {
+		MFAToken     string `json:"mfa_token" binding:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mfa_token is required"})
+		return
+	}
+
+	claims, err := actionTokenService.ParseActionToken(req.MFAToken, services.PurposeMFAChallenge)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired mfa_token"})
+		return
+	}
+
+	account := claims.UserID.String()
+	throttler := throttle.Default()
+	wait, err := throttler.Check(c, account, c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check login attempts"})
+		return
+	}
+	if wait > 0 {
+		writeTooManyAttempts(c, wait)
+		return
+	}
+
+	if err := verifySecondFactor(c, claims.UserID, req.Code, req.RecoveryCode); err != nil {
+		if !errors.Is(err, services.ErrInvalidMFACode) {
+			writeMFAError(c, err)
+			return
+		}
+		if wait, err := throttler.Failure(c, account, c.ClientIP()); err != nil {
+			log.Println("❌ Failed to record login failure:", err)
+		} else if wait > 0 {
+			writeTooManyAttempts(c, wait)
+			return
+		}
+		writeMFAError(c, services.ErrInvalidMFACode)
+		return
+	}
+
+	if err := actionTokenService.MarkActionTokenUsed(c, claims); err != nil {
+		if errors.Is(err, services.ErrActionTokenUsed) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired mfa_token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check token"})
+		return
+	}
+
+	user, err := userService.GetUserByID(c, claims.UserID)
+	if err != nil || services.PasswordHint(user.PasswordHash) != claims.PasswordHint {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired mfa_token"})
+		return
+	}
+
+	if err := throttler.Success(c, account); err != nil {
+		log.Println("❌ Failed to reset login attempts:", err)
+	}
+
+	completeLogin(c, user)
+}
+
+// verifySecondFactor accepts either a TOTP code or a recovery code.
+func verifySecondFactor(c *gin.Context, userID gocql.UUID, code, recoveryCode string) error {
+	switch {
+	case code != "":
+		return mfaService.VerifyCode(c, userID, code)
+	case recoveryCode != "":
+		return mfaService.UseRecoveryCode(c, userID, recoveryCode)
+	}
+	return services.ErrInvalidMFACode
+}
+
+// attemptsExceeded writes 429 and returns true while the signed-in user or
+// the client IP must wait. Checks of a password or code made by signed-in
+// users share the login budget of their account, so a stolen session cannot
+// guess them faster than a login could.
+func attemptsExceeded(c *gin.Context, userID gocql.UUID) bool {
+	wait, err := throttle.Default().Check(c, userID.String(), c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check login attempts"})
+		return true
+	}
+	if wait > 0 {
+		writeTooManyAttempts(c, wait)
+		return true
+	}
+	return false
+}
+
+// failedAttempt counts a wrong password or code. It writes 429 and returns
+// true once the failure makes the user wait.
+func failedAttempt(c *gin.Context, userID gocql.UUID) bool {
+	wait, err := throttle.Default().Failure(c, userID.String(), c.ClientIP())
+	if err != nil {
+		log.Println("❌ Failed to record login failure:", err)
+		return false
+	}
+	if wait > 0 {
+		writeTooManyAttempts(c, wait)
+		return true
+	}
+	return false
+}
+
+func succeededAttempt(c *gin.Context, userID gocql.UUID) {
+	if err := throttle.Default().Success(c, userID.String()); err != nil {
+		log.Println("❌ Failed to reset login attempts:", err)
+	}
+}
+
+func writeMFAError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidMFACode):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrMFAAlreadyEnabled),
+		errors.Is(err, services.ErrMFANotEnabled),
+		errors.Is(err, services.ErrMFANotEnrolling):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check two-factor code"})
+	}
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"go-fundraising/auth/throttle"
+	"go-fundraising/auth/totp"
+	"go-fundraising/db/dbtest"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocql/gocql"
+)
+
+// wrongCode is a well-formed TOTP code that is not the current one.
+func wrongCode(t *testing.T, secret string) string {
+	t.Helper()
+	code, err := totp.Code(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code == "000000" {
+		return "111111"
+	}
+	return "000000"
+}
+
+func currentCode(t *testing.T, secret string) string {
+	t.Helper()
+	code, err := totp.Code(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// assertThrottled sends bad until the account must wait and then checks that
+// good is refused too.
+func assertThrottled(t *testing.T, path string, userID gocql.UUID, bad, good interface{}) {
+	t.Helper()
+	browser := newBrowser(t)
+	free := throttle.PolicyFromEnv().FreeAttempts
+
+	for i := 0; i < free; i++ {
+		if status, body := call(t, browser, http.MethodPost, path, userID, bad); status != http.StatusUnauthorized {
+			t.Fatalf("attempt %d = %d %v, want 401", i+1, status, body)
+		}
+	}
+	if status, body := call(t, browser, http.MethodPost, path, userID, bad); status != http.StatusTooManyRequests {
+		t.Fatalf("attempt %d = %d %v, want 429", free+1, status, body)
+	}
+	if status, body := call(t, browser, http.MethodPost, path, userID, good); status != http.StatusTooManyRequests {
+		t.Fatalf("correct attempt while throttled = %d %v, want 429", status, body)
+	}
+}
+
+func TestConfirmMFAIsThrottled(t *testing.T) {
+	dbtest.Setup(t, "auth")
+	user := newTestUser(t, true)
+
+	secret, err := mfaService.StartEnrollment(context.Background(), user.ID)
+	if err != nil {
+		t.Fatalf("start enrollment: %v", err)
+	}
+	assertThrottled(t, "/auth/mfa/confirm", user.ID,
+		gin.H{"code": wrongCode(t, secret)},
+		gin.H{"code": currentCode(t, secret)})
+}
+
+func TestMFARecheckIsThrottled(t *testing.T) {
+	dbtest.Setup(t, "auth")
+	ctx := context.Background()
+
+	enrolled := func() (gocql.UUID, string) {
+		user := newTestUser(t, true)
+		secret, err := mfaService.StartEnrollment(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("start enrollment: %v", err)
+		}
+		if _, err := mfaService.ConfirmEnrollment(ctx, user.ID, currentCode(t, secret)); err != nil {
+			t.Fatalf("confirm enrollment: %v", err)
+		}
+		return user.ID, secret
+	}
+
+	t.Run("recovery codes", func(t *testing.T) {
+		userID, secret := enrolled()
+		assertThrottled(t, "/auth/mfa/recovery-codes", userID,
+			gin.H{"code": wrongCode(t, secret)},
+			gin.H{"code": currentCode(t, secret)})
+	})
+	t.Run("disable with wrong password", func(t *testing.T) {
+		userID, secret := enrolled()
+		assertThrottled(t, "/auth/mfa/disable", userID,
+			gin.H{"password": "wrong", "code": currentCode(t, secret)},
+			gin.H{"password": "correct horse", "code": currentCode(t, secret)})
+	})
+	t.Run("disable with wrong code", func(t *testing.T) {
+		userID, secret := enrolled()
+		assertThrottled(t, "/auth/mfa/disable", userID,
+			gin.H{"password": "correct horse", "code": wrongCode(t, secret)},
+			gin.H{"password": "correct horse", "code": currentCode(t, secret)})
+	})
+}
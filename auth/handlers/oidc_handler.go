@@ -0,0 +1,394 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"go-fundraising/auth/models"
+	"go-fundraising/auth/oidc"
+	"go-fundraising/auth/roles"
+	"go-fundraising/auth/services"
+	"go-fundraising/configs"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocql/gocql"
+)
+
+var identityService = services.IdentityService{}
+
+// OIDCLoginHandler sends the browser to the provider to sign in. An optional
+// login_hint is passed through.
+func OIDCLoginHandler(c *gin.Context) {
+	provider, ok := loadOIDCProvider(c)
+	if !ok {
+		return
+	}
+
+	authURL, ok := startOIDC(c, provider, models.OIDCState{})
+	if !ok {
+		return
+	}
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCLinkHandler starts linking another identity to the signed-in user. The
+// request carries a bearer token, so the URL is returned for the client to
+// open rather than redirected to. It must be opened in the browser that made
+// this request, which holds the state cookie the callback checks.
+func OIDCLinkHandler(c *gin.Context) {
+	provider, ok := loadOIDCProvider(c)
+	if !ok {
+		return
+	}
+
+	raw, _ := c.Get("user_id")
+	authURL, ok := startOIDC(c, provider, models.OIDCState{LinkUserID: raw.(gocql.UUID)})
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"authorization_url": authURL})
+}
+
+// OIDCReauthHandler starts a fresh sign-in at the provider for the signed-in
+// user, whose callback returns a short-lived reauth_token instead of a
+// session. Accounts without a password present it where others would give
+// their password. The URL is returned like OIDCLinkHandler's.
+func OIDCReauthHandler(c *gin.Context) {
+	provider, ok := loadOIDCProvider(c)
+	if !ok {
+		return
+	}
+
+	raw, _ := c.Get("user_id")
+	authURL, ok := startOIDC(c, provider, models.OIDCState{ReauthUserID: raw.(gocql.UUID)})
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"authorization_url": authURL})
+}
+
+// OIDCCallbackHandler finishes a sign-in, link or re-authentication started
+// by the handlers above. A sign-in responds like LoginHandler.
+func OIDCCallbackHandler(c *gin.Context) {
+	provider, ok := loadOIDCProvider(c)
+	if !ok {
+		return
+	}
+	if e := c.Query("error"); e != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sign-in failed at the provider: " + e})
+		return
+	}
+
+	// The state must come back to the browser that started the sign-in,
+	// or a victim could be made to finish an attacker's sign-in or link.
+	bound, _ := c.Cookie(oidcStateCookie)
+	clearOIDCStateCookie(c)
+	if bound == "" || subtle.ConstantTimeCompare([]byte(bound), []byte(c.Query("state"))) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrInvalidOIDCState.Error()})
+		return
+	}
+
+	state, err := identityService.ConsumeOIDCState(c, c.Query("state"))
+	if err != nil || state.Provider != provider.Name {
+		if err != nil && !errors.Is(err, services.ErrInvalidOIDCState) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check sign-in state"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrInvalidOIDCState.Error()})
+		return
+	}
+
+	claims, err := provider.Exchange(c, c.Query("code"), state.CodeVerifier, state.Nonce)
+	if err != nil {
+		log.Println("❌ OIDC exchange failed:", provider.Name, err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "could not verify sign-in with the provider"})
+		return
+	}
+
+	identity := models.UserIdentity{
+		Provider: provider.Name,
+		Subject:  claims.Subject,
+		Email:    services.NormalizeEmail(claims.Email),
+	}
+
+	if state.ReauthUserID != (gocql.UUID{}) {
+		completeReauth(c, state.ReauthUserID, identity)
+		return
+	}
+
+	if state.LinkUserID != (gocql.UUID{}) {
+		identity.UserID = state.LinkUserID
+		if err := identityService.LinkIdentity(c, identity); err != nil {
+			writeLinkError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":  "Identity linked",
+			"provider": identity.Provider,
+			"subject":  identity.Subject,
+		})
+		return
+	}
+
+	var user models.User
+	existing, err := identityService.GetIdentity(c, provider.Name, claims.Subject)
+	switch {
+	case err == nil:
+		user, err = userService.GetUserByID(c, existing.UserID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+			return
+		}
+	case errors.Is(err, gocql.ErrNotFound):
+		if user, ok = userForNewIdentity(c, identity, claims); !ok {
+			return
+		}
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch identity"})
+		return
+	}
+
+	if challengeMFA(c, user) {
+		return
+	}
+	completeLogin(c, user)
+}
+
+func GetIdentitiesHandler(c *gin.Context) {
+	raw, _ := c.Get("user_id")
+	identities, err := identityService.GetIdentitiesByUserID(c, raw.(gocql.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch identities"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"identities": identities})
+}
+
+// UnlinkIdentityHandler removes a linked identity, unless it is the only way
+// left to sign in.
+func UnlinkIdentityHandler(c *gin.Context) {
+	raw, _ := c.Get("user_id")
+	user, err := userService.GetUserByID(c, raw.(gocql.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+
+	if user.PasswordHash == "" {
+		identities, err := identityService.GetIdentitiesByUserID(c, user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch identities"})
+			return
+		}
+		if len(identities) <= 1 {
+			c.JSON(http.StatusConflict, gin.H{"error": "set a password before unlinking your only sign-in method"})
+			return
+		}
+	}
+
+	err = identityService.UnlinkIdentity(c, user.ID, c.Param("provider"), c.Param("subject"))
+	if err != nil {
+		if errors.Is(err, gocql.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "identity not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlink identity"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Identity unlinked"})
+}
+
+func loadOIDCProvider(c *gin.Context) (*oidc.Provider, bool) {
+	provider, err := oidc.Get(c.Param("provider"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return provider, true
+}
+
+// startOIDC records a pending sign-in and returns the provider URL for it.
+// state says what the sign-in is for; the rest of it is filled in here.
+func startOIDC(c *gin.Context, provider *oidc.Provider, state models.OIDCState) (string, bool) {
+	state.Provider = provider.Name
+	state.CreatedAt = time.Now()
+
+	var err error
+	for _, v := range []*string{&state.State, &state.Nonce, &state.CodeVerifier} {
+		if *v, err = oidc.RandomString(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sign-in"})
+			return "", false
+		}
+	}
+
+	authURL, err := provider.AuthCodeURL(c, state.State, state.Nonce, state.CodeVerifier, c.Query("login_hint"))
+	if err != nil {
+		log.Println("❌ OIDC discovery failed:", provider.Name, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "identity provider is unavailable"})
+		return "", false
+	}
+
+	if err := identityService.SaveOIDCState(c, state); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sign-in"})
+		return "", false
+	}
+	setOIDCStateCookie(c, state.State)
+	return authURL, true
+}
+
+// oidcStateCookie holds the state of the sign-in this browser started. It is
+// Lax so that it rides along on the provider's redirect back.
+const (
+	oidcStateCookie     = "oidc_state"
+	oidcStateCookiePath = "/auth/oidc"
+)
+
+func setOIDCStateCookie(c *gin.Context, state string) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, int(services.OIDCStateTTL.Seconds()), oidcStateCookiePath, "", secureCookies(), true)
+}
+
+func clearOIDCStateCookie(c *gin.Context) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, "", -1, oidcStateCookiePath, "", secureCookies(), true)
+}
+
+// secureCookies is set when the service is reached over https.
+func secureCookies() bool {
+	return strings.HasPrefix(configs.GetEnv("APP_HOST"), "https://")
+}
+
+// userForNewIdentity resolves the user behind an identity seen for the first
+// time. It links to an existing account only when both sides have verified
+// the email, and otherwise registers a new account without a password.
+func userForNewIdentity(c *gin.Context, identity models.UserIdentity, claims oidc.Claims) (models.User, bool) {
+	if identity.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "the provider did not share an email address"})
+		return models.User{}, false
+	}
+
+	user, err := userService.GetUserByEmail(c, identity.Email)
+	switch {
+	case err == nil:
+		if !claims.EmailVerified || !user.EmailVerified {
+			c.JSON(http.StatusConflict, gin.H{"error": "an account with this email already exists, sign in with your password and link this provider"})
+			return models.User{}, false
+		}
+	case errors.Is(err, gocql.ErrNotFound):
+		if user, err = registerOIDCUser(c, identity, claims); err != nil {
+			if errors.Is(err, services.ErrEmailTaken) {
+				c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register user"})
+			}
+			return models.User{}, false
+		}
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return models.User{}, false
+	}
+
+	identity.UserID = user.ID
+	if err := identityService.LinkIdentity(c, identity); err != nil {
+		writeLinkError(c, err)
+		return models.User{}, false
+	}
+	return user, true
+}
+
+func registerOIDCUser(c *gin.Context, identity models.UserIdentity, claims oidc.Claims) (models.User, error) {
+	user := models.User{
+		ID:            gocql.TimeUUID(),
+		Email:         identity.Email,
+		EmailVerified: claims.EmailVerified,
+		Roles:         roles.Default(),
+		CreatedAt:     time.Now(),
+	}
+
+	base := usernameBase(claims)
+	for attempt := 0; ; attempt++ {
+		user.Username = base
+		if attempt > 0 {
+			suffix := make([]byte, 2)
+			if _, err := rand.Read(suffix); err != nil {
+				return models.User{}, err
+			}
+			user.Username = base + "-" + hex.EncodeToString(suffix)
+		}
+
+		err := userService.NewUser(c, user)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, services.ErrUsernameTaken) || attempt == 5 {
+			return models.User{}, err
+		}
+	}
+
+	if user.EmailVerified {
+		if _, err := paymentService.ClaimGuestDonations(c, user.ID, user.Username, user.Email); err != nil {
+			log.Println("❌ Failed to claim guest donations for", user.ID, err)
+		}
+	}
+	return user, nil
+}
+
+// usernameBase derives a username from the provider's preferred username or
+// the email's local part, keeping only characters safe in a username.
+func usernameBase(claims oidc.Claims) string {
+	candidate := claims.PreferredUsername
+	if candidate == "" {
+		candidate = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+
+	var b strings.Builder
+	for _, r := range strings.ToLower(candidate) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '.' || r == '_' || r == '-' {
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() == 0 {
+		return "user"
+	}
+	return b.String()
+}
+
+// completeReauth issues a reauth_token once the provider has signed in an
+// identity that is linked to userID.
+func completeReauth(c *gin.Context, userID gocql.UUID, identity models.UserIdentity) {
+	existing, err := identityService.GetIdentity(c, identity.Provider, identity.Subject)
+	if err != nil && !errors.Is(err, gocql.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch identity"})
+		return
+	}
+	if err != nil || existing.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "this identity is not linked to your account"})
+		return
+	}
+
+	user, err := userService.GetUserByID(c, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+	reauthToken, err := actionTokenService.IssueActionToken(user, services.PurposeReauthenticate, services.ReauthenticateTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"reauth_token": reauthToken})
+}
+
+func writeLinkError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrIdentityLinked) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link identity"})
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"context"
+	"go-fundraising/auth/totp"
+	"go-fundraising/db/dbtest"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// signIn runs the fake provider's sign-in as email in browser and returns the
+// callback's response.
+func signIn(t *testing.T, browser *http.Client, email string) (int, map[string]interface{}) {
+	t.Helper()
+	return call(t, browser, http.MethodGet, "/auth/oidc/fake/login?login_hint="+url.QueryEscape(email), gocql.UUID{}, nil)
+}
+
+// startFlow asks for the URL of a link or reauth flow as userID from
+// browser.
+func startFlow(t *testing.T, browser *http.Client, flow string, userID gocql.UUID, email string) string {
+	t.Helper()
+	status, body := call(t, browser, http.MethodPost, "/auth/oidc/fake/"+flow+"?login_hint="+url.QueryEscape(email), userID, nil)
+	authURL, _ := body["authorization_url"].(string)
+	if status != http.StatusOK || authURL == "" {
+		t.Fatalf("start %s = %d %v", flow, status, body)
+	}
+	return authURL
+}
+
+func follow(t *testing.T, browser *http.Client, rawURL string) (int, map[string]interface{}) {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return call(t, browser, http.MethodGet, u.RequestURI(), gocql.UUID{}, nil)
+}
+
+func assertLinked(t *testing.T, userID gocql.UUID, want int) {
+	t.Helper()
+	identities, err := identityService.GetIdentitiesByUserID(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("get identities: %v", err)
+	}
+	if len(identities) != want {
+		t.Errorf("user has %d identities, want %d", len(identities), want)
+	}
+}
+
+func TestOIDCCallbackRequiresStateCookie(t *testing.T) {
+	callback, _ := url.Parse(testServer.URL + "/auth/oidc/fake/callback?code=c&state=s")
+
+	withoutCookie := newBrowser(t)
+	otherCookie := newBrowser(t)
+	otherCookie.Jar.SetCookies(callback, []*http.Cookie{{Name: oidcStateCookie, Value: "other", Path: oidcStateCookiePath}})
+
+	for name, browser := range map[string]*http.Client{"no cookie": withoutCookie, "other state": otherCookie} {
+		status, body := call(t, browser, http.MethodGet, callback.RequestURI(), gocql.UUID{}, nil)
+		if status != http.StatusBadRequest {
+			t.Errorf("%s: callback = %d %v, want 400", name, status, body)
+		}
+	}
+}
+
+func TestOIDCLoginRegistersNewUser(t *testing.T) {
+	dbtest.Setup(t, "auth")
+	email := "donor-" + gocql.TimeUUID().String() + "@example.com"
+
+	status, body := signIn(t, newBrowser(t), email)
+	if status != http.StatusOK || body["access_token"] == nil {
+		t.Fatalf("sign in = %d %v, want tokens", status, body)
+	}
+
+	user, err := userService.GetUserByEmail(context.Background(), email)
+	if err != nil {
+		t.Fatalf("get user: %v", err)
+	}
+	if !user.EmailVerified || user.PasswordHash != "" {
+		t.Errorf("registered user = %+v, want a verified email and no password", user)
+	}
+	assertLinked(t, user.ID, 1)
+
+	// Signing in again finds the same account through the identity.
+	if status, body := signIn(t, newBrowser(t), email); status != http.StatusOK {
+		t.Fatalf("second sign in = %d %v", status, body)
+	}
+	assertLinked(t, user.ID, 1)
+}
+
+func TestOIDCLoginLinksOnlyVerifiedEmail(t *testing.T) {
+	dbtest.Setup(t, "auth")
+
+	verified := newTestUser(t, true)
+	if status, body := signIn(t, newBrowser(t), verified.Email); status != http.StatusOK {
+		t.Fatalf("verified sign in = %d %v, want 200", status, body)
+	}
+	assertLinked(t, verified.ID, 1)
+
+	unverified := newTestUser(t, false)
+	if status, body := signIn(t, newBrowser(t), unverified.Email); status != http.StatusConflict {
+		t.Fatalf("unverified sign in = %d %v, want 409", status, body)
+	}
+	assertLinked(t, unverified.ID, 0)
+}
+
+func TestOIDCLinkFlow(t *testing.T) {
+	dbtest.Setup(t, "auth")
+	user := newTestUser(t, false)
+	email := "other-" + gocql.TimeUUID().String() + "@example.com"
+
+	browser := newBrowser(t)
+	status, body := follow(t, browser, startFlow(t, browser, "link", user.ID, email))
+	if status != http.StatusOK || body["message"] != "Identity linked" {
+		t.Fatalf("link = %d %v", status, body)
+	}
+	assertLinked(t, user.ID, 1)
+}
+
+func TestOIDCLinkURLIsBoundToBrowser(t *testing.T) {
+	dbtest.Setup(t, "auth")
+	user := newTestUser(t, false)
+	email := "victim-" + gocql.TimeUUID().String() + "@example.com"
+
+	// An attacker starts a link and gets a victim to open the URL.
+	authURL := startFlow(t, newBrowser(t), "link", user.ID, email)
+	if status, body := follow(t, newBrowser(t), authURL); status != http.StatusBadRequest {
+		t.Fatalf("link from another browser = %d %v, want 400", status, body)
+	}
+	assertLinked(t, user.ID, 0)
+}
+
+func TestOIDCLoginChallengesMFA(t *testing.T) {
+	dbtest.Setup(t, "auth")
+	ctx := context.Background()
+	user := newTestUser(t, true)
+
+	secret, err := mfaService.StartEnrollment(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("start enrollment: %v", err)
+	}
+	code, err := totp.Code(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfaService.ConfirmEnrollment(ctx, user.ID, code); err != nil {
+		t.Fatalf("confirm enrollment: %v", err)
+	}
+
+	status, body := signIn(t, newBrowser(t), user.Email)
+	if status != http.StatusOK || body["mfa_required"] != true || body["access_token"] != nil {
+		t.Fatalf("sign in = %d %v, want an MFA challenge", status, body)
+	}
+}
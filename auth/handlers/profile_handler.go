@@ -0,0 +1,446 @@
+package handlers
+
+import (
+	"errors"
+	"go-fundraising/auth/services"
+	campaign "go-fundraising/campaign/services"
+	"go-fundraising/configs"
+	"go-fundraising/mailer"
+	"go-fundraising/payment/models"
+	"go-fundraising/payment/providers"
+	payment "go-fundraising/payment/services"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocql/gocql"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var commentService = campaign.CommentService{}
+var recurringService = payment.RecurringService{}
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 500
+	maxAvatarURLLength   = 2048
+)
+
+// UpdateProfileHandler changes the fields that are sent and leaves the rest.
+func UpdateProfileHandler(c *gin.Context) {
+	var req struct {
+		DisplayName *string `json:"display_name"`
+		AvatarURL   *string `json:"avatar_url"`
+		Bio         *string `json:"bio"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	raw, _ := c.Get("user_id")
+	user, err := userService.GetUserByID(c, raw.(gocql.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+
+	if req.DisplayName != nil {
+		user.DisplayName = strings.TrimSpace(*req.DisplayName)
+		if utf8.RuneCountInString(user.DisplayName) > maxDisplayNameLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "display_name is too long"})
+			return
+		}
+	}
+	if req.Bio != nil {
+		user.Bio = strings.TrimSpace(*req.Bio)
+		if utf8.RuneCountInString(user.Bio) > maxBioLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bio is too long"})
+			return
+		}
+	}
+	if req.AvatarURL != nil {
+		user.AvatarURL = strings.TrimSpace(*req.AvatarURL)
+		if user.AvatarURL != "" && !isWebURL(user.AvatarURL) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "avatar_url must be an http or https URL"})
+			return
+		}
+	}
+
+	if err := userService.UpdateProfile(c, user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Profile updated",
+		"display_name": user.DisplayName,
+		"avatar_url":   user.AvatarURL,
+		"bio":          user.Bio,
+	})
+}
+
+// ChangePasswordHandler sets a new password and signs out every other
+// session. Accounts created through an identity provider have no password
+// yet; they prove who they are with a reauth_token from OIDCReauthHandler
+// instead of current_password. Accounts with two-factor authentication also
+// need a code or recovery code.
+func ChangePasswordHandler(c *gin.Context) {
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		ReauthToken     string `json:"reauth_token"`
+		Code            string `json:"code"`
+		RecoveryCode    string `json:"recovery_code"`
+		Password        string `json:"password" binding:"required"`
+		RePassword      string `json:"re_password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if req.RePassword != req.Password {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password and RePassword are not matching"})
+		return
+	}
+
+	raw, _ := c.Get("user_id")
+	user, err := userService.GetUserByID(c, raw.(gocql.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+
+	// The reauth_token is only spent once everything else has passed, so a
+	// mistyped code does not send the user back to the provider.
+	var reauth services.ActionClaims
+	if user.PasswordHash == "" {
+		reauth, err = actionTokenService.ParseActionToken(req.ReauthToken, services.PurposeReauthenticate)
+		if err != nil || reauth.UserID != user.ID || reauth.PasswordHint != services.PasswordHint(user.PasswordHash) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "sign in again with your identity provider to set a password"})
+			return
+		}
+	}
+
+	if attemptsExceeded(c, user.ID) {
+		return
+	}
+	if user.PasswordHash != "" {
+		if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.CurrentPassword)) != nil {
+			if failedAttempt(c, user.ID) {
+				return
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+			return
+		}
+	}
+
+	mfa, err := mfaService.GetMFA(c, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check two-factor authentication"})
+		return
+	}
+	if mfa.Enabled {
+		if err := verifySecondFactor(c, user.ID, req.Code, req.RecoveryCode); err != nil {
+			if errors.Is(err, services.ErrInvalidMFACode) && failedAttempt(c, user.ID) {
+				return
+			}
+			writeMFAError(c, err)
+			return
+		}
+	}
+	succeededAttempt(c, user.ID)
+
+	if user.PasswordHash == "" {
+		if err := actionTokenService.MarkActionTokenUsed(c, reauth); err != nil {
+			if errors.Is(err, services.ErrActionTokenUsed) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "sign in again with your identity provider to set a password"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check token"})
+			return
+		}
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Password hashing failed"})
+		return
+	}
+	if err := userService.UpdatePasswordHash(c, user.ID, string(hashedPassword)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+
+	// Tokens from before sessions existed have no session to keep.
+	var keep gocql.UUID
+	if sid, ok := c.Get("session_id"); ok {
+		keep = sid.(gocql.UUID)
+	}
+	if err := refreshTokenService.RevokeOtherSessions(c, user.ID, keep); err != nil {
+		log.Println("❌ Failed to revoke sessions after password change:", user.ID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed"})
+}
+
+// RequestEmailChangeHandler emails a confirmation link to the new address.
+// The account keeps its current email until the link is opened.
+func RequestEmailChangeHandler(c *gin.Context) {
+	var req struct {
+		Email    string `json:"email" binding:"required"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
+		return
+	}
+	addr, err := mail.ParseAddress(req.Email)
+	if err != nil || addr.Name != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email"})
+		return
+	}
+	newEmail := services.NormalizeEmail(addr.Address)
+
+	raw, _ := c.Get("user_id")
+	user, err := userService.GetUserByID(c, raw.(gocql.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+	if user.PasswordHash == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "set a password before changing your email"})
+		return
+	}
+	if attemptsExceeded(c, user.ID) {
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)) != nil {
+		if failedAttempt(c, user.ID) {
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+		return
+	}
+	succeededAttempt(c, user.ID)
+	if newEmail == services.NormalizeEmail(user.Email) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "that is already your email"})
+		return
+	}
+	if _, err := userService.GetUserByEmail(c, newEmail); err == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email already registered"})
+		return
+	} else if !errors.Is(err, gocql.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check email"})
+		return
+	}
+
+	tokenStr, err := actionTokenService.IssueEmailChangeToken(user, newEmail)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	link := configs.GetEnv("APP_HOST") + "/auth/email/confirm?token=" + url.QueryEscape(tokenStr)
+	err = mailer.Default().Send(c, mailer.Message{
+		To:      newEmail,
+		Subject: "Confirm your new email",
+		Body: "Hi " + user.Username + ",\n\n" +
+			"Confirm this as the new email address of your account by opening this link within 24 hours:\n\n" +
+			link + "\n",
+	})
+	if err != nil {
+		log.Println("❌ Failed to send email change confirmation:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send confirmation email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Confirmation sent to the new address"})
+}
+
+// ConfirmEmailChangeHandler accepts the token as ?token= from the emailed
+// link or as a JSON body.
+func ConfirmEmailChangeHandler(c *gin.Context) {
+	tokenStr := c.Query("token")
+	if tokenStr == "" {
+		var req struct {
+			Token string `json:"token" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+			return
+		}
+		tokenStr = req.Token
+	}
+
+	claims, err := actionTokenService.ConsumeActionToken(c, tokenStr, services.PurposeChangeEmail)
+	if err != nil {
+		writeActionTokenError(c, err)
+		return
+	}
+
+	user, err := userService.GetUserByID(c, claims.UserID)
+	if err != nil || user.Email != claims.Email || claims.NewEmail == "" ||
+		services.PasswordHint(user.PasswordHash) != claims.PasswordHint {
+		c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrInvalidActionToken.Error()})
+		return
+	}
+
+	if err := userService.ChangeEmail(c, user, claims.NewEmail); err != nil {
+		if errors.Is(err, services.ErrEmailTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change email"})
+		return
+	}
+
+	err = mailer.Default().Send(c, mailer.Message{
+		To:      user.Email,
+		Subject: "Your email was changed",
+		Body: "Hi " + user.Username + ",\n\n" +
+			"The email address of your account was changed to " + claims.NewEmail + ".\n" +
+			"If this was not you, reset your password and contact support.\n",
+	})
+	if err != nil {
+		log.Println("❌ Failed to notify old email address:", err)
+	}
+
+	// The new address is proven, so guest donations made with it are theirs.
+	if _, err := paymentService.ClaimGuestDonations(c, user.ID, user.Username, claims.NewEmail); err != nil {
+		log.Println("❌ Failed to claim guest donations for", user.ID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email changed", "email": claims.NewEmail})
+}
+
+// DeleteAccountHandler deletes the account. Comments, donations and campaigns
+// stay, with the username replaced and donor details removed, so campaign
+// totals and history are unaffected.
+func DeleteAccountHandler(c *gin.Context) {
+	var req struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+		Confirm      bool   `json:"confirm"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	raw, _ := c.Get("user_id")
+	user, err := userService.GetUserByID(c, raw.(gocql.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+
+	if attemptsExceeded(c, user.ID) {
+		return
+	}
+	if user.PasswordHash != "" {
+		if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)) != nil {
+			if failedAttempt(c, user.ID) {
+				return
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+			return
+		}
+	} else if !req.Confirm {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "confirm must be true to delete an account without a password"})
+		return
+	}
+
+	mfa, err := mfaService.GetMFA(c, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check two-factor authentication"})
+		return
+	}
+	if mfa.Enabled {
+		if err := verifySecondFactor(c, user.ID, req.Code, req.RecoveryCode); err != nil {
+			if errors.Is(err, services.ErrInvalidMFACode) && failedAttempt(c, user.ID) {
+				return
+			}
+			writeMFAError(c, err)
+			return
+		}
+	}
+	succeededAttempt(c, user.ID)
+
+	// Billing must stop before the account goes, or it would keep charging
+	// someone who can no longer sign in to cancel.
+	donations, err := recurringService.GetRecurringDonationsByUserID(c, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch recurring donations"})
+		return
+	}
+	for _, donation := range donations {
+		if donation.Status != models.RecurringStatusActive {
+			continue
+		}
+		if err := providers.Default().CancelSubscription(c, donation.SubscriptionID); err != nil {
+			log.Println("❌ Cancel subscription error:", err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "payment provider rejected cancelling a recurring donation"})
+			return
+		}
+		if _, err := recurringService.CancelRecurringDonation(c, donation.SubscriptionID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel recurring donation"})
+			return
+		}
+	}
+
+	// Anonymize first so a failure leaves an account that can retry.
+	if _, err := commentService.AnonymizeUserComments(c, user.ID, services.DeletedUsername); err != nil {
+		log.Println("❌ Failed to anonymize comments:", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+	verifiedEmail := ""
+	if user.EmailVerified {
+		verifiedEmail = user.Email
+	}
+	if _, err := paymentService.AnonymizeDonor(c, user.ID, verifiedEmail, services.DeletedUsername); err != nil {
+		log.Println("❌ Failed to anonymize payments:", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+	if _, err := campaignService.AnonymizeOwner(c, user.ID, services.DeletedUsername); err != nil {
+		log.Println("❌ Failed to anonymize campaigns:", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+
+	if err := refreshTokenService.RevokeAllSessions(c, user.ID); err != nil {
+		log.Println("❌ Failed to revoke sessions of deleted account:", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+	if err := mfaService.Disable(c, user.ID); err != nil {
+		log.Println("❌ Failed to remove two-factor authentication:", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+	if err := identityService.DeleteIdentities(c, user.ID); err != nil {
+		log.Println("❌ Failed to unlink identities:", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+	if err := userService.DeleteUser(c, user); err != nil {
+		log.Println("❌ Failed to delete user:", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account deleted"})
+}
+
+func isWebURL(raw string) bool {
+	if len(raw) > maxAvatarURLLength {
+		return false
+	}
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
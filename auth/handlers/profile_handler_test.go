@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"go-fundraising/db/dbtest"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocql/gocql"
+)
+
+func TestPasswordChecksAreThrottled(t *testing.T) {
+	dbtest.Setup(t, "auth")
+
+	cases := []struct {
+		name      string
+		path      string
+		bad, good func(user string) gin.H
+	}{
+		{
+			name: "change password",
+			path: "/auth/password/change",
+			bad: func(string) gin.H {
+				return gin.H{"current_password": "wrong", "password": "new horse", "re_password": "new horse"}
+			},
+			good: func(string) gin.H {
+				return gin.H{"current_password": "correct horse", "password": "new horse", "re_password": "new horse"}
+			},
+		},
+		{
+			name: "change email",
+			path: "/auth/email/change",
+			bad: func(user string) gin.H {
+				return gin.H{"email": "new-" + user + "@example.com", "password": "wrong"}
+			},
+			good: func(user string) gin.H {
+				return gin.H{"email": "new-" + user + "@example.com", "password": "correct horse"}
+			},
+		},
+		{
+			name: "delete account",
+			path: "/auth/account/delete",
+			bad:  func(string) gin.H { return gin.H{"password": "wrong"} },
+			good: func(string) gin.H { return gin.H{"password": "correct horse"} },
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			user := newTestUser(t, true)
+			assertThrottled(t, tc.path, user.ID, tc.bad(user.ID.String()), tc.good(user.ID.String()))
+		})
+	}
+}
+
+func TestSettingFirstPasswordNeedsReauth(t *testing.T) {
+	dbtest.Setup(t, "auth")
+	ctx := context.Background()
+	email := "donor-" + gocql.TimeUUID().String() + "@example.com"
+	browser := newBrowser(t)
+
+	if status, body := signIn(t, browser, email); status != http.StatusOK {
+		t.Fatalf("sign in = %d %v", status, body)
+	}
+	user, err := userService.GetUserByEmail(ctx, email)
+	if err != nil {
+		t.Fatalf("get user: %v", err)
+	}
+	change := gin.H{"password": "new horse", "re_password": "new horse"}
+
+	if status, body := call(t, browser, http.MethodPost, "/auth/password/change", user.ID, change); status != http.StatusUnauthorized {
+		t.Fatalf("change without reauth = %d %v, want 401", status, body)
+	}
+
+	// Someone else's identity does not re-authenticate this account.
+	other := "other-" + gocql.TimeUUID().String() + "@example.com"
+	if status, body := follow(t, browser, startFlow(t, browser, "reauth", user.ID, other)); status != http.StatusForbidden {
+		t.Fatalf("reauth as another identity = %d %v, want 403", status, body)
+	}
+
+	status, body := follow(t, browser, startFlow(t, browser, "reauth", user.ID, email))
+	reauthToken, _ := body["reauth_token"].(string)
+	if status != http.StatusOK || reauthToken == "" {
+		t.Fatalf("reauth = %d %v, want a reauth_token", status, body)
+	}
+
+	change["reauth_token"] = reauthToken
+	if status, body := call(t, browser, http.MethodPost, "/auth/password/change", user.ID, change); status != http.StatusOK {
+		t.Fatalf("change with reauth = %d %v, want 200", status, body)
+	}
+	if user, err = userService.GetUserByID(ctx, user.ID); err != nil || user.PasswordHash == "" {
+		t.Fatalf("password not set: %v", err)
+	}
+
+	// With a password set, the token no longer stands in for it.
+	if status, body := call(t, browser, http.MethodPost, "/auth/password/change", user.ID, change); status != http.StatusUnauthorized {
+		t.Fatalf("change replaying the reauth_token = %d %v, want 401", status, body)
+	}
+}
+
+func TestChangePasswordChecksSecondFactor(t *testing.T) {
+	dbtest.Setup(t, "auth")
+	ctx := context.Background()
+	user := newTestUser(t, true)
+
+	secret, err := mfaService.StartEnrollment(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("start enrollment: %v", err)
+	}
+	if _, err := mfaService.ConfirmEnrollment(ctx, user.ID, currentCode(t, secret)); err != nil {
+		t.Fatalf("confirm enrollment: %v", err)
+	}
+
+	browser := newBrowser(t)
+	change := gin.H{"current_password": "correct horse", "password": "new horse", "re_password": "new horse"}
+	if status, body := call(t, browser, http.MethodPost, "/auth/password/change", user.ID, change); status != http.StatusUnauthorized {
+		t.Fatalf("change without a code = %d %v, want 401", status, body)
+	}
+
+	// The code that confirmed enrollment cannot be used again.
+	recoveryCodes, err := mfaService.RegenerateRecoveryCodes(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("regenerate recovery codes: %v", err)
+	}
+	change["recovery_code"] = recoveryCodes[0]
+	if status, body := call(t, browser, http.MethodPost, "/auth/password/change", user.ID, change); status != http.StatusOK {
+		t.Fatalf("change with a recovery code = %d %v, want 200", status, body)
+	}
+}
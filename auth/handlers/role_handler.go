@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"errors"
+	"go-fundraising/auth/models"
+	"go-fundraising/auth/roles"
+	"go-fundraising/auth/services"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocql/gocql"
+)
+
+var roleService = services.RoleService{}
+
+type RoleChangeRequest struct {
+	Role   string `json:"role" binding:"required"`
+	Reason string `json:"reason"`
+}
+
+func GetUserRolesHandler(c *gin.Context) {
+	user, ok := loadRoleTarget(c)
+	if !ok {
+		return
+	}
+
+	history, err := roleService.GetRoleChanges(c, user.ID, 50)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch role history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id": user.ID,
+		"roles":   roles.Effective(user.Roles),
+		"history": history,
+	})
+}
+
+// GrantRoleHandler adds a role. The user's access tokens pick it up on their
+// next refresh.
+func GrantRoleHandler(c *gin.Context) {
+	var req RoleChangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role is required"})
+		return
+	}
+
+	target, ok := loadRoleTarget(c)
+	if !ok {
+		return
+	}
+
+	raw, _ := c.Get("user_id")
+	actorID := raw.(gocql.UUID)
+
+	user, err := roleService.GrantRole(c, actorID, target.ID, req.Role, req.Reason)
+	if err != nil && !errors.Is(err, services.ErrRoleNotChanged) {
+		writeRoleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id": user.ID,
+		"roles":   roles.Effective(user.Roles),
+		"changed": err == nil,
+	})
+}
+
+// RevokeRoleHandler removes a role and signs the user out everywhere, since
+// their access tokens would otherwise keep the role until they expire.
+func RevokeRoleHandler(c *gin.Context) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	// The body is optional on DELETE.
+	_ = c.ShouldBindJSON(&req)
+
+	target, ok := loadRoleTarget(c)
+	if !ok {
+		return
+	}
+
+	raw, _ := c.Get("user_id")
+	actorID := raw.(gocql.UUID)
+
+	user, err := roleService.RevokeRole(c, actorID, target.ID, c.Param("role"), req.Reason)
+	if err != nil && !errors.Is(err, services.ErrRoleNotChanged) {
+		writeRoleError(c, err)
+		return
+	}
+	changed := err == nil
+
+	if changed {
+		if err := refreshTokenService.RevokeAllSessions(c, user.ID); err != nil {
+			log.Println("❌ Failed to revoke sessions after role change:", user.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id": user.ID,
+		"roles":   roles.Effective(user.Roles),
+		"changed": changed,
+	})
+}
+
+func loadRoleTarget(c *gin.Context) (models.User, bool) {
+	userID, err := gocql.ParseUUID(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid UUID format"})
+		return models.User{}, false
+	}
+
+	user, err := userService.GetUserByID(c, userID)
+	if err != nil {
+		if errors.Is(err, gocql.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		}
+		return models.User{}, false
+	}
+	return user, true
+}
+
+func writeRoleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrUnknownRole):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrBaseRole), errors.Is(err, services.ErrSelfDemotion):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update roles"})
+	}
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocql/gocql"
+)
+
+func GetSessionsHandler(c *gin.Context) {
+	raw, _ := c.Get("user_id")
+	userID := raw.(gocql.UUID)
+
+	sessions, err := refreshTokenService.GetActiveSessions(c, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sessions"})
+		return
+	}
+
+	current, _ := c.Get("session_id")
+	result := make([]gin.H, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, gin.H{
+			"id":           session.FamilyID,
+			"user_agent":   session.UserAgent,
+			"ip_address":   session.IPAddress,
+			"created_at":   session.CreatedAt,
+			"last_used_at": session.LastUsedAt,
+			"expires_at":   session.ExpiresAt,
+			"current":      current == session.FamilyID,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": result})
+}
+
+func RevokeSessionHandler(c *gin.Context) {
+	sessionID, err := gocql.ParseUUID(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid session_id"})
+		return
+	}
+
+	session, err := refreshTokenService.GetRefreshTokenFamily(c, sessionID)
+	if err != nil {
+		if errors.Is(err, gocql.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch session"})
+		}
+		return
+	}
+
+	raw, _ := c.Get("user_id")
+	if session.UserID != raw.(gocql.UUID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	if err := refreshTokenService.RevokeRefreshTokenFamily(c, sessionID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+// RevokeAllSessionsHandler logs the user out everywhere, including the
+// session making the request.
+func RevokeAllSessionsHandler(c *gin.Context) {
+	raw, _ := c.Get("user_id")
+	userID := raw.(gocql.UUID)
+
+	if err := refreshTokenService.RevokeAllSessions(c, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out everywhere"})
+}
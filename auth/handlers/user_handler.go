@@ -1,12 +1,18 @@
 package handlers
 
 import (
+	"errors"
 	"go-fundraising/auth/models"
+	"go-fundraising/auth/roles"
 	"go-fundraising/auth/services"
+	"go-fundraising/auth/throttle"
 	campaign "go-fundraising/campaign/services"
+	"go-fundraising/token"
+	"log"
 	"net/http"
-	"os"
+	"net/mail"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -16,6 +22,8 @@ import (
 )
 
 var userService = services.UserService{}
+var refreshTokenService = services.RefreshTokenService{}
+var loginAuditService = services.LoginAuditService{}
 var campaignService = campaign.CampaignService{}
 
 func CreateUserHandler(c *gin.Context) {
@@ -30,13 +38,17 @@ func CreateUserHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
+	addr, err := mail.ParseAddress(request.Email)
+	if err != nil || addr.Name != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email"})
+		return
+	}
 	if request.RePassword != request.Password {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Password and RePassword are not matching"})
 		return
 	}
-	exists, err := userService.CheckUsernameExists(c, request.Username)
-	if exists {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Username already taken"})
+	if strings.TrimSpace(request.Username) == "" || strings.Contains(request.Username, "@") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid username"})
 		return
 	}
 
@@ -49,14 +61,26 @@ func CreateUserHandler(c *gin.Context) {
 	user := models.User{
 		ID:           gocql.TimeUUID(),
 		Username:     request.Username,
-		Email:        request.Email,
+		Email:        addr.Address,
 		PasswordHash: string(hashedPassword),
+		Roles:        roles.Default(),
 		CreatedAt:    time.Now(),
 	}
 	if err := userService.NewUser(c, user); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register user"})
+		switch {
+		case errors.Is(err, services.ErrUsernameTaken):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Username already taken"})
+		case errors.Is(err, services.ErrEmailTaken):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Email already registered"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register user"})
+		}
 		return
 	}
+	user.Email = services.NormalizeEmail(user.Email)
+	if err := sendVerificationEmail(c, user); err != nil {
+		log.Println("❌ Failed to send verification email:", err)
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"message": "User created",
 		"user":    user,
@@ -66,6 +90,7 @@ func CreateUserHandler(c *gin.Context) {
 func LoginHandler(c *gin.Context) {
 	var req struct {
 		Username string `json:"username"`
+		Email    string `json:"email"`
 		Password string `json:"password"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -73,44 +98,124 @@ func LoginHandler(c *gin.Context) {
 		return
 	}
 
-	user, err := userService.GetUserByUsername(c, req.Username)
+	// Usernames cannot contain "@", so either field may hold either one.
+	login := req.Username
+	if login == "" {
+		login = req.Email
+	}
+
+	var user models.User
+	var err error
+	if strings.Contains(login, "@") {
+		user, err = userService.GetUserByEmail(c, login)
+	} else {
+		user, err = userService.GetUserByUsername(c, login)
+	}
+	if err != nil && !errors.Is(err, gocql.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+
+	// Known accounts are throttled by ID so their username and email share
+	// one budget; unknown names are throttled too, so both look the same.
+	account := "login:" + strings.ToLower(strings.TrimSpace(login))
+	if err == nil {
+		account = user.ID.String()
+	}
+
+	throttler := throttle.Default()
+	wait, err := throttler.Check(c, account, c.ClientIP())
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check login attempts"})
+		return
+	}
+	if wait > 0 {
+		writeTooManyAttempts(c, wait)
 		return
 	}
 
-	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)) != nil {
+	if user.ID == (gocql.UUID{}) || bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)) != nil {
+		reason := services.LoginFailureBadPassword
+		if user.ID == (gocql.UUID{}) {
+			reason = services.LoginFailureUnknownAccount
+		}
+		if err := loginAuditService.RecordLoginFailure(c, models.LoginFailure{
+			Account:   account,
+			UserID:    user.ID,
+			IPAddress: c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+			Reason:    reason,
+		}); err != nil {
+			log.Println("❌ Failed to audit login failure:", err)
+		}
+
+		wait, err := throttler.Failure(c, account, c.ClientIP())
+		if err != nil {
+			log.Println("❌ Failed to record login failure:", err)
+		}
+		if wait > 0 {
+			writeTooManyAttempts(c, wait)
+			return
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
 		return
 	}
-	jwtKey := []byte(os.Getenv("JWT_SECRET"))
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": user.ID.String(),
-		"exp":     time.Now().Add(2 * time.Hour).Unix(),
-	})
 
-	accessTokenString, err := accessToken.SignedString(jwtKey)
+	// Failures stay counted until the second step succeeds, so knowing the
+	// password does not reset the budget for guessing codes.
+	if challengeMFA(c, user) {
+		return
+	}
+
+	if err := throttler.Success(c, account); err != nil {
+		log.Println("❌ Failed to reset login attempts:", err)
+	}
+
+	completeLogin(c, user)
+}
+
+// challengeMFA responds with an MFA challenge token when the user has
+// two-factor authentication enabled, and reports whether it responded.
+func challengeMFA(c *gin.Context, user models.User) bool {
+	mfa, err := mfaService.GetMFA(c, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check two-factor authentication"})
+		return true
+	}
+	if !mfa.Enabled {
+		return false
+	}
+
+	mfaToken, err := actionTokenService.IssueActionToken(user, services.PurposeMFAChallenge, services.MFAChallengeTTL)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
-		return
+		return true
 	}
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": user.ID.String(),
-		"exp":     time.Now().Add(7 * 24 * time.Hour).Unix(),
+	c.JSON(http.StatusOK, gin.H{
+		"mfa_required": true,
+		"mfa_token":    mfaToken,
 	})
-	refreshTokenString, _ := refreshToken.SignedString(jwtKey)
-	refreshTokenExpiry := time.Now().Add(7 * 24 * time.Hour)
-	err = userService.SaveRefreshToken(c, refreshTokenString, user.ID, refreshTokenExpiry)
+	return true
+}
+
+// completeLogin starts a session for an authenticated user and responds with
+// its tokens.
+func completeLogin(c *gin.Context, user models.User) {
+	refreshTokenString, sessionID, err := refreshTokenService.NewRefreshTokenFamily(c, user.ID, c.Request.UserAgent(), c.ClientIP())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save token"})
 		return
 	}
+	accessTokenString, err := issueAccessToken(user, sessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
 
 	c.JSON(200, gin.H{
 		"access_token":  accessTokenString,
 		"refresh_token": refreshTokenString,
 	})
-
 }
 
 func RefreshHandler(c *gin.Context) {
@@ -122,28 +227,28 @@ func RefreshHandler(c *gin.Context) {
 		return
 	}
 
-	userID, err := userService.ValidateRefreshToken(c, req.RefreshToken)
+	row, refreshTokenString, err := refreshTokenService.RotateRefreshToken(c, req.RefreshToken, c.Request.UserAgent(), c.ClientIP())
 	if err != nil {
-		c.JSON(401, gin.H{"error": "invalid or expired refresh token"})
+		switch {
+		case errors.Is(err, services.ErrRefreshTokenReused):
+			c.JSON(401, gin.H{"error": "refresh token reuse detected, please sign in again"})
+		case errors.Is(err, services.ErrInvalidRefreshToken):
+			c.JSON(401, gin.H{"error": "invalid or expired refresh token"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		}
 		return
 	}
-	_ = userService.DeleteRefreshToken(c, req.RefreshToken)
-	jwtKey := []byte(os.Getenv("JWT_SECRET"))
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userID.String(),
-		"exp":     time.Now().Add(2 * time.Hour).Unix(),
-	})
-	accessTokenString, _ := accessToken.SignedString(jwtKey)
 
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userID.String(),
-		"exp":     time.Now().Add(7 * 24 * time.Hour).Unix(),
-	})
-	refreshTokenString, _ := refreshToken.SignedString(jwtKey)
-	refreshTokenExpiry := time.Now().Add(7 * 24 * time.Hour)
-	err = userService.SaveRefreshToken(c, refreshTokenString, userID, refreshTokenExpiry)
+	// Roles are read again so grants and revocations reach the new token.
+	user, err := userService.GetUserByID(c, row.UserID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save token"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+	accessTokenString, err := issueAccessToken(user, row.FamilyID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
@@ -153,6 +258,30 @@ func RefreshHandler(c *gin.Context) {
 	})
 }
 
+func writeTooManyAttempts(c *gin.Context, wait time.Duration) {
+	seconds := int(wait.Round(time.Second) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       "too many failed login attempts, try again later",
+		"retry_after": seconds,
+	})
+}
+
+// issueAccessToken signs a short-lived access token for the session; the
+// "sid" claim lets AuthMiddleware reject it once the session is revoked, and
+// "roles" are what the role middleware checks.
+func issueAccessToken(user models.User, sessionID gocql.UUID) (string, error) {
+	return token.Issue(jwt.MapClaims{
+		"user_id": user.ID.String(),
+		"sid":     sessionID.String(),
+		"roles":   roles.Effective(user.Roles),
+		"exp":     time.Now().Add(2 * time.Hour).Unix(),
+	})
+}
+
 func LogoutHandler(c *gin.Context) {
 	var req struct {
 		RefreshToken string `json:"refresh_token" binding:"required"`
@@ -163,16 +292,9 @@ func LogoutHandler(c *gin.Context) {
 		return
 	}
 
-	refreshToken := req.RefreshToken
-
-	_, err := userService.ValidateRefreshToken(c, refreshToken)
-	if err != nil {
-		c.JSON(http.StatusOK, gin.H{"message": "logged out"})
-		return
-	}
-
-	if err := userService.DeleteRefreshToken(c, refreshToken); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete refresh token"})
+	err := refreshTokenService.RevokeRefreshToken(c, req.RefreshToken)
+	if err != nil && !errors.Is(err, services.ErrInvalidRefreshToken) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke refresh token"})
 		return
 	}
 
@@ -209,10 +331,21 @@ func CurrentUserHandler(c *gin.Context) {
 	listCampaign, err := campaignService.GetCampaignByUserID(c, user.ID.String(), page, perPage)
 
 	c.JSON(http.StatusOK, gin.H{
-		"id":         user.ID.String(),
-		"email":      user.Email,
-		"username":   user.Username,
-		"created_at": user.CreatedAt,
-		"campaigns":  listCampaign,
+		"id":             user.ID.String(),
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+		"username":       user.Username,
+		"display_name":   user.DisplayName,
+		"avatar_url":     user.AvatarURL,
+		"bio":            user.Bio,
+		"roles":          roles.Effective(user.Roles),
+		"created_at":     user.CreatedAt,
+		"campaigns":      listCampaign,
 	})
 }
+
+// JWKSHandler publishes the public signing keys for other services.
+func JWKSHandler(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, token.Keys().JWKS())
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"github.com/scylladb/gocqlx/table"
+)
+
+// UsedActionToken marks an email verification or password reset token as
+// spent. Rows expire with the token they mark.
+type UsedActionToken struct {
+	ID     string    `db:"id"`
+	UsedAt time.Time `db:"used_at"`
+}
+
+var UsedActionTokenTable = table.Metadata{
+	Name:    "used_action_tokens",
+	Columns: []string{"id", "used_at"},
+	PartKey: []string{"id"},
+}
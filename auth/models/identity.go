@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/table"
+)
+
+// UserIdentity links an account at an external OpenID Connect provider to a
+// user. Subject is the provider's stable id for the account.
+type UserIdentity struct {
+	Provider  string     `db:"provider"`
+	Subject   string     `db:"subject"`
+	UserID    gocql.UUID `db:"user_id"`
+	Email     string     `db:"email"`
+	CreatedAt time.Time  `db:"created_at"`
+}
+
+var UserIdentityTable = table.Metadata{
+	Name:    "user_identities",
+	Columns: []string{"provider", "subject", "user_id", "email", "created_at"},
+	PartKey: []string{"provider", "subject"},
+}
+
+// OIDCState is a sign-in in progress, from the redirect to the provider
+// until its callback. LinkUserID is set when a signed-in user is linking
+// another identity rather than signing in, and ReauthUserID when they are
+// proving again that they own one of their linked identities.
+type OIDCState struct {
+	State        string     `db:"state"`
+	Provider     string     `db:"provider"`
+	CodeVerifier string     `db:"code_verifier"`
+	Nonce        string     `db:"nonce"`
+	LinkUserID   gocql.UUID `db:"link_user_id"`
+	ReauthUserID gocql.UUID `db:"reauth_user_id"`
+	CreatedAt    time.Time  `db:"created_at"`
+}
+
+var OIDCStateTable = table.Metadata{
+	Name:    "oidc_states",
+	Columns: []string{"state", "provider", "code_verifier", "nonce", "link_user_id", "reauth_user_id", "created_at"},
+	PartKey: []string{"state"},
+}
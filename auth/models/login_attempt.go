@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/table"
+)
+
+// LoginAttempt is the throttling state of one account or IP when login
+// throttling is kept in Scylla. Rows expire once they stop mattering.
+type LoginAttempt struct {
+	Key          string    `db:"key"`
+	Failures     int       `db:"failures"`
+	LastFailure  time.Time `db:"last_failure"`
+	BlockedUntil time.Time `db:"blocked_until"`
+}
+
+var LoginAttemptTable = table.Metadata{
+	Name:    "login_attempts",
+	Columns: []string{"key", "failures", "last_failure", "blocked_until"},
+	PartKey: []string{"key"},
+}
+
+// LoginFailure is the audit record of one failed login, newest first per
+// account.
+type LoginFailure struct {
+	Account   string     `db:"account"`
+	ID        gocql.UUID `db:"id"`
+	UserID    gocql.UUID `db:"user_id"`
+	IPAddress string     `db:"ip_address"`
+	UserAgent string     `db:"user_agent"`
+	Reason    string     `db:"reason"`
+	CreatedAt time.Time  `db:"created_at"`
+}
+
+var LoginFailureTable = table.Metadata{
+	Name:    "login_failures",
+	Columns: []string{"account", "id", "user_id", "ip_address", "user_agent", "reason", "created_at"},
+	PartKey: []string{"account"},
+	SortKey: []string{"id"},
+}
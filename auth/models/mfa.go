@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/table"
+)
+
+// UserMFA is a user's TOTP enrollment. PendingSecret holds a secret until a
+// code from it is confirmed; LastUsedStep is the time step of the last code
+// accepted, so a code cannot be replayed.
+type UserMFA struct {
+	UserID        gocql.UUID `db:"user_id"`
+	Secret        string     `db:"secret"`
+	PendingSecret string     `db:"pending_secret"`
+	Enabled       bool       `db:"enabled"`
+	LastUsedStep  int64      `db:"last_used_step"`
+	EnabledAt     time.Time  `db:"enabled_at"`
+}
+
+var UserMFATable = table.Metadata{
+	Name:    "user_mfa",
+	Columns: []string{"user_id", "secret", "pending_secret", "enabled", "last_used_step", "enabled_at"},
+	PartKey: []string{"user_id"},
+}
+
+// MFARecoveryCode is one single-use recovery code, stored hashed.
+type MFARecoveryCode struct {
+	UserID    gocql.UUID `db:"user_id"`
+	CodeHash  string     `db:"code_hash"`
+	UsedAt    time.Time  `db:"used_at"`
+	CreatedAt time.Time  `db:"created_at"`
+}
+
+var MFARecoveryCodeTable = table.Metadata{
+	Name:    "mfa_recovery_codes",
+	Columns: []string{"user_id", "code_hash", "used_at", "created_at"},
+	PartKey: []string{"user_id"},
+	SortKey: []string{"code_hash"},
+}
@@ -7,14 +7,40 @@ import (
 	"github.com/scylladb/gocqlx/table"
 )
 
+// RefreshToken is stored under the SHA-256 hash of the token the client
+// holds, never the token itself. RotatedAt is set once the token has been
+// exchanged; seeing it again means the token was replayed.
 type RefreshToken struct {
-	UserId    gocql.UUID `db:"user_id"`
-	Token     string     `db:"refresh_token"`
+	TokenHash string     `db:"token_hash"`
+	UserID    gocql.UUID `db:"user_id"`
+	FamilyID  gocql.UUID `db:"family_id"`
 	ExpiresAt time.Time  `db:"expires_at"`
+	RotatedAt time.Time  `db:"rotated_at"`
+	CreatedAt time.Time  `db:"created_at"`
 }
 
 var RefreshTokenTable = table.Metadata{
 	Name:    "refresh_tokens",
-	Columns: []string{"user_id", "refresh_token", "expires_at"},
-	PartKey: []string{"refresh_token"},
+	Columns: []string{"token_hash", "user_id", "family_id", "expires_at", "rotated_at", "created_at"},
+	PartKey: []string{"token_hash"},
+}
+
+// RefreshTokenFamily groups every refresh token descended from one login and
+// is the user's session on one device: access tokens carry its ID as "sid".
+// Revoking the family invalidates all of them at once.
+type RefreshTokenFamily struct {
+	FamilyID   gocql.UUID `db:"family_id"`
+	UserID     gocql.UUID `db:"user_id"`
+	Revoked    bool       `db:"revoked"`
+	UserAgent  string     `db:"user_agent"`
+	IPAddress  string     `db:"ip_address"`
+	CreatedAt  time.Time  `db:"created_at"`
+	LastUsedAt time.Time  `db:"last_used_at"`
+	ExpiresAt  time.Time  `db:"expires_at"`
+}
+
+var RefreshTokenFamilyTable = table.Metadata{
+	Name:    "refresh_token_families",
+	Columns: []string{"family_id", "user_id", "revoked", "user_agent", "ip_address", "created_at", "last_used_at", "expires_at"},
+	PartKey: []string{"family_id"},
 }
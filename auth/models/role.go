@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/table"
+)
+
+const (
+	RoleChangeGrant  = "grant"
+	RoleChangeRevoke = "revoke"
+)
+
+// RoleChange is the audit record of a role being granted or revoked, newest
+// first per user.
+type RoleChange struct {
+	UserID    gocql.UUID `db:"user_id"`
+	ID        gocql.UUID `db:"id"`
+	Action    string     `db:"action"`
+	Role      string     `db:"role"`
+	ActorID   gocql.UUID `db:"actor_id"`
+	Reason    string     `db:"reason"`
+	CreatedAt time.Time  `db:"created_at"`
+}
+
+var RoleChangeTable = table.Metadata{
+	Name:    "role_changes",
+	Columns: []string{"user_id", "id", "action", "role", "actor_id", "reason", "created_at"},
+	PartKey: []string{"user_id"},
+	SortKey: []string{"id"},
+}
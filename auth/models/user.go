@@ -8,15 +8,44 @@ import (
 )
 
 type User struct {
-	ID           gocql.UUID `db:"id"`
-	Email        string     `db:"email"`
-	Username     string     `db:"username"`
-	PasswordHash string     `db:"password_hash"`
-	CreatedAt    time.Time  `db:"created_at"`
+	ID            gocql.UUID `db:"id"`
+	Email         string     `db:"email"`
+	Username      string     `db:"username"`
+	PasswordHash  string     `db:"password_hash"`
+	EmailVerified bool       `db:"email_verified"`
+	Roles         []string   `db:"roles"`
+	DisplayName   string     `db:"display_name"`
+	AvatarURL     string     `db:"avatar_url"`
+	Bio           string     `db:"bio"`
+	CreatedAt     time.Time  `db:"created_at"`
 }
 
 var UserTable = table.Metadata{
 	Name:    "users",
-	Columns: []string{"id", "email", "username", "password_hash", "created_at"},
+	Columns: []string{"id", "email", "username", "password_hash", "email_verified", "roles", "display_name", "avatar_url", "bio", "created_at"},
 	PartKey: []string{"id"},
 }
+
+// UserByUsername and UserByEmail claim a normalized username or email for a
+// user with IF NOT EXISTS, which is what makes them unique.
+type UserByUsername struct {
+	Username string     `db:"username"`
+	UserID   gocql.UUID `db:"user_id"`
+}
+
+var UserByUsernameTable = table.Metadata{
+	Name:    "users_by_username",
+	Columns: []string{"username", "user_id"},
+	PartKey: []string{"username"},
+}
+
+type UserByEmail struct {
+	Email  string     `db:"email"`
+	UserID gocql.UUID `db:"user_id"`
+}
+
+var UserByEmailTable = table.Metadata{
+	Name:    "users_by_email",
+	Columns: []string{"email", "user_id"},
+	PartKey: []string{"email"},
+}
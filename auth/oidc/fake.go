@@ -0,0 +1,245 @@
+package oidc
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"go-fundraising/configs"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	fakeClientID     = "fake-client"
+	fakeClientSecret = "fake-secret"
+	fakeKeyID        = "fake"
+	fakeCodeTTL      = time.Minute
+)
+
+func FakeEnabled() bool {
+	return configs.GetEnv("OIDC_FAKE_ISSUER") == "true"
+}
+
+func FakeIssuerURL() string {
+	return configs.GetEnv("APP_HOST") + "/oidc/fake"
+}
+
+// FakeIssuer is a stand-in OpenID Connect issuer for development and tests.
+// It signs in whoever /authorize is asked for through login_hint without a
+// password, and otherwise behaves like a real issuer: discovery, PKCE,
+// single-use codes and signed ID tokens.
+type FakeIssuer struct {
+	issuer  string
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+	mux     *http.ServeMux
+
+	mu    sync.Mutex
+	codes map[string]fakeCode
+}
+
+type fakeCode struct {
+	email       string
+	redirectURI string
+	nonce       string
+	challenge   string
+	expiresAt   time.Time
+}
+
+var (
+	fakeIssuer     *FakeIssuer
+	fakeIssuerOnce sync.Once
+)
+
+// Fake returns the issuer served under /oidc/fake.
+func Fake() *FakeIssuer {
+	fakeIssuerOnce.Do(func() {
+		fakeIssuer = NewFakeIssuer(FakeIssuerURL())
+	})
+	return fakeIssuer
+}
+
+func NewFakeIssuer(issuer string) *FakeIssuer {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	f := &FakeIssuer{
+		issuer:  issuer,
+		private: private,
+		public:  public,
+		mux:     http.NewServeMux(),
+		codes:   map[string]fakeCode{},
+	}
+	f.mux.HandleFunc("/.well-known/openid-configuration", f.discovery)
+	f.mux.HandleFunc("/authorize", f.authorize)
+	f.mux.HandleFunc("/token", f.token)
+	f.mux.HandleFunc("/jwks", f.jwks)
+	return f
+}
+
+// ServeHTTP expects paths relative to the issuer URL.
+func (f *FakeIssuer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mux.ServeHTTP(w, r)
+}
+
+func (f *FakeIssuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                f.issuer,
+		"authorization_endpoint":                f.issuer + "/authorize",
+		"token_endpoint":                        f.issuer + "/token",
+		"jwks_uri":                              f.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"EdDSA"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (f *FakeIssuer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != fakeClientID || q.Get("response_type") != "code" {
+		http.Error(w, "invalid client_id or response_type", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	addr, err := mail.ParseAddress(q.Get("login_hint"))
+	if err != nil {
+		http.Error(w, "pass login_hint=<email> to choose who signs in", http.StatusBadRequest)
+		return
+	}
+
+	code, err := RandomString()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	f.mu.Lock()
+	for c, pending := range f.codes {
+		if time.Now().After(pending.expiresAt) {
+			delete(f.codes, c)
+		}
+	}
+	f.codes[code] = fakeCode{
+		email:       strings.ToLower(addr.Address),
+		redirectURI: redirectURI.String(),
+		nonce:       q.Get("nonce"),
+		challenge:   q.Get("code_challenge"),
+		expiresAt:   time.Now().Add(fakeCodeTTL),
+	}
+	f.mu.Unlock()
+
+	back := redirectURI.Query()
+	back.Set("code", code)
+	back.Set("state", q.Get("state"))
+	redirectURI.RawQuery = back.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (f *FakeIssuer) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	if clientID != fakeClientID || subtle.ConstantTimeCompare([]byte(secret), []byte(fakeClientSecret)) != 1 {
+		writeTokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		writeTokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	f.mu.Lock()
+	code, found := f.codes[r.PostFormValue("code")]
+	delete(f.codes, r.PostFormValue("code"))
+	f.mu.Unlock()
+
+	if !found || time.Now().After(code.expiresAt) || code.redirectURI != r.PostFormValue("redirect_uri") {
+		writeTokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+	if CodeChallenge(r.PostFormValue("code_verifier")) != code.challenge {
+		writeTokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	// The subject is stable per email, like a real issuer's user id.
+	sum := sha256.Sum256([]byte(code.email))
+	now := time.Now()
+	t := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
+		"iss":                f.issuer,
+		"sub":                hex.EncodeToString(sum[:16]),
+		"aud":                fakeClientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"nonce":              code.nonce,
+		"email":              code.email,
+		"email_verified":     true,
+		"preferred_username": strings.SplitN(code.email, "@", 2)[0],
+	})
+	t.Header["kid"] = fakeKeyID
+	idToken, err := t.SignedString(f.private)
+	if err != nil {
+		writeTokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "fake-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (f *FakeIssuer) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"kid": fakeKeyID,
+			"use": "sig",
+			"alg": "EdDSA",
+			"x":   base64.RawURLEncoding.EncodeToString(f.public),
+		}},
+	})
+}
+
+func writeTokenError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
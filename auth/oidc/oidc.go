@@ -0,0 +1,293 @@
+// Package oidc signs users in with an external OpenID Connect issuer using
+// the authorization code flow with PKCE. Providers are configured from the
+// environment:
+//
+//	OIDC_PROVIDERS=google
+//	OIDC_GOOGLE_ISSUER=https://accounts.google.com
+//	OIDC_GOOGLE_CLIENT_ID=...
+//	OIDC_GOOGLE_CLIENT_SECRET=...
+//	OIDC_GOOGLE_SCOPES=openid email profile    (optional)
+//	OIDC_GOOGLE_REDIRECT_URL=...               (optional, defaults under APP_HOST)
+//
+// With OIDC_FAKE_ISSUER=true a stand-in issuer is served under /oidc/fake and
+// registered as the "fake" provider, for development and tests.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-fundraising/configs"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const discoveryTTL = time.Hour
+
+var (
+	ErrUnknownProvider = errors.New("unknown identity provider")
+	ErrInvalidIDToken  = errors.New("invalid id_token")
+
+	httpClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// Claims are the parts of a verified ID token this service uses.
+type Claims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type Provider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	RedirectURL  string
+
+	mu           sync.Mutex
+	meta         metadata
+	discoveredAt time.Time
+	keys         *remoteKeys
+}
+
+var (
+	providers     map[string]*Provider
+	providersOnce sync.Once
+)
+
+// Get returns a configured provider by name.
+func Get(name string) (*Provider, error) {
+	providersOnce.Do(func() {
+		providers = map[string]*Provider{}
+		for _, name := range strings.Split(configs.GetEnv("OIDC_PROVIDERS"), ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name != "" {
+				providers[name] = providerFromEnv(name)
+			}
+		}
+		if FakeEnabled() {
+			providers["fake"] = &Provider{
+				Name:         "fake",
+				Issuer:       FakeIssuerURL(),
+				ClientID:     fakeClientID,
+				ClientSecret: fakeClientSecret,
+				Scopes:       []string{"openid", "email", "profile"},
+				RedirectURL:  defaultRedirectURL("fake"),
+			}
+		}
+	})
+
+	p, ok := providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return p, nil
+}
+
+func providerFromEnv(name string) *Provider {
+	prefix := "OIDC_" + strings.ToUpper(name) + "_"
+
+	scopes := strings.Fields(configs.GetEnv(prefix + "SCOPES"))
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	redirect := configs.GetEnv(prefix + "REDIRECT_URL")
+	if redirect == "" {
+		redirect = defaultRedirectURL(name)
+	}
+
+	return &Provider{
+		Name:         name,
+		Issuer:       strings.TrimSuffix(configs.GetEnv(prefix+"ISSUER"), "/"),
+		ClientID:     configs.GetEnv(prefix + "CLIENT_ID"),
+		ClientSecret: configs.GetEnv(prefix + "CLIENT_SECRET"),
+		Scopes:       scopes,
+		RedirectURL:  redirect,
+	}
+}
+
+func defaultRedirectURL(name string) string {
+	return configs.GetEnv("APP_HOST") + "/auth/oidc/" + name + "/callback"
+}
+
+// AuthCodeURL is where the user is sent to sign in. loginHint is optional.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier, loginHint string) (string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {CodeChallenge(codeVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+	if loginHint != "" {
+		q.Set("login_hint", loginHint)
+	}
+
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the verified claims of
+// the ID token that came with it.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (Claims, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Claims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return Claims{}, err
+	}
+	defer res.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&body); err != nil {
+		return Claims{}, fmt.Errorf("token endpoint: %w", err)
+	}
+	if res.StatusCode != http.StatusOK || body.Error != "" {
+		return Claims{}, fmt.Errorf("token endpoint: %s %s", body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return Claims{}, fmt.Errorf("%w: missing from token response", ErrInvalidIDToken)
+	}
+
+	return p.verify(ctx, meta, body.IDToken, nonce)
+}
+
+func (p *Provider) verify(ctx context.Context, meta metadata, idToken, nonce string) (Claims, error) {
+	t, err := jwt.Parse(idToken, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.keys.get(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}),
+		jwt.WithIssuer(meta.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	raw := t.Claims.(jwt.MapClaims)
+
+	if got, _ := raw["nonce"].(string); got == "" || got != nonce {
+		return Claims{}, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	// A token for several audiences must name us as the authorized party.
+	if aud, _ := raw.GetAudience(); len(aud) > 1 {
+		if azp, _ := raw["azp"].(string); azp != p.ClientID {
+			return Claims{}, fmt.Errorf("%w: azp mismatch", ErrInvalidIDToken)
+		}
+	}
+
+	claims := Claims{}
+	claims.Subject, _ = raw["sub"].(string)
+	claims.Email, _ = raw["email"].(string)
+	claims.Name, _ = raw["name"].(string)
+	claims.PreferredUsername, _ = raw["preferred_username"].(string)
+	switch v := raw["email_verified"].(type) {
+	case bool:
+		claims.EmailVerified = v
+	case string:
+		// Some issuers send it as a string.
+		claims.EmailVerified = v == "true"
+	}
+	if claims.Subject == "" {
+		return Claims{}, fmt.Errorf("%w: missing sub", ErrInvalidIDToken)
+	}
+	return claims, nil
+}
+
+// discover fetches and caches the issuer's metadata.
+func (p *Provider) discover(ctx context.Context) (metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.discoveredAt.IsZero() && time.Since(p.discoveredAt) < discoveryTTL {
+		return p.meta, nil
+	}
+
+	var meta metadata
+	if err := getJSON(ctx, p.Issuer+"/.well-known/openid-configuration", &meta); err != nil {
+		return metadata{}, fmt.Errorf("discovery: %w", err)
+	}
+	if meta.Issuer != p.Issuer {
+		return metadata{}, fmt.Errorf("discovery: issuer %q does not match %q", meta.Issuer, p.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return metadata{}, errors.New("discovery: incomplete provider metadata")
+	}
+
+	p.meta = meta
+	p.discoveredAt = time.Now()
+	if p.keys == nil || p.keys.uri != meta.JWKSURI {
+		p.keys = &remoteKeys{uri: meta.JWKSURI}
+	}
+	return meta, nil
+}
+
+func getJSON(ctx context.Context, uri string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", uri, res.Status)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(v)
+}
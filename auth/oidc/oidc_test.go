@@ -0,0 +1,139 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// newTestProvider serves a fake issuer over HTTP and returns a provider
+// configured against it.
+func newTestProvider(t *testing.T) *Provider {
+	t.Helper()
+
+	var issuer *FakeIssuer
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		issuer.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	issuer = NewFakeIssuer(srv.URL)
+
+	return &Provider{
+		Name:         "fake",
+		Issuer:       srv.URL,
+		ClientID:     fakeClientID,
+		ClientSecret: fakeClientSecret,
+		Scopes:       []string{"openid", "email", "profile"},
+		RedirectURL:  "http://app.test/auth/oidc/fake/callback",
+	}
+}
+
+// authorize signs email in at the issuer and returns the code and state it
+// redirects back with.
+func authorize(t *testing.T, p *Provider, state, nonce, verifier, email string) (string, string) {
+	t.Helper()
+
+	authURL, err := p.AuthCodeURL(context.Background(), state, nonce, verifier, email)
+	if err != nil {
+		t.Fatalf("auth code url: %v", err)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: status %d", res.StatusCode)
+	}
+
+	back, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("authorize redirect: %v", err)
+	}
+	return back.Query().Get("code"), back.Query().Get("state")
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// The example from RFC 7636, appendix B.
+	got := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Errorf("CodeChallenge = %s, want %s", got, want)
+	}
+}
+
+func TestExchangeReturnsVerifiedClaims(t *testing.T) {
+	p := newTestProvider(t)
+	ctx := context.Background()
+
+	code, state := authorize(t, p, "state-1", "nonce-1", "verifier-1", "Donor@Example.com")
+	if state != "state-1" {
+		t.Errorf("state = %q, want it passed through", state)
+	}
+
+	claims, err := p.Exchange(ctx, code, "verifier-1", "nonce-1")
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if claims.Email != "donor@example.com" || !claims.EmailVerified || claims.Subject == "" {
+		t.Errorf("claims = %+v", claims)
+	}
+
+	code, _ = authorize(t, p, "state-2", "nonce-2", "verifier-2", "donor@example.com")
+	again, err := p.Exchange(ctx, code, "verifier-2", "nonce-2")
+	if err != nil {
+		t.Fatalf("second exchange: %v", err)
+	}
+	if again.Subject != claims.Subject {
+		t.Error("subject is not stable across sign-ins")
+	}
+}
+
+func TestExchangeRequiresCodeVerifier(t *testing.T) {
+	p := newTestProvider(t)
+	ctx := context.Background()
+
+	code, _ := authorize(t, p, "state", "nonce", "the-verifier", "donor@example.com")
+	if _, err := p.Exchange(ctx, code, "another-verifier", "nonce"); err == nil {
+		t.Fatal("exchange with the wrong code verifier succeeded")
+	}
+	// The failed attempt used the code up.
+	if _, err := p.Exchange(ctx, code, "the-verifier", "nonce"); err == nil {
+		t.Fatal("code could be redeemed twice")
+	}
+}
+
+func TestAuthorizeRequiresPKCE(t *testing.T) {
+	p := newTestProvider(t)
+
+	q := url.Values{
+		"response_type": {"code"},
+		"client_id":     {fakeClientID},
+		"redirect_uri":  {p.RedirectURL},
+		"login_hint":    {"donor@example.com"},
+	}
+	res, err := http.Get(p.Issuer + "/authorize?" + q.Encode())
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("authorize without a code challenge: status %d, want 400", res.StatusCode)
+	}
+}
+
+func TestExchangeRejectsNonceMismatch(t *testing.T) {
+	p := newTestProvider(t)
+
+	code, _ := authorize(t, p, "state", "issued-nonce", "verifier", "donor@example.com")
+	_, err := p.Exchange(context.Background(), code, "verifier", "expected-nonce")
+	if !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("err = %v, want ErrInvalidIDToken", err)
+	}
+}
@@ -0,0 +1,73 @@
+// Package roles defines the roles a user can hold and the permissions each
+// one grants. Access tokens carry a user's roles, so checks need no lookup.
+//
+// Admins manage roles through /admin; the first one is granted directly:
+//
+//	UPDATE go_fundraising.users SET roles = {'donor', 'organizer', 'admin'} WHERE id = ...;
+package roles
+
+const (
+	Donor     = "donor"
+	Organizer = "organizer"
+	Moderator = "moderator"
+	Admin     = "admin"
+)
+
+type Permission string
+
+const (
+	CreateCampaign   Permission = "campaign:create"
+	ModerateCampaign Permission = "campaign:moderate"
+	ModerateComment  Permission = "comment:moderate"
+	RefundAnyPayment Permission = "payment:refund_any"
+	ManageRoles      Permission = "roles:manage"
+)
+
+var permissions = map[string][]Permission{
+	Donor:     nil,
+	Organizer: {CreateCampaign},
+	Moderator: {ModerateCampaign, ModerateComment},
+	Admin:     {CreateCampaign, ModerateCampaign, ModerateComment, RefundAnyPayment, ManageRoles},
+}
+
+// Default is what every new account starts with. Donor is the base role and
+// cannot be revoked, so a user's stored roles are never empty once set.
+func Default() []string {
+	return []string{Donor, Organizer}
+}
+
+func IsValid(role string) bool {
+	_, ok := permissions[role]
+	return ok
+}
+
+// Effective returns the roles a user acts with. Accounts created before roles
+// existed have none stored and get the defaults.
+func Effective(stored []string) []string {
+	if len(stored) == 0 {
+		return Default()
+	}
+	return stored
+}
+
+// Has reports whether held includes role. Admins hold every role.
+func Has(held []string, role string) bool {
+	for _, r := range held {
+		if r == role || r == Admin {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports whether any of the held roles grants p.
+func Can(held []string, p Permission) bool {
+	for _, r := range held {
+		for _, granted := range permissions[r] {
+			if granted == p {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -2,7 +2,10 @@ package routes
 
 import (
 	"go-fundraising/auth/handlers"
+	"go-fundraising/auth/oidc"
+	"go-fundraising/auth/roles"
 	"go-fundraising/middleware"
+	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
@@ -12,8 +15,47 @@ func InitAuthRouter(route *gin.Engine) {
 	{
 		userGroup.POST("/register", handlers.CreateUserHandler)
 		userGroup.POST("/login", handlers.LoginHandler)
+		userGroup.POST("/login/mfa", handlers.MFALoginHandler)
+		userGroup.GET("/mfa", middleware.AuthMiddleware(), handlers.GetMFAStatusHandler)
+		userGroup.POST("/mfa/enroll", middleware.AuthMiddleware(), handlers.EnrollMFAHandler)
+		userGroup.POST("/mfa/confirm", middleware.AuthMiddleware(), handlers.ConfirmMFAHandler)
+		userGroup.POST("/mfa/disable", middleware.AuthMiddleware(), handlers.DisableMFAHandler)
+		userGroup.POST("/mfa/recovery-codes", middleware.AuthMiddleware(), handlers.RegenerateRecoveryCodesHandler)
 		userGroup.GET("/current-user", middleware.AuthMiddleware(), handlers.CurrentUserHandler)
 		userGroup.POST("/refresh", handlers.RefreshHandler)
 		userGroup.POST("/logout", handlers.LogoutHandler)
+		userGroup.POST("/verify-email/send", middleware.AuthMiddleware(), handlers.SendVerificationEmailHandler)
+		userGroup.GET("/verify-email", handlers.VerifyEmailHandler)
+		userGroup.POST("/verify-email", handlers.VerifyEmailHandler)
+		userGroup.POST("/password/forgot", handlers.ForgotPasswordHandler)
+		userGroup.POST("/password/reset", handlers.ResetPasswordHandler)
+		userGroup.GET("/sessions", middleware.AuthMiddleware(), handlers.GetSessionsHandler)
+		userGroup.DELETE("/sessions", middleware.AuthMiddleware(), handlers.RevokeAllSessionsHandler)
+		userGroup.DELETE("/sessions/:session_id", middleware.AuthMiddleware(), handlers.RevokeSessionHandler)
+		userGroup.PATCH("/profile", middleware.AuthMiddleware(), handlers.UpdateProfileHandler)
+		userGroup.POST("/password/change", middleware.AuthMiddleware(), handlers.ChangePasswordHandler)
+		userGroup.POST("/email/change", middleware.AuthMiddleware(), handlers.RequestEmailChangeHandler)
+		userGroup.GET("/email/confirm", handlers.ConfirmEmailChangeHandler)
+		userGroup.POST("/email/confirm", handlers.ConfirmEmailChangeHandler)
+		userGroup.DELETE("/account", middleware.AuthMiddleware(), handlers.DeleteAccountHandler)
+		userGroup.GET("/oidc/:provider/login", handlers.OIDCLoginHandler)
+		userGroup.POST("/oidc/:provider/link", middleware.AuthMiddleware(), handlers.OIDCLinkHandler)
+		userGroup.POST("/oidc/:provider/reauth", middleware.AuthMiddleware(), handlers.OIDCReauthHandler)
+		userGroup.GET("/oidc/:provider/callback", handlers.OIDCCallbackHandler)
+		userGroup.GET("/identities", middleware.AuthMiddleware(), handlers.GetIdentitiesHandler)
+		userGroup.DELETE("/identities/:provider/:subject", middleware.AuthMiddleware(), handlers.UnlinkIdentityHandler)
+	}
+
+	adminGroup := route.Group("/admin", middleware.AuthMiddleware(), middleware.RequirePermission(roles.ManageRoles))
+	{
+		adminGroup.GET("/users/:user_id/roles", handlers.GetUserRolesHandler)
+		adminGroup.POST("/users/:user_id/roles", handlers.GrantRoleHandler)
+		adminGroup.DELETE("/users/:user_id/roles/:role", handlers.RevokeRoleHandler)
+	}
+
+	route.GET("/.well-known/jwks.json", handlers.JWKSHandler)
+
+	if oidc.FakeEnabled() {
+		route.Any("/oidc/fake/*path", gin.WrapH(http.StripPrefix("/oidc/fake", oidc.Fake())))
 	}
 }
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"go-fundraising/auth/models"
+	"go-fundraising/db"
+	"go-fundraising/token"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+)
+
+const (
+	PurposeVerifyEmail    = "verify_email"
+	PurposeResetPassword  = "reset_password"
+	PurposeMFAChallenge   = "mfa_challenge"
+	PurposeChangeEmail    = "change_email"
+	PurposeReauthenticate = "reauthenticate"
+
+	EmailVerificationTTL = 24 * time.Hour
+	EmailChangeTTL       = 24 * time.Hour
+	PasswordResetTTL     = time.Hour
+	MFAChallengeTTL      = 5 * time.Minute
+	ReauthenticateTTL    = 5 * time.Minute
+)
+
+var (
+	ErrInvalidActionToken = errors.New("invalid or expired token")
+	ErrActionTokenUsed    = errors.New("token has already been used")
+)
+
+// ActionClaims are carried by the signed tokens in verification and reset
+// links. Email and PasswordHint tie a token to the account state it was
+// issued for, so changing either invalidates outstanding links.
+type ActionClaims struct {
+	ID           string
+	UserID       gocql.UUID
+	Purpose      string
+	Email        string
+	PasswordHint string
+	ExpiresAt    time.Time
+
+	// NewEmail is the address being confirmed by a change_email token.
+	NewEmail string
+}
+
+type ActionTokenService struct{}
+
+// IssueActionToken signs a single-use token for purpose. The token has no
+// user_id claim, so it is never accepted as an access token.
+func (s *ActionTokenService) IssueActionToken(user models.User, purpose string, ttl time.Duration) (string, error) {
+	return token.Issue(actionClaims(user, purpose, ttl))
+}
+
+// IssueEmailChangeToken signs a change_email token confirming newEmail. It
+// is sent to newEmail, so redeeming it proves the user owns that address.
+func (s *ActionTokenService) IssueEmailChangeToken(user models.User, newEmail string) (string, error) {
+	claims := actionClaims(user, PurposeChangeEmail, EmailChangeTTL)
+	claims["new_email"] = newEmail
+	return token.Issue(claims)
+}
+
+func actionClaims(user models.User, purpose string, ttl time.Duration) jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":     user.ID.String(),
+		"purpose": purpose,
+		"jti":     gocql.TimeUUID().String(),
+		"email":   user.Email,
+		"pwd":     PasswordHint(user.PasswordHash),
+		"exp":     time.Now().Add(ttl).Unix(),
+	}
+}
+
+// ConsumeActionToken verifies tokenStr for purpose and marks it used. Every
+// token can be consumed once.
+func (s *ActionTokenService) ConsumeActionToken(ctx context.Context, tokenStr, purpose string) (ActionClaims, error) {
+	claims, err := s.ParseActionToken(tokenStr, purpose)
+	if err != nil {
+		return ActionClaims{}, err
+	}
+	if err := s.MarkActionTokenUsed(ctx, claims); err != nil {
+		return ActionClaims{}, err
+	}
+	return claims, nil
+}
+
+// ParseActionToken verifies tokenStr for purpose without spending it.
+func (s *ActionTokenService) ParseActionToken(tokenStr, purpose string) (ActionClaims, error) {
+	raw, err := token.Parse(tokenStr)
+	if err != nil {
+		return ActionClaims{}, ErrInvalidActionToken
+	}
+
+	claims := ActionClaims{}
+	claims.Purpose, _ = raw["purpose"].(string)
+	claims.ID, _ = raw["jti"].(string)
+	claims.Email, _ = raw["email"].(string)
+	claims.PasswordHint, _ = raw["pwd"].(string)
+	claims.NewEmail, _ = raw["new_email"].(string)
+	sub, _ := raw["sub"].(string)
+	if claims.Purpose != purpose || claims.ID == "" {
+		return ActionClaims{}, ErrInvalidActionToken
+	}
+	if claims.UserID, err = gocql.ParseUUID(sub); err != nil {
+		return ActionClaims{}, ErrInvalidActionToken
+	}
+	exp, err := raw.GetExpirationTime()
+	if err != nil || exp == nil {
+		return ActionClaims{}, ErrInvalidActionToken
+	}
+	claims.ExpiresAt = exp.Time
+	return claims, nil
+}
+
+// MarkActionTokenUsed spends the token, failing with ErrActionTokenUsed if it
+// already was.
+func (s *ActionTokenService) MarkActionTokenUsed(ctx context.Context, claims ActionClaims) error {
+	stmt, names := qb.Insert(models.UsedActionTokenTable.Name).
+		Columns(models.UsedActionTokenTable.Columns...).
+		Unique().
+		TTLNamed("ttl").
+		ToCql()
+
+	remaining := time.Until(claims.ExpiresAt) + time.Minute
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindStructMap(models.UsedActionToken{ID: claims.ID, UsedAt: time.Now()}, map[string]interface{}{
+			"ttl": qb.TTL(remaining),
+		})
+	applied, err := q.MapScanCAS(map[string]interface{}{})
+	q.Release()
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrActionTokenUsed
+	}
+	return nil
+}
+
+// PasswordHint is a short fingerprint of a password hash. It changes whenever
+// the password does without revealing anything about it.
+func PasswordHint(passwordHash string) string {
+	sum := sha256.Sum256([]byte(passwordHash))
+	return hex.EncodeToString(sum[:8])
+}
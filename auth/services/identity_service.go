@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"go-fundraising/auth/models"
+	"go-fundraising/db"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+)
+
+// OIDCStateTTL is how long a user has to finish signing in at the provider.
+const OIDCStateTTL = 10 * time.Minute
+
+var (
+	ErrInvalidOIDCState = errors.New("invalid or expired sign-in state")
+	ErrIdentityLinked   = errors.New("identity is linked to another account")
+)
+
+type IdentityService struct{}
+
+func (s *IdentityService) SaveOIDCState(ctx context.Context, state models.OIDCState) error {
+	stmt, names := qb.Insert(models.OIDCStateTable.Name).
+		Columns(models.OIDCStateTable.Columns...).
+		TTL(OIDCStateTTL).
+		ToCql()
+
+	return gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindStruct(state).
+		ExecRelease()
+}
+
+// ConsumeOIDCState returns a pending sign-in and deletes it, so each state is
+// good for exactly one callback.
+func (s *IdentityService) ConsumeOIDCState(ctx context.Context, state string) (models.OIDCState, error) {
+	var row models.OIDCState
+
+	stmt, names := qb.Select(models.OIDCStateTable.Name).Where(qb.Eq("state")).ToCql()
+	err := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"state": state}).
+		GetRelease(&row)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return models.OIDCState{}, ErrInvalidOIDCState
+	}
+	if err != nil {
+		return models.OIDCState{}, err
+	}
+
+	stmt, names = qb.Delete(models.OIDCStateTable.Name).Where(qb.Eq("state")).Existing().ToCql()
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"state": state})
+	applied, err := q.MapScanCAS(map[string]interface{}{})
+	q.Release()
+	if err != nil {
+		return models.OIDCState{}, err
+	}
+	if !applied {
+		return models.OIDCState{}, ErrInvalidOIDCState
+	}
+	return row, nil
+}
+
+func (s *IdentityService) GetIdentity(ctx context.Context, provider, subject string) (models.UserIdentity, error) {
+	var identity models.UserIdentity
+
+	stmt, names := qb.Select(models.UserIdentityTable.Name).
+		Where(qb.Eq("provider"), qb.Eq("subject")).
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"provider": provider, "subject": subject})
+
+	if err := q.GetRelease(&identity); err != nil {
+		return models.UserIdentity{}, err
+	}
+	return identity, nil
+}
+
+func (s *IdentityService) GetIdentitiesByUserID(ctx context.Context, userID gocql.UUID) ([]models.UserIdentity, error) {
+	var identities []models.UserIdentity
+
+	stmt, names := qb.Select(models.UserIdentityTable.Name).Where(qb.Eq("user_id")).ToCql()
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"user_id": userID})
+
+	if err := q.SelectRelease(&identities); err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+// LinkIdentity claims an external identity for a user. Linking an identity
+// the user already has succeeds; one held by someone else does not.
+func (s *IdentityService) LinkIdentity(ctx context.Context, identity models.UserIdentity) error {
+	if identity.CreatedAt.IsZero() {
+		identity.CreatedAt = time.Now()
+	}
+
+	stmt, names := qb.Insert(models.UserIdentityTable.Name).
+		Columns(models.UserIdentityTable.Columns...).
+		Unique().
+		ToCql()
+
+	existing := map[string]interface{}{}
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).BindStruct(identity)
+	applied, err := q.MapScanCAS(existing)
+	q.Release()
+	if err != nil {
+		return err
+	}
+	if !applied {
+		if owner, _ := existing["user_id"].(gocql.UUID); owner != identity.UserID {
+			return ErrIdentityLinked
+		}
+	}
+	return nil
+}
+
+// UnlinkIdentity removes an identity if it belongs to userID.
+func (s *IdentityService) UnlinkIdentity(ctx context.Context, userID gocql.UUID, provider, subject string) error {
+	stmt, names := qb.Delete(models.UserIdentityTable.Name).
+		Where(qb.Eq("provider"), qb.Eq("subject")).
+		If(qb.Eq("user_id")).
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).BindMap(map[string]interface{}{
+		"provider": provider,
+		"subject":  subject,
+		"user_id":  userID,
+	})
+	applied, err := q.MapScanCAS(map[string]interface{}{})
+	q.Release()
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return gocql.ErrNotFound
+	}
+	return nil
+}
+
+// DeleteIdentities unlinks every identity of a user.
+func (s *IdentityService) DeleteIdentities(ctx context.Context, userID gocql.UUID) error {
+	identities, err := s.GetIdentitiesByUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, identity := range identities {
+		err := s.UnlinkIdentity(ctx, userID, identity.Provider, identity.Subject)
+		if err != nil && !errors.Is(err, gocql.ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
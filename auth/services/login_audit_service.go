@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"go-fundraising/auth/models"
+	"go-fundraising/db"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+)
+
+// LoginFailureRetention is how long failed logins stay in the audit log.
+const LoginFailureRetention = 90 * 24 * time.Hour
+
+const (
+	LoginFailureUnknownAccount = "unknown_account"
+	LoginFailureBadPassword    = "bad_password"
+)
+
+type LoginAuditService struct{}
+
+func (s *LoginAuditService) RecordLoginFailure(ctx context.Context, failure models.LoginFailure) error {
+	if failure.ID == (gocql.UUID{}) {
+		failure.ID = gocql.TimeUUID()
+	}
+	if failure.CreatedAt.IsZero() {
+		failure.CreatedAt = time.Now()
+	}
+
+	stmt, names := qb.Insert(models.LoginFailureTable.Name).
+		Columns(models.LoginFailureTable.Columns...).
+		TTL(LoginFailureRetention).
+		ToCql()
+
+	return gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindStruct(failure).
+		ExecRelease()
+}
+
+func (s *LoginAuditService) GetLoginFailures(ctx context.Context, account string, limit uint) ([]models.LoginFailure, error) {
+	var failures []models.LoginFailure
+
+	stmt, names := qb.Select(models.LoginFailureTable.Name).
+		Where(qb.Eq("account")).
+		Limit(limit).
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"account": account})
+
+	if err := q.SelectRelease(&failures); err != nil {
+		return nil, err
+	}
+	return failures, nil
+}
@@ -0,0 +1,301 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"go-fundraising/auth/models"
+	"go-fundraising/auth/totp"
+	"go-fundraising/db"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+)
+
+const RecoveryCodeCount = 10
+
+var (
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrMFANotEnrolling   = errors.New("no two-factor enrollment in progress")
+	ErrInvalidMFACode    = errors.New("invalid two-factor code")
+)
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type MFAService struct{}
+
+func (s *MFAService) GetMFA(ctx context.Context, userID gocql.UUID) (models.UserMFA, error) {
+	var mfa models.UserMFA
+
+	stmt, names := qb.Select(models.UserMFATable.Name).
+		Where(qb.Eq("user_id")).
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"user_id": userID})
+
+	if err := q.GetRelease(&mfa); err != nil {
+		if errors.Is(err, gocql.ErrNotFound) {
+			return models.UserMFA{UserID: userID}, nil
+		}
+		return models.UserMFA{}, err
+	}
+	return mfa, nil
+}
+
+// StartEnrollment stores a new pending secret for the user and returns it.
+// Nothing changes for login until a code from it is confirmed.
+func (s *MFAService) StartEnrollment(ctx context.Context, userID gocql.UUID) (string, error) {
+	mfa, err := s.GetMFA(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if mfa.Enabled {
+		return "", ErrMFAAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+
+	stmt, names := qb.Update(models.UserMFATable.Name).
+		Set("pending_secret").
+		Where(qb.Eq("user_id")).
+		ToCql()
+
+	err = gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{
+			"user_id":        userID,
+			"pending_secret": secret,
+		}).
+		ExecRelease()
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// ConfirmEnrollment enables two-factor authentication once code matches the
+// pending secret and returns a fresh set of recovery codes.
+func (s *MFAService) ConfirmEnrollment(ctx context.Context, userID gocql.UUID, code string) ([]string, error) {
+	mfa, err := s.GetMFA(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if mfa.Enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if mfa.PendingSecret == "" {
+		return nil, ErrMFANotEnrolling
+	}
+
+	step, ok := totp.Validate(mfa.PendingSecret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	stmt, names := qb.Update(models.UserMFATable.Name).
+		Set("secret", "pending_secret", "enabled", "last_used_step", "enabled_at").
+		Where(qb.Eq("user_id")).
+		If(qb.EqNamed("pending_secret", "expected")).
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).BindMap(map[string]interface{}{
+		"user_id":        userID,
+		"secret":         mfa.PendingSecret,
+		"pending_secret": nil,
+		"enabled":        true,
+		"last_used_step": step,
+		"enabled_at":     time.Now(),
+		"expected":       mfa.PendingSecret,
+	})
+	applied, err := q.MapScanCAS(map[string]interface{}{})
+	q.Release()
+	if err != nil {
+		return nil, err
+	}
+	if !applied {
+		// Enrollment was restarted or confirmed concurrently.
+		return nil, ErrMFANotEnrolling
+	}
+
+	return s.RegenerateRecoveryCodes(ctx, userID)
+}
+
+// VerifyCode accepts a TOTP code for an enabled user. Each code is accepted
+// once: the step it matched must be newer than the last one used.
+func (s *MFAService) VerifyCode(ctx context.Context, userID gocql.UUID, code string) error {
+	mfa, err := s.GetMFA(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !mfa.Enabled {
+		return ErrMFANotEnabled
+	}
+
+	step, ok := totp.Validate(mfa.Secret, code, time.Now())
+	if !ok || step <= mfa.LastUsedStep {
+		return ErrInvalidMFACode
+	}
+
+	stmt, names := qb.Update(models.UserMFATable.Name).
+		Set("last_used_step").
+		Where(qb.Eq("user_id")).
+		If(qb.LtNamed("last_used_step", "step")).
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).BindMap(map[string]interface{}{
+		"user_id":        userID,
+		"last_used_step": step,
+		"step":           step,
+	})
+	applied, err := q.MapScanCAS(map[string]interface{}{})
+	q.Release()
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// UseRecoveryCode spends one recovery code.
+func (s *MFAService) UseRecoveryCode(ctx context.Context, userID gocql.UUID, code string) error {
+	hash := hashRecoveryCode(code)
+
+	var row models.MFARecoveryCode
+	stmt, names := qb.Select(models.MFARecoveryCodeTable.Name).
+		Where(qb.Eq("user_id"), qb.Eq("code_hash")).
+		ToCql()
+
+	err := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"user_id": userID, "code_hash": hash}).
+		GetRelease(&row)
+	if err != nil {
+		if errors.Is(err, gocql.ErrNotFound) {
+			return ErrInvalidMFACode
+		}
+		return err
+	}
+	if !row.UsedAt.IsZero() {
+		return ErrInvalidMFACode
+	}
+
+	// The row is known to exist here; "IF used_at = null" on a missing row
+	// would create it.
+	useStmt, useNames := qb.Update(models.MFARecoveryCodeTable.Name).
+		Set("used_at").
+		Where(qb.Eq("user_id"), qb.Eq("code_hash")).
+		If(qb.EqLit("used_at", "null")).
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(useStmt).WithContext(ctx), useNames).BindMap(map[string]interface{}{
+		"user_id":   userID,
+		"code_hash": hash,
+		"used_at":   time.Now(),
+	})
+	applied, err := q.MapScanCAS(map[string]interface{}{})
+	q.Release()
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes and returns the
+// new ones. Only their hashes are kept.
+func (s *MFAService) RegenerateRecoveryCodes(ctx context.Context, userID gocql.UUID) ([]string, error) {
+	if err := s.deleteRecoveryCodes(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	stmt, names := qb.Insert(models.MFARecoveryCodeTable.Name).
+		Columns("user_id", "code_hash", "created_at").
+		ToCql()
+
+	now := time.Now()
+	codes := make([]string, 0, RecoveryCodeCount)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(recoveryEncoding.EncodeToString(b))
+		code := raw[:4] + "-" + raw[4:]
+
+		err := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+			BindStruct(models.MFARecoveryCode{UserID: userID, CodeHash: hashRecoveryCode(code), CreatedAt: now}).
+			ExecRelease()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+func (s *MFAService) CountRecoveryCodes(ctx context.Context, userID gocql.UUID) (int, error) {
+	var rows []models.MFARecoveryCode
+
+	stmt, names := qb.Select(models.MFARecoveryCodeTable.Name).
+		Where(qb.Eq("user_id")).
+		ToCql()
+
+	err := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"user_id": userID}).
+		SelectRelease(&rows)
+	if err != nil {
+		return 0, err
+	}
+
+	remaining := 0
+	for _, row := range rows {
+		if row.UsedAt.IsZero() {
+			remaining++
+		}
+	}
+	return remaining, nil
+}
+
+func (s *MFAService) Disable(ctx context.Context, userID gocql.UUID) error {
+	stmt, names := qb.Delete(models.UserMFATable.Name).
+		Where(qb.Eq("user_id")).
+		ToCql()
+
+	err := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"user_id": userID}).
+		ExecRelease()
+	if err != nil {
+		return err
+	}
+	return s.deleteRecoveryCodes(ctx, userID)
+}
+
+func (s *MFAService) deleteRecoveryCodes(ctx context.Context, userID gocql.UUID) error {
+	stmt, names := qb.Delete(models.MFARecoveryCodeTable.Name).
+		Where(qb.Eq("user_id")).
+		ToCql()
+
+	return gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"user_id": userID}).
+		ExecRelease()
+}
+
+// hashRecoveryCode ignores case and separators, as codes are typed by hand.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
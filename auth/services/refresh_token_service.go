@@ -0,0 +1,309 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"go-fundraising/auth/models"
+	"go-fundraising/db"
+	"log"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+)
+
+// RefreshTokenTTL is how long a refresh token stays valid. Rows are written
+// with the same TTL so Scylla expires them on its own.
+const RefreshTokenTTL = 7 * 24 * time.Hour
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+type RefreshTokenService struct{}
+
+// NewRefreshTokenFamily starts a family, and with it a session, for a fresh
+// login and returns its first refresh token.
+func (s *RefreshTokenService) NewRefreshTokenFamily(ctx context.Context, userID gocql.UUID, userAgent, ipAddress string) (string, gocql.UUID, error) {
+	now := time.Now()
+	family := models.RefreshTokenFamily{
+		FamilyID:   gocql.TimeUUID(),
+		UserID:     userID,
+		UserAgent:  userAgent,
+		IPAddress:  ipAddress,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(RefreshTokenTTL),
+	}
+
+	stmt, names := qb.Insert(models.RefreshTokenFamilyTable.Name).
+		Columns(models.RefreshTokenFamilyTable.Columns...).
+		TTLNamed("ttl").
+		ToCql()
+
+	err := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindStructMap(family, map[string]interface{}{"ttl": qb.TTL(RefreshTokenTTL)}).
+		ExecRelease()
+	if err != nil {
+		return "", gocql.UUID{}, err
+	}
+
+	token, err := s.issue(ctx, userID, family.FamilyID, now)
+	if err != nil {
+		return "", gocql.UUID{}, err
+	}
+	return token, family.FamilyID, nil
+}
+
+// RotateRefreshToken exchanges token for a new one in the same family. A
+// token that was already exchanged revokes the whole family, since either
+// it or its successor is in the wrong hands.
+func (s *RefreshTokenService) RotateRefreshToken(ctx context.Context, token, userAgent, ipAddress string) (models.RefreshToken, string, error) {
+	row, err := s.lookup(ctx, token)
+	if err != nil {
+		return models.RefreshToken{}, "", err
+	}
+
+	family, err := s.GetRefreshTokenFamily(ctx, row.FamilyID)
+	if err != nil {
+		if errors.Is(err, gocql.ErrNotFound) {
+			return models.RefreshToken{}, "", ErrInvalidRefreshToken
+		}
+		return models.RefreshToken{}, "", err
+	}
+	if family.Revoked {
+		return models.RefreshToken{}, "", ErrInvalidRefreshToken
+	}
+
+	now := time.Now()
+	remaining := row.ExpiresAt.Sub(now)
+	if remaining <= 0 {
+		return models.RefreshToken{}, "", ErrInvalidRefreshToken
+	}
+
+	// Claim the token with a conditional write so two concurrent exchanges
+	// cannot both succeed. The update keeps the row's TTL.
+	stmt, names := qb.Update(models.RefreshTokenTable.Name).
+		TTLNamed("ttl").
+		Set("rotated_at").
+		Where(qb.Eq("token_hash")).
+		If(qb.EqLit("rotated_at", "null")).
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).BindMap(map[string]interface{}{
+		"token_hash": row.TokenHash,
+		"rotated_at": now,
+		"ttl":        qb.TTL(remaining),
+	})
+	applied, err := q.MapScanCAS(map[string]interface{}{})
+	q.Release()
+	if err != nil {
+		return models.RefreshToken{}, "", err
+	}
+	if !applied {
+		log.Printf("⚠️ Refresh token reuse in family %s of user %s, revoking family\n", row.FamilyID, row.UserID)
+		if err := s.RevokeRefreshTokenFamily(ctx, row.FamilyID); err != nil {
+			return models.RefreshToken{}, "", err
+		}
+		return models.RefreshToken{}, "", ErrRefreshTokenReused
+	}
+
+	family.UserAgent = userAgent
+	family.IPAddress = ipAddress
+	if err := s.touchFamily(ctx, family, now); err != nil {
+		return models.RefreshToken{}, "", err
+	}
+
+	next, err := s.issue(ctx, row.UserID, row.FamilyID, now)
+	if err != nil {
+		return models.RefreshToken{}, "", err
+	}
+	return row, next, nil
+}
+
+// RevokeRefreshToken revokes the family token belongs to, which is what
+// logging out of one session means.
+func (s *RefreshTokenService) RevokeRefreshToken(ctx context.Context, token string) error {
+	row, err := s.lookup(ctx, token)
+	if err != nil {
+		return err
+	}
+	return s.RevokeRefreshTokenFamily(ctx, row.FamilyID)
+}
+
+func (s *RefreshTokenService) RevokeRefreshTokenFamily(ctx context.Context, familyID gocql.UUID) error {
+	stmt, names := qb.Update(models.RefreshTokenFamilyTable.Name).
+		TTLNamed("ttl").
+		Set("revoked").
+		Where(qb.Eq("family_id")).
+		ToCql()
+
+	return gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{
+			"family_id": familyID,
+			"revoked":   true,
+			"ttl":       qb.TTL(RefreshTokenTTL),
+		}).
+		ExecRelease()
+}
+
+func (s *RefreshTokenService) GetRefreshTokenFamily(ctx context.Context, familyID gocql.UUID) (models.RefreshTokenFamily, error) {
+	var family models.RefreshTokenFamily
+
+	stmt, names := qb.Select(models.RefreshTokenFamilyTable.Name).
+		Where(qb.Eq("family_id")).
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"family_id": familyID})
+
+	if err := q.GetRelease(&family); err != nil {
+		return models.RefreshTokenFamily{}, err
+	}
+	return family, nil
+}
+
+// GetActiveSessions lists the user's families that have not been revoked.
+// Expired ones are already gone through their TTL.
+func (s *RefreshTokenService) GetActiveSessions(ctx context.Context, userID gocql.UUID) ([]models.RefreshTokenFamily, error) {
+	var families []models.RefreshTokenFamily
+
+	stmt, names := qb.Select(models.RefreshTokenFamilyTable.Name).
+		Where(qb.Eq("user_id")).
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"user_id": userID})
+
+	if err := q.SelectRelease(&families); err != nil {
+		return nil, err
+	}
+
+	active := make([]models.RefreshTokenFamily, 0, len(families))
+	for _, family := range families {
+		if !family.Revoked {
+			active = append(active, family)
+		}
+	}
+	return active, nil
+}
+
+// RevokeAllSessions logs the user out everywhere.
+func (s *RefreshTokenService) RevokeAllSessions(ctx context.Context, userID gocql.UUID) error {
+	families, err := s.GetActiveSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, family := range families {
+		if err := s.RevokeRefreshTokenFamily(ctx, family.FamilyID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RevokeOtherSessions signs the user out everywhere except the session keep.
+func (s *RefreshTokenService) RevokeOtherSessions(ctx context.Context, userID, keep gocql.UUID) error {
+	families, err := s.GetActiveSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, family := range families {
+		if family.FamilyID == keep {
+			continue
+		}
+		if err := s.RevokeRefreshTokenFamily(ctx, family.FamilyID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsSessionActive reports whether access tokens of the session may still be
+// used: it exists, has not expired and has not been revoked.
+func (s *RefreshTokenService) IsSessionActive(ctx context.Context, familyID gocql.UUID) (bool, error) {
+	family, err := s.GetRefreshTokenFamily(ctx, familyID)
+	if err != nil {
+		if errors.Is(err, gocql.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return !family.Revoked, nil
+}
+
+// touchFamily rewrites the family with a fresh TTL so it lives as long as
+// its newest token.
+func (s *RefreshTokenService) touchFamily(ctx context.Context, family models.RefreshTokenFamily, now time.Time) error {
+	family.LastUsedAt = now
+	family.ExpiresAt = now.Add(RefreshTokenTTL)
+
+	stmt, names := qb.Update(models.RefreshTokenFamilyTable.Name).
+		TTLNamed("ttl").
+		Set("user_id", "user_agent", "ip_address", "created_at", "last_used_at", "expires_at").
+		Where(qb.Eq("family_id")).
+		ToCql()
+
+	return gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindStructMap(family, map[string]interface{}{"ttl": qb.TTL(RefreshTokenTTL)}).
+		ExecRelease()
+}
+
+func (s *RefreshTokenService) issue(ctx context.Context, userID, familyID gocql.UUID, now time.Time) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	row := models.RefreshToken{
+		TokenHash: hashRefreshToken(token),
+		UserID:    userID,
+		FamilyID:  familyID,
+		ExpiresAt: now.Add(RefreshTokenTTL),
+		CreatedAt: now,
+	}
+
+	stmt, names := qb.Insert(models.RefreshTokenTable.Name).
+		Columns("token_hash", "user_id", "family_id", "expires_at", "created_at").
+		TTLNamed("ttl").
+		ToCql()
+
+	err := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindStructMap(row, map[string]interface{}{"ttl": qb.TTL(RefreshTokenTTL)}).
+		ExecRelease()
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (s *RefreshTokenService) lookup(ctx context.Context, token string) (models.RefreshToken, error) {
+	var row models.RefreshToken
+
+	stmt, names := qb.Select(models.RefreshTokenTable.Name).
+		Where(qb.Eq("token_hash")).
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"token_hash": hashRefreshToken(token)})
+
+	if err := q.GetRelease(&row); err != nil {
+		if errors.Is(err, gocql.ErrNotFound) {
+			return models.RefreshToken{}, ErrInvalidRefreshToken
+		}
+		return models.RefreshToken{}, err
+	}
+	return row, nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"go-fundraising/auth/models"
+	"go-fundraising/auth/roles"
+	"go-fundraising/db"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+)
+
+var (
+	ErrUnknownRole    = errors.New("unknown role")
+	ErrBaseRole       = errors.New("the donor role cannot be revoked")
+	ErrSelfDemotion   = errors.New("admins cannot revoke their own admin role")
+	ErrRoleNotChanged = errors.New("role is unchanged")
+)
+
+var userService = UserService{}
+
+type RoleService struct{}
+
+// GrantRole adds role to a user and records who did it. Granting a role the
+// user already holds returns ErrRoleNotChanged and is not audited.
+func (s *RoleService) GrantRole(ctx context.Context, actorID, userID gocql.UUID, role, reason string) (models.User, error) {
+	if !roles.IsValid(role) {
+		return models.User{}, ErrUnknownRole
+	}
+
+	user, err := userService.GetUserByID(ctx, userID)
+	if err != nil {
+		return models.User{}, err
+	}
+	if contains(roles.Effective(user.Roles), role) {
+		return user, ErrRoleNotChanged
+	}
+
+	// Users without stored roles act with the defaults; store those too so
+	// the grant does not take them away.
+	add := []string{role}
+	if len(user.Roles) == 0 {
+		add = append(roles.Default(), role)
+	}
+
+	stmt, names := qb.Update(models.UserTable.Name).
+		Add("roles").
+		Where(qb.Eq("id")).
+		ToCql()
+	err = gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"id": userID, "roles": add}).
+		ExecRelease()
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if err := s.record(ctx, userID, actorID, models.RoleChangeGrant, role, reason); err != nil {
+		return models.User{}, err
+	}
+	return userService.GetUserByID(ctx, userID)
+}
+
+// RevokeRole removes role from a user and records who did it.
+func (s *RoleService) RevokeRole(ctx context.Context, actorID, userID gocql.UUID, role, reason string) (models.User, error) {
+	if !roles.IsValid(role) {
+		return models.User{}, ErrUnknownRole
+	}
+	if role == roles.Donor {
+		return models.User{}, ErrBaseRole
+	}
+	if role == roles.Admin && actorID == userID {
+		return models.User{}, ErrSelfDemotion
+	}
+
+	user, err := userService.GetUserByID(ctx, userID)
+	if err != nil {
+		return models.User{}, err
+	}
+	if !contains(roles.Effective(user.Roles), role) {
+		return user, ErrRoleNotChanged
+	}
+
+	var q *gocqlx.Queryx
+	if len(user.Roles) == 0 {
+		var remaining []string
+		for _, r := range roles.Default() {
+			if r != role {
+				remaining = append(remaining, r)
+			}
+		}
+		stmt, names := qb.Update(models.UserTable.Name).Set("roles").Where(qb.Eq("id")).ToCql()
+		q = gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+			BindMap(map[string]interface{}{"id": userID, "roles": remaining})
+	} else {
+		stmt, names := qb.Update(models.UserTable.Name).Remove("roles").Where(qb.Eq("id")).ToCql()
+		q = gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+			BindMap(map[string]interface{}{"id": userID, "roles": []string{role}})
+	}
+	if err := q.ExecRelease(); err != nil {
+		return models.User{}, err
+	}
+
+	if err := s.record(ctx, userID, actorID, models.RoleChangeRevoke, role, reason); err != nil {
+		return models.User{}, err
+	}
+	return userService.GetUserByID(ctx, userID)
+}
+
+func (s *RoleService) GetRoleChanges(ctx context.Context, userID gocql.UUID, limit uint) ([]models.RoleChange, error) {
+	var changes []models.RoleChange
+
+	stmt, names := qb.Select(models.RoleChangeTable.Name).
+		Where(qb.Eq("user_id")).
+		Limit(limit).
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"user_id": userID})
+
+	if err := q.SelectRelease(&changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+func (s *RoleService) record(ctx context.Context, userID, actorID gocql.UUID, action, role, reason string) error {
+	change := models.RoleChange{
+		UserID:    userID,
+		ID:        gocql.TimeUUID(),
+		Action:    action,
+		Role:      role,
+		ActorID:   actorID,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	}
+
+	stmt, names := qb.Insert(models.RoleChangeTable.Name).
+		Columns(models.RoleChangeTable.Columns...).
+		ToCql()
+
+	return gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindStruct(change).
+		ExecRelease()
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -3,69 +3,199 @@ package services
 import (
 	"context"
 	"errors"
-	"fmt"
 	"go-fundraising/auth/models"
 	"go-fundraising/db"
 	"log"
-	"time"
+	"strings"
 
 	"github.com/gocql/gocql"
 	"github.com/scylladb/gocqlx"
 	"github.com/scylladb/gocqlx/qb"
+	"github.com/scylladb/gocqlx/table"
 )
 
 type UserService struct{}
 
+// DeletedUsername replaces the username of a deleted account wherever its
+// comments, donations and campaigns remain.
+const DeletedUsername = "deleted-user"
+
+var (
+	ErrUsernameTaken = errors.New("username already taken")
+	ErrEmailTaken    = errors.New("email already registered")
+)
+
+// NewUser claims the user's username and email in their lookup tables before
+// writing the user, so two concurrent sign-ups cannot end up sharing either.
 func (s *UserService) NewUser(ctx context.Context, user models.User) error {
+	// Accounts from before the lookup tables are only claimed once looked up.
+	if _, err := s.GetUserByUsername(ctx, user.Username); err == nil {
+		return ErrUsernameTaken
+	} else if !errors.Is(err, gocql.ErrNotFound) {
+		return err
+	}
+	if _, err := s.GetUserByEmail(ctx, user.Email); err == nil {
+		return ErrEmailTaken
+	} else if !errors.Is(err, gocql.ErrNotFound) {
+		return err
+	}
+
+	username := NormalizeUsername(user.Username)
+	user.Email = NormalizeEmail(user.Email)
+
+	claimed, err := s.claim(ctx, models.UserByUsernameTable, "username", username, user.ID)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrUsernameTaken
+	}
+
+	claimed, err = s.claim(ctx, models.UserByEmailTable, "email", user.Email, user.ID)
+	if err != nil || !claimed {
+		if releaseErr := s.release(ctx, models.UserByUsernameTable, "username", username, user.ID); releaseErr != nil {
+			log.Println("❌ Failed to release username claim:", username, releaseErr)
+		}
+		if err != nil {
+			return err
+		}
+		return ErrEmailTaken
+	}
+
 	stmt, names := qb.Insert(models.UserTable.Name).
 		Columns(models.UserTable.Columns...).
 		ToCql()
 
 	q := gocqlx.Query(db.ScyllaSession.Query(stmt), names).BindStruct(user)
 
-	return q.ExecRelease()
+	// Without the user row the claims would hold both names forever.
+	if err := q.ExecRelease(); err != nil {
+		if releaseErr := s.release(ctx, models.UserByUsernameTable, "username", username, user.ID); releaseErr != nil {
+			log.Println("❌ Failed to release username claim:", username, releaseErr)
+		}
+		if releaseErr := s.release(ctx, models.UserByEmailTable, "email", user.Email, user.ID); releaseErr != nil {
+			log.Println("❌ Failed to release email claim:", user.Email, releaseErr)
+		}
+		return err
+	}
+	return nil
 }
 
+// GetUserByUsername finds a user by username, ignoring case.
 func (s *UserService) GetUserByUsername(ctx context.Context, username string) (models.User, error) {
-	var user models.User
+	return s.getUserByLookup(ctx, models.UserByUsernameTable, "username", NormalizeUsername(username), username)
+}
 
-	stmt, names := qb.Select(models.UserTable.Name).Where(qb.Eq("username")).ToCql()
-	q := gocqlx.Query(db.ScyllaSession.Query(stmt), names).BindMap(map[string]interface{}{
-		"username": username,
-	})
+// GetUserByEmail finds a user by email, ignoring case.
+func (s *UserService) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	return s.getUserByLookup(ctx, models.UserByEmailTable, "email", NormalizeEmail(email), email)
+}
 
+func (s *UserService) CheckUsernameExists(ctx context.Context, username string) (bool, error) {
+	_, err := s.GetUserByUsername(ctx, username)
+	if err != nil {
+		if errors.Is(err, gocql.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// getUserByLookup resolves key through its lookup table. Users created before
+// the lookup tables are found through the users table index instead, by the
+// value exactly as given, and claimed in the lookup table on the way.
+func (s *UserService) getUserByLookup(ctx context.Context, lookup table.Metadata, column, key, raw string) (models.User, error) {
+	userID, err := s.lookup(ctx, lookup, column, key)
+	if err == nil {
+		return s.GetUserByID(ctx, userID)
+	}
+	if !errors.Is(err, gocql.ErrNotFound) {
+		return models.User{}, err
+	}
+
+	var user models.User
+	stmt, names := qb.Select(models.UserTable.Name).Where(qb.Eq(column)).Limit(1).ToCql()
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).BindMap(map[string]interface{}{
+		column: raw,
+	})
 	if err := q.GetRelease(&user); err != nil {
 		return models.User{}, err
 	}
+
+	claimed, err := s.claim(ctx, lookup, column, key, user.ID)
+	if err != nil {
+		return models.User{}, err
+	}
+	if !claimed {
+		// Another account already owns the normalized value.
+		return models.User{}, gocql.ErrNotFound
+	}
 	return user, nil
 }
 
-func (s *UserService) CheckUsernameExists(ctx context.Context, username string) (bool, error) {
-	stmt, names := qb.Select(models.UserTable.Name).
-		Columns("id").
-		Where(qb.Eq("username")).
-		Limit(1).
-		ToCql()
-
-	var result struct {
-		ID gocql.UUID `db:"id"`
+func (s *UserService) lookup(ctx context.Context, lookup table.Metadata, column, key string) (gocql.UUID, error) {
+	var row struct {
+		UserID gocql.UUID `db:"user_id"`
 	}
 
-	q := gocqlx.Query(db.ScyllaSession.Query(stmt), names).
-		BindMap(map[string]interface{}{"username": username})
-
-	err := q.Get(&result)
+	stmt, names := qb.Select(lookup.Name).Columns("user_id").Where(qb.Eq(column)).ToCql()
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).BindMap(map[string]interface{}{
+		column: key,
+	})
+	if err := q.GetRelease(&row); err != nil {
+		return gocql.UUID{}, err
+	}
+	return row.UserID, nil
+}
 
-	log.Println(result)
+// claim takes key for userID and reports whether it succeeded. Claiming a key
+// the user already holds also succeeds.
+func (s *UserService) claim(ctx context.Context, lookup table.Metadata, column, key string, userID gocql.UUID) (bool, error) {
+	stmt, names := qb.Insert(lookup.Name).
+		Columns(lookup.Columns...).
+		Unique().
+		ToCql()
 
+	existing := map[string]interface{}{}
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).BindMap(map[string]interface{}{
+		column:    key,
+		"user_id": userID,
+	})
+	applied, err := q.MapScanCAS(existing)
+	q.Release()
 	if err != nil {
-		if err == gocql.ErrNotFound {
-			return false, nil
-		}
 		return false, err
 	}
+	if applied {
+		return true, nil
+	}
+	owner, _ := existing["user_id"].(gocql.UUID)
+	return owner == userID, nil
+}
 
-	return true, nil
+func (s *UserService) release(ctx context.Context, lookup table.Metadata, column, key string, userID gocql.UUID) error {
+	stmt, names := qb.Delete(lookup.Name).
+		Where(qb.Eq(column)).
+		If(qb.Eq("user_id")).
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).BindMap(map[string]interface{}{
+		column:    key,
+		"user_id": userID,
+	})
+	_, err := q.MapScanCAS(map[string]interface{}{})
+	q.Release()
+	return err
+}
+
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
 }
 
 func (s *UserService) GetUserByID(ctx context.Context, user_id gocql.UUID) (models.User, error) {
@@ -82,56 +212,99 @@ func (s *UserService) GetUserByID(ctx context.Context, user_id gocql.UUID) (mode
 	return user, nil
 }
 
-func (s *UserService) SaveRefreshToken(ctx context.Context, token string, userID gocql.UUID, expiresAt time.Time) error {
-	stmt, names := qb.Insert(models.RefreshTokenTable.Name).Columns(models.RefreshTokenTable.Columns...).ToCql()
-	q := gocqlx.Query(db.ScyllaSession.Query(stmt), names).BindMap(map[string]interface{}{
-		"refresh_token": token,
-		"user_id":       userID,
-		"expires_at":    expiresAt,
-	})
-	return q.ExecRelease()
+func (s *UserService) MarkEmailVerified(ctx context.Context, userID gocql.UUID) error {
+	stmt, names := qb.Update(models.UserTable.Name).
+		Set("email_verified").
+		Where(qb.Eq("id")).
+		ToCql()
+
+	return gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{
+			"id":             userID,
+			"email_verified": true,
+		}).
+		ExecRelease()
 }
 
-func (s *UserService) ValidateRefreshToken(ctx context.Context, token string) (gocql.UUID, error) {
-	var row struct {
-		UserID    gocql.UUID `db:"user_id"`
-		ExpiresAt time.Time  `db:"expires_at"`
-	}
+func (s *UserService) UpdatePasswordHash(ctx context.Context, userID gocql.UUID, passwordHash string) error {
+	stmt, names := qb.Update(models.UserTable.Name).
+		Set("password_hash").
+		Where(qb.Eq("id")).
+		ToCql()
+
+	return gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{
+			"id":            userID,
+			"password_hash": passwordHash,
+		}).
+		ExecRelease()
+}
 
-	stmt, names := qb.Select(models.RefreshTokenTable.Name).
-		Columns("user_id", "expires_at").
-		Where(qb.Eq("refresh_token")).
+// UpdateProfile saves the user's display name, avatar and bio.
+func (s *UserService) UpdateProfile(ctx context.Context, user models.User) error {
+	stmt, names := qb.Update(models.UserTable.Name).
+		Set("display_name", "avatar_url", "bio").
+		Where(qb.Eq("id")).
 		ToCql()
 
-	q := gocqlx.Query(db.ScyllaSession.Query(stmt), names).BindMap(map[string]interface{}{
-		"refresh_token": token,
-	})
+	return gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindStruct(user).
+		ExecRelease()
+}
 
-	if err := q.GetRelease(&row); err != nil {
-		if errors.Is(err, gocql.ErrNotFound) {
-			return gocql.UUID{}, fmt.Errorf("refresh token not found")
-		}
-		return gocql.UUID{}, err
+// ChangeEmail moves the user to newEmail, which the caller must have
+// verified, and frees the old address for others.
+func (s *UserService) ChangeEmail(ctx context.Context, user models.User, newEmail string) error {
+	newEmail = NormalizeEmail(newEmail)
+
+	if existing, err := s.GetUserByEmail(ctx, newEmail); err == nil && existing.ID != user.ID {
+		return ErrEmailTaken
+	} else if err != nil && !errors.Is(err, gocql.ErrNotFound) {
+		return err
 	}
 
-	userID := row.UserID
-	expiresAt := row.ExpiresAt
+	claimed, err := s.claim(ctx, models.UserByEmailTable, "email", newEmail, user.ID)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrEmailTaken
+	}
 
-	if time.Now().After(expiresAt) {
-		_ = s.DeleteRefreshToken(ctx, token)
-		return gocql.UUID{}, fmt.Errorf("refresh token expired")
+	stmt, names := qb.Update(models.UserTable.Name).
+		Set("email", "email_verified").
+		Where(qb.Eq("id")).
+		ToCql()
+	err = gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{
+			"id":             user.ID,
+			"email":          newEmail,
+			"email_verified": true,
+		}).
+		ExecRelease()
+	if err != nil {
+		return err
 	}
 
-	return userID, nil
+	if old := NormalizeEmail(user.Email); old != newEmail {
+		if err := s.release(ctx, models.UserByEmailTable, "email", old, user.ID); err != nil {
+			log.Println("❌ Failed to release old email claim:", old, err)
+		}
+	}
+	return nil
 }
 
-func (s *UserService) DeleteRefreshToken(ctx context.Context, token string) error {
-	stmt, names := qb.Delete(models.RefreshTokenTable.Name).
-		Where(qb.Eq("refresh_token")).ToCql()
-
-	q := gocqlx.Query(db.ScyllaSession.Query(stmt), names).BindMap(map[string]interface{}{
-		"refresh_token": token,
-	})
+// DeleteUser removes the user row and frees the username and email.
+func (s *UserService) DeleteUser(ctx context.Context, user models.User) error {
+	if err := s.release(ctx, models.UserByUsernameTable, "username", NormalizeUsername(user.Username), user.ID); err != nil {
+		return err
+	}
+	if err := s.release(ctx, models.UserByEmailTable, "email", NormalizeEmail(user.Email), user.ID); err != nil {
+		return err
+	}
 
-	return q.ExecRelease()
+	stmt, names := qb.Delete(models.UserTable.Name).Where(qb.Eq("id")).ToCql()
+	return gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"id": user.ID}).
+		ExecRelease()
 }
@@ -0,0 +1,64 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps state in process, for a single node.
+type MemoryStore struct {
+	mu        sync.Mutex
+	states    map[string]State
+	window    time.Duration
+	lastSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{states: map[string]State{}}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.states[key], nil
+}
+
+func (s *MemoryStore) RecordFailure(ctx context.Context, key string, now time.Time, policy Policy) (State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := policy.next(s.states[key], now)
+	s.states[key] = state
+
+	if policy.Window > s.window {
+		s.window = policy.Window
+	}
+	s.sweepLocked(now)
+	return state, nil
+}
+
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.states, key)
+	return nil
+}
+
+// sweepLocked drops keys that are neither blocked nor inside the window, so
+// the map does not grow with every address that ever failed once. It runs at
+// most once a minute.
+func (s *MemoryStore) sweepLocked(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+
+	for key, state := range s.states {
+		if now.After(state.BlockedUntil) && now.Sub(state.LastFailure) > s.window {
+			delete(s.states, key)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package throttle
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 1; i <= testPolicy.LockoutAttempts; i++ {
+		state, err := store.RecordFailure(ctx, "locked", start, testPolicy)
+		if err != nil {
+			t.Fatalf("record failure: %v", err)
+		}
+		if state.Failures != i {
+			t.Fatalf("failures = %d, want %d", state.Failures, i)
+		}
+	}
+	if _, err := store.RecordFailure(ctx, "stale", start, testPolicy); err != nil {
+		t.Fatalf("record failure: %v", err)
+	}
+	if _, err := store.RecordFailure(ctx, "reset", start, testPolicy); err != nil {
+		t.Fatalf("record failure: %v", err)
+	}
+
+	if state, _ := store.Get(ctx, "locked"); !state.BlockedUntil.Equal(start.Add(testPolicy.LockoutDuration)) {
+		t.Errorf("locked state = %+v, want blocked for the lockout", state)
+	}
+	if err := store.Reset(ctx, "reset"); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	if state, _ := store.Get(ctx, "reset"); state != (State{}) {
+		t.Errorf("state after reset = %+v, want none", state)
+	}
+
+	// Past the window the sweep forgets keys that are not blocked.
+	later := start.Add(testPolicy.Window + 2*time.Minute)
+	if _, err := store.RecordFailure(ctx, "other", later, testPolicy); err != nil {
+		t.Fatalf("record failure: %v", err)
+	}
+	if state, _ := store.Get(ctx, "stale"); state != (State{}) {
+		t.Errorf("stale state = %+v, want it swept", state)
+	}
+	if state, _ := store.Get(ctx, "locked"); state.Failures != testPolicy.LockoutAttempts {
+		t.Errorf("locked state = %+v, want it kept while blocked", state)
+	}
+
+	// A failure after the window starts the count again.
+	state, err := store.RecordFailure(ctx, "locked", start.Add(testPolicy.LockoutDuration+time.Minute), testPolicy)
+	if err != nil {
+		t.Fatalf("record failure: %v", err)
+	}
+	if state.Failures != 1 || !state.BlockedUntil.IsZero() {
+		t.Errorf("state after the window = %+v, want one free failure", state)
+	}
+}
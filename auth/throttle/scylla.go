@@ -0,0 +1,120 @@
+package throttle
+
+import (
+	"context"
+	"errors"
+	"go-fundraising/auth/models"
+	"go-fundraising/db"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+)
+
+// ScyllaStore shares state between nodes through the login_attempts table.
+// Rows carry a TTL so idle keys disappear on their own.
+type ScyllaStore struct{}
+
+func (ScyllaStore) Get(ctx context.Context, key string) (State, error) {
+	row, err := getAttempt(ctx, key)
+	if err != nil {
+		if errors.Is(err, gocql.ErrNotFound) {
+			return State{}, nil
+		}
+		return State{}, err
+	}
+	return State{Failures: row.Failures, LastFailure: row.LastFailure, BlockedUntil: row.BlockedUntil}, nil
+}
+
+// RecordFailure is a compare-and-set loop on the failure count, so
+// concurrent failures on different nodes are all counted.
+func (ScyllaStore) RecordFailure(ctx context.Context, key string, now time.Time, policy Policy) (State, error) {
+	insertStmt, insertNames := qb.Insert(models.LoginAttemptTable.Name).
+		Columns(models.LoginAttemptTable.Columns...).
+		Unique().
+		TTLNamed("ttl").
+		ToCql()
+	updateStmt, updateNames := qb.Update(models.LoginAttemptTable.Name).
+		TTLNamed("ttl").
+		Set("failures", "last_failure", "blocked_until").
+		Where(qb.Eq("key")).
+		If(qb.EqNamed("failures", "previous")).
+		ToCql()
+
+	for {
+		var previous State
+		exists := true
+		row, err := getAttempt(ctx, key)
+		switch {
+		case errors.Is(err, gocql.ErrNotFound):
+			exists = false
+		case err != nil:
+			return State{}, err
+		default:
+			previous = State{Failures: row.Failures, LastFailure: row.LastFailure, BlockedUntil: row.BlockedUntil}
+		}
+
+		state := policy.next(previous, now)
+		ttl := policy.Window
+		if d := state.BlockedUntil.Sub(now); d > ttl {
+			ttl = d
+		}
+		attempt := models.LoginAttempt{
+			Key:          key,
+			Failures:     state.Failures,
+			LastFailure:  state.LastFailure,
+			BlockedUntil: state.BlockedUntil,
+		}
+		extra := map[string]interface{}{
+			"ttl":      qb.TTL(ttl),
+			"previous": previous.Failures,
+		}
+
+		var q *gocqlx.Queryx
+		if exists {
+			q = gocqlx.Query(db.ScyllaSession.Query(updateStmt).WithContext(ctx), updateNames).BindStructMap(attempt, extra)
+		} else {
+			q = gocqlx.Query(db.ScyllaSession.Query(insertStmt).WithContext(ctx), insertNames).BindStructMap(attempt, extra)
+		}
+		applied, err := q.MapScanCAS(map[string]interface{}{})
+		q.Release()
+		if err != nil {
+			return State{}, err
+		}
+		if applied {
+			return state, nil
+		}
+	}
+}
+
+func (ScyllaStore) Reset(ctx context.Context, key string) error {
+	// Conditional like every other write to the row, so it is ordered with
+	// the compare-and-set updates.
+	stmt, names := qb.Delete(models.LoginAttemptTable.Name).
+		Where(qb.Eq("key")).
+		Existing().
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"key": key})
+	_, err := q.MapScanCAS(map[string]interface{}{})
+	q.Release()
+	return err
+}
+
+func getAttempt(ctx context.Context, key string) (models.LoginAttempt, error) {
+	var row models.LoginAttempt
+
+	stmt, names := qb.Select(models.LoginAttemptTable.Name).
+		Where(qb.Eq("key")).
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"key": key})
+
+	if err := q.GetRelease(&row); err != nil {
+		return models.LoginAttempt{}, err
+	}
+	return row, nil
+}
@@ -0,0 +1,196 @@
+// Package throttle slows down password guessing. Failed logins are counted
+// per account and per client IP; past a few free attempts each further
+// failure doubles the wait before the next try, and enough of them lock the
+// key out for a while.
+package throttle
+
+import (
+	"context"
+	"go-fundraising/configs"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// State is what a store remembers about one key.
+type State struct {
+	Failures     int
+	LastFailure  time.Time
+	BlockedUntil time.Time
+}
+
+// Store keeps failure state per key. State older than the policy window is
+// forgotten.
+type Store interface {
+	Get(ctx context.Context, key string) (State, error)
+	// RecordFailure counts a failure for key and returns the new state.
+	RecordFailure(ctx context.Context, key string, now time.Time, policy Policy) (State, error)
+	Reset(ctx context.Context, key string) error
+}
+
+type Policy struct {
+	FreeAttempts    int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	LockoutAttempts int
+	LockoutDuration time.Duration
+	Window          time.Duration
+}
+
+// next applies one more failure to state.
+func (p Policy) next(state State, now time.Time) State {
+	if now.Sub(state.LastFailure) > p.Window {
+		state = State{}
+	}
+	state.Failures++
+	state.LastFailure = now
+
+	switch {
+	case p.LockoutAttempts > 0 && state.Failures >= p.LockoutAttempts:
+		state.BlockedUntil = now.Add(p.LockoutDuration)
+	case state.Failures > p.FreeAttempts:
+		delay := p.BaseDelay << (state.Failures - p.FreeAttempts - 1)
+		if delay <= 0 || delay > p.MaxDelay {
+			delay = p.MaxDelay
+		}
+		state.BlockedUntil = now.Add(delay)
+	}
+	return state
+}
+
+// scaled is the policy for keys shared by many accounts, such as an IP.
+func (p Policy) scaled(factor int) Policy {
+	p.FreeAttempts *= factor
+	p.LockoutAttempts *= factor
+	return p
+}
+
+// PolicyFromEnv reads the LOGIN_* settings, falling back to defaults.
+func PolicyFromEnv() Policy {
+	return Policy{
+		FreeAttempts:    envInt("LOGIN_FREE_ATTEMPTS", 5),
+		BaseDelay:       envDuration("LOGIN_BASE_DELAY", time.Second),
+		MaxDelay:        envDuration("LOGIN_MAX_DELAY", 15*time.Minute),
+		LockoutAttempts: envInt("LOGIN_LOCKOUT_ATTEMPTS", 10),
+		LockoutDuration: envDuration("LOGIN_LOCKOUT_DURATION", 30*time.Minute),
+		Window:          envDuration("LOGIN_ATTEMPT_WINDOW", time.Hour),
+	}
+}
+
+type Throttler struct {
+	store    Store
+	account  Policy
+	ip       Policy
+	ipFactor int
+}
+
+func NewThrottler(store Store, policy Policy, ipFactor int) *Throttler {
+	if ipFactor < 1 {
+		ipFactor = 1
+	}
+	return &Throttler{store: store, account: policy, ip: policy.scaled(ipFactor)}
+}
+
+// Check returns how long the caller must wait before trying account from ip
+// again, or zero when they may try now.
+func (t *Throttler) Check(ctx context.Context, account, ip string) (time.Duration, error) {
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range []string{accountKey(account), ipKey(ip)} {
+		state, err := t.store.Get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if d := state.BlockedUntil.Sub(now); d > wait {
+			wait = d
+		}
+	}
+	return wait, nil
+}
+
+// Failure records a failed attempt on account from ip and returns the wait
+// now imposed.
+func (t *Throttler) Failure(ctx context.Context, account, ip string) (time.Duration, error) {
+	now := time.Now()
+
+	accountState, err := t.store.RecordFailure(ctx, accountKey(account), now, t.account)
+	if err != nil {
+		return 0, err
+	}
+	ipState, err := t.store.RecordFailure(ctx, ipKey(ip), now, t.ip)
+	if err != nil {
+		return 0, err
+	}
+
+	wait := accountState.BlockedUntil.Sub(now)
+	if d := ipState.BlockedUntil.Sub(now); d > wait {
+		wait = d
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, nil
+}
+
+// Success clears the account's failures. The IP's are kept, so one valid
+// login does not reset a spray across many accounts.
+func (t *Throttler) Success(ctx context.Context, account string) error {
+	return t.store.Reset(ctx, accountKey(account))
+}
+
+func accountKey(account string) string {
+	return "account:" + account
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+var (
+	defaultThrottler *Throttler
+	defaultOnce      sync.Once
+)
+
+// Default returns the throttler configured by the LOGIN_* settings, storing
+// state in memory or, with LOGIN_THROTTLE_STORE=scylla, in Scylla so every
+// node sees the same counts.
+func Default() *Throttler {
+	defaultOnce.Do(func() {
+		var store Store
+		switch configs.GetEnv("LOGIN_THROTTLE_STORE") {
+		case "scylla":
+			store = ScyllaStore{}
+		default:
+			store = NewMemoryStore()
+		}
+		defaultThrottler = NewThrottler(store, PolicyFromEnv(), envInt("LOGIN_IP_FACTOR", 5))
+	})
+	return defaultThrottler
+}
+
+func envInt(key string, fallback int) int {
+	raw := configs.GetEnv(key)
+	if raw == "" {
+		return fallback
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < 0 {
+		log.Printf("⚠️ Invalid %s=%q, using %d\n", key, raw, fallback)
+		return fallback
+	}
+	return v
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	raw := configs.GetEnv(key)
+	if raw == "" {
+		return fallback
+	}
+	v, err := time.ParseDuration(raw)
+	if err != nil || v <= 0 {
+		log.Printf("⚠️ Invalid %s=%q, using %s\n", key, raw, fallback)
+		return fallback
+	}
+	return v
+}
@@ -0,0 +1,129 @@
+package throttle
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// testPolicy gives two free attempts, then waits of 1s, 2s and at most 3s,
+// and locks the key out for an hour on the sixth failure.
+var testPolicy = Policy{
+	FreeAttempts:    2,
+	BaseDelay:       time.Second,
+	MaxDelay:        3 * time.Second,
+	LockoutAttempts: 6,
+	LockoutDuration: time.Hour,
+	Window:          10 * time.Minute,
+}
+
+func TestPolicyNext(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	noLockout := testPolicy
+	noLockout.LockoutAttempts = 0
+
+	tests := []struct {
+		name         string
+		policy       Policy
+		state        State
+		wantFailures int
+		wantWait     time.Duration
+	}{
+		{"first failure is free", testPolicy, State{}, 1, 0},
+		{"last free failure", testPolicy, State{Failures: 1, LastFailure: now}, 2, 0},
+		{"first delay", testPolicy, State{Failures: 2, LastFailure: now}, 3, time.Second},
+		{"delay doubles", testPolicy, State{Failures: 3, LastFailure: now}, 4, 2 * time.Second},
+		{"delay capped at MaxDelay", testPolicy, State{Failures: 4, LastFailure: now}, 5, 3 * time.Second},
+		{"lockout", testPolicy, State{Failures: 5, LastFailure: now}, 6, time.Hour},
+		{"still locked out", testPolicy, State{Failures: 8, LastFailure: now}, 9, time.Hour},
+		{"no lockout configured", noLockout, State{Failures: 8, LastFailure: now}, 9, 3 * time.Second},
+		{"shift overflow capped", noLockout, State{Failures: 200, LastFailure: now}, 201, 3 * time.Second},
+		{"inside window", testPolicy, State{Failures: 2, LastFailure: now.Add(-9 * time.Minute)}, 3, time.Second},
+		{"window resets count", testPolicy, State{Failures: 5, LastFailure: now.Add(-11 * time.Minute), BlockedUntil: now}, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.policy.next(tt.state, now)
+			if got.Failures != tt.wantFailures || !got.LastFailure.Equal(now) {
+				t.Errorf("state = %+v, want %d failures, the last now", got, tt.wantFailures)
+			}
+			var wait time.Duration
+			if !got.BlockedUntil.IsZero() {
+				wait = got.BlockedUntil.Sub(now)
+			}
+			if wait != tt.wantWait {
+				t.Errorf("blocked for %s, want %s", wait, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestPolicyScaled(t *testing.T) {
+	got := testPolicy.scaled(5)
+	want := testPolicy
+	want.FreeAttempts = 10
+	want.LockoutAttempts = 30
+	if got != want {
+		t.Errorf("scaled = %+v, want %+v", got, want)
+	}
+}
+
+func TestThrottler(t *testing.T) {
+	ctx := context.Background()
+	throttler := NewThrottler(NewMemoryStore(), testPolicy, 2)
+
+	fail := func(account, ip string) time.Duration {
+		t.Helper()
+		wait, err := throttler.Failure(ctx, account, ip)
+		if err != nil {
+			t.Fatalf("failure: %v", err)
+		}
+		return wait
+	}
+	check := func(account, ip string) time.Duration {
+		t.Helper()
+		wait, err := throttler.Check(ctx, account, ip)
+		if err != nil {
+			t.Fatalf("check: %v", err)
+		}
+		return wait
+	}
+
+	// The account runs out of free attempts before the IP does.
+	for i := 0; i < testPolicy.FreeAttempts; i++ {
+		if wait := fail("alice", "10.0.0.1"); wait != 0 {
+			t.Fatalf("free failure %d waits %s", i+1, wait)
+		}
+	}
+	if wait := fail("alice", "10.0.0.1"); wait <= 0 {
+		t.Fatal("failure past the free attempts does not wait")
+	}
+	if check("alice", "10.0.0.2") <= 0 {
+		t.Error("blocked account can be tried from another IP")
+	}
+	if check("bob", "10.0.0.1") != 0 {
+		t.Error("IP is blocked before its scaled free attempts are used")
+	}
+
+	// The IP allows twice as many failures, spread over accounts.
+	if wait := fail("bob", "10.0.0.1"); wait != 0 {
+		t.Fatalf("fourth failure from the IP waits %s", wait)
+	}
+	if wait := fail("carol", "10.0.0.1"); wait <= 0 {
+		t.Fatal("failure past the IP's free attempts does not wait")
+	}
+	if check("dave", "10.0.0.1") <= 0 {
+		t.Error("spraying IP can try a fresh account")
+	}
+
+	// Success clears the account but not the IP.
+	if err := throttler.Success(ctx, "alice"); err != nil {
+		t.Fatalf("success: %v", err)
+	}
+	if check("alice", "10.0.0.2") != 0 {
+		t.Error("account still blocked after a success")
+	}
+	if check("alice", "10.0.0.1") <= 0 {
+		t.Error("success cleared the IP's failures")
+	}
+}
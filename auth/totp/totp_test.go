@@ -0,0 +1,91 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of RFC 6238, Appendix B, in base32.
+var rfcSecret = encoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeMatchesRFC6238(t *testing.T) {
+	// The RFC lists eight digits; the last six are the six-digit code.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("Code(%d): %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("Code(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := step(now)
+
+	codeAt := func(offset int64) string {
+		c, err := Code(rfcSecret, now.Add(time.Duration(offset)*Period))
+		if err != nil {
+			t.Fatalf("Code: %v", err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name      string
+		candidate string
+		wantStep  int64
+		wantOK    bool
+	}{
+		{"current step", codeAt(0), current, true},
+		{"one step behind", codeAt(-1), current - 1, true},
+		{"one step ahead", codeAt(1), current + 1, true},
+		{"two steps behind", codeAt(-2), 0, false},
+		{"two steps ahead", codeAt(2), 0, false},
+		{"spaces and padding", " " + codeAt(0)[:3] + " " + codeAt(0)[3:] + " ", current, true},
+		{"wrong code", "000000", 0, false},
+		{"too short", codeAt(0)[:5], 0, false},
+		{"too long", codeAt(0) + "0", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, gotOK := Validate(rfcSecret, tt.candidate, now)
+			if gotStep != tt.wantStep || gotOK != tt.wantOK {
+				t.Errorf("Validate(%q) = %d, %v, want %d, %v", tt.candidate, gotStep, gotOK, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestValidateRejectsMalformedSecret(t *testing.T) {
+	if _, ok := Validate("not base32!", "123456", time.Now()); ok {
+		t.Error("Validate accepted a code for a malformed secret")
+	}
+	if _, err := Code("not base32!", time.Now()); err == nil {
+		t.Error("Code accepted a malformed secret")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret: %v", err)
+	}
+	key, err := decode(secret)
+	if err != nil || len(key) != 20 {
+		t.Errorf("secret %q decodes to %d bytes, %v, want 20", secret, len(key), err)
+	}
+}
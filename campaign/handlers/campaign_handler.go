@@ -2,14 +2,20 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"go-fundraising/auth/roles"
 	auth "go-fundraising/auth/services"
 	"go-fundraising/campaign/models"
 	campaign "go-fundraising/campaign/services"
+	"go-fundraising/currency"
+	ledger "go-fundraising/ledger/services"
+	"go-fundraising/middleware"
 	models2 "go-fundraising/payment/models"
 	payment "go-fundraising/payment/services"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -19,16 +25,24 @@ import (
 var userService = auth.UserService{}
 var campaignService = campaign.CampaignService{}
 var paymentService = payment.PaymentService{}
+var ledgerService = ledger.LedgerService{}
 
 type CampaignWithPayments struct {
 	ID              gocql.UUID               `json:"ID"`
 	Title           string                   `json:"Title"`
 	Description     string                   `json:"Description"`
 	Target          int                      `json:"Target"`
-	AmountCollected int                      `json:"AmountCollected"`
+	Currency        string                   `json:"Currency"`
+	AmountCollected int64                    `json:"AmountCollected"`
+	Totals          map[string]int64         `json:"Totals"`
+	DonationCount   int                      `json:"DonationCount"`
 	Image           string                   `json:"Image"`
+	Category        string                   `json:"Category"`
+	Status          string                   `json:"Status"`
+	EndOnTarget     bool                     `json:"EndOnTarget"`
 	Deadline        time.Time                `json:"Deadline"`
 	CreatedAt       time.Time                `json:"CreatedAt"`
+	Balances        map[string]int64         `json:"Balances"`
 	Payments        []models2.PaymentHistory `json:"Payments"`
 }
 
@@ -37,8 +51,12 @@ func CreateCampaignHandler(c *gin.Context) {
 		Title       string    `json:"title"`
 		Description string    `json:"description"`
 		Target      int       `json:"target"`
+		Currency    string    `json:"currency"`
 		Image       string    `json:"image"`
+		Category    string    `json:"category"`
 		Deadline    time.Time `json:"deadline"`
+		Status      string    `json:"status"`
+		EndOnTarget bool      `json:"end_on_target"`
 	}
 
 	raw, _ := c.Get("user_id")
@@ -55,6 +73,37 @@ func CreateCampaignHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
+	if request.Target <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "target must be positive"})
+		return
+	}
+	// A campaign without a deadline runs until it is ended.
+	if !request.Deadline.IsZero() && !request.Deadline.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "deadline must be in the future"})
+		return
+	}
+	if request.Status == "" {
+		request.Status = models.StatusActive
+	}
+	if request.Status != models.StatusActive && request.Status != models.StatusDraft {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be draft or active"})
+		return
+	}
+	if request.Category == "" {
+		request.Category = models.CategoryOther
+	}
+	if !models.IsValidCategory(request.Category) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown category"})
+		return
+	}
+	if request.Currency == "" {
+		request.Currency = currency.Default
+	}
+	code, ok := currency.Normalize(request.Currency)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid currency"})
+		return
+	}
 	log.Println(user)
 	CurrentCampaign := models.Campaign{
 		ID:              gocql.TimeUUID(),
@@ -63,8 +112,12 @@ func CreateCampaignHandler(c *gin.Context) {
 		Title:           request.Title,
 		Description:     request.Description,
 		Target:          request.Target,
+		Currency:        code,
 		Image:           request.Image,
+		Category:        request.Category,
 		AmountCollected: 0,
+		Status:          request.Status,
+		EndOnTarget:     request.EndOnTarget,
 		Deadline:        request.Deadline,
 		CreatedAt:       time.Now(),
 	}
@@ -80,6 +133,190 @@ func CreateCampaignHandler(c *gin.Context) {
 	})
 }
 
+func UpdateCampaignHandler(c *gin.Context) {
+	var request struct {
+		Title       *string    `json:"title"`
+		Description *string    `json:"description"`
+		Target      *int       `json:"target"`
+		Image       *string    `json:"image"`
+		Category    *string    `json:"category"`
+		Deadline    *time.Time `json:"deadline"`
+	}
+
+	campaign, ok := loadOwnedCampaign(c, "")
+	if !ok {
+		return
+	}
+	if campaign.Status == models.StatusEnded || campaign.Status == models.StatusCancelled || campaign.Status == models.StatusDeleting {
+		c.JSON(http.StatusConflict, gin.H{"error": "Campaign is " + campaign.Status + " and can no longer be edited"})
+		return
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if c.Request.Method == http.MethodPut &&
+		(request.Title == nil || request.Description == nil || request.Target == nil || request.Image == nil || request.Deadline == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "PUT requires title, description, target, image and deadline"})
+		return
+	}
+
+	if request.Title != nil {
+		campaign.Title = *request.Title
+	}
+	if request.Description != nil {
+		campaign.Description = *request.Description
+	}
+	if request.Target != nil {
+		if *request.Target <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "target must be positive"})
+			return
+		}
+		campaign.Target = *request.Target
+	}
+	if request.Image != nil {
+		campaign.Image = *request.Image
+	}
+	if request.Category != nil {
+		if !models.IsValidCategory(*request.Category) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown category"})
+			return
+		}
+		campaign.Category = *request.Category
+	}
+	if request.Deadline != nil {
+		if !request.Deadline.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "deadline must be in the future"})
+			return
+		}
+		campaign.Deadline = *request.Deadline
+	}
+
+	updated, err := campaignService.UpdateCampaign(c, campaign)
+	if err != nil {
+		log.Print(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update campaign"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Campaign successfully updated",
+		"campaign": updated,
+	})
+}
+
+func UpdateCampaignStatusHandler(c *gin.Context) {
+	var request struct {
+		Status string `json:"status" binding:"required"`
+	}
+
+	current, ok := loadOwnedCampaign(c, roles.ModerateCampaign)
+	if !ok {
+		return
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status is required"})
+		return
+	}
+	if !models.IsValidStatus(request.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown status"})
+		return
+	}
+
+	updated, err := campaignService.UpdateCampaignStatus(c, current, request.Status)
+	if err != nil {
+		switch {
+		case errors.Is(err, campaign.ErrInvalidStatusTransition):
+			c.JSON(http.StatusConflict, gin.H{"error": "Cannot move campaign from " + current.Status + " to " + request.Status})
+		case errors.Is(err, campaign.ErrCampaignStatusChanged):
+			c.JSON(http.StatusConflict, gin.H{"error": "Campaign status changed, please retry"})
+		default:
+			log.Print(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update campaign status"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Campaign status updated",
+		"campaign": updated,
+	})
+}
+
+func DeleteCampaignHandler(c *gin.Context) {
+	current, ok := loadOwnedCampaign(c, roles.ModerateCampaign)
+	if !ok {
+		return
+	}
+
+	// No donation can start once the campaign is marked deleting, so one
+	// cannot slip in between the check for payments and the delete.
+	stored, err := campaignService.MarkCampaignDeleting(c, current.ID)
+	if err != nil {
+		if errors.Is(err, campaign.ErrCampaignStatusChanged) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Campaign status changed, try again"})
+			return
+		}
+		log.Print(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete campaign"})
+		return
+	}
+
+	hasPayments, err := paymentService.HasPayments(c, current.ID)
+	if err != nil || hasPayments {
+		restoreCampaignStatus(c, stored)
+		if err != nil {
+			log.Print(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check campaign payments"})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": "Campaign has received donations and cannot be deleted"})
+		return
+	}
+
+	if err := campaignService.DeleteCampaign(c, current.ID); err != nil {
+		log.Print(err)
+		restoreCampaignStatus(c, stored)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete campaign"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Campaign successfully deleted"})
+}
+
+func restoreCampaignStatus(c *gin.Context, stored models.Campaign) {
+	if err := campaignService.RestoreCampaignStatus(c, stored); err != nil {
+		log.Println("❌ Failed to restore campaign status:", stored.ID, err)
+	}
+}
+
+// loadOwnedCampaign resolves :campaign_id and makes sure it belongs to the
+// authenticated user, or that the user has the override permission, writing
+// the error response when neither holds.
+func loadOwnedCampaign(c *gin.Context, override roles.Permission) (models.Campaign, bool) {
+	campaignID, err := gocql.ParseUUID(c.Param("campaign_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid UUID format"})
+		return models.Campaign{}, false
+	}
+
+	campaign, err := campaignService.GetCampaignByID(c, campaignID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "campaign not found"})
+		return models.Campaign{}, false
+	}
+
+	raw, _ := c.Get("user_id")
+	if campaign.UserID != raw.(gocql.UUID) && (override == "" || !middleware.Can(c, override)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You do not own this campaign"})
+		return models.Campaign{}, false
+	}
+
+	return campaign, true
+}
+
 func GetCampaignHandler(c *gin.Context) {
 	idParam := c.Param("campaign_id")
 	if idParam == "" {
@@ -103,25 +340,90 @@ func GetCampaignHandler(c *gin.Context) {
 	if err != nil {
 		payments = []models2.PaymentHistory{}
 	}
+	for i := range payments {
+		payments[i] = payments[i].Public()
+	}
 	log.Println(payments)
 
+	balances, err := ledgerService.GetCampaignBalance(c, campaignID)
+	if err != nil {
+		balances = map[string]int64{}
+	}
+
 	resp := CampaignWithPayments{
 		ID:              campaign.ID,
 		Title:           campaign.Title,
 		Description:     campaign.Description,
 		Target:          campaign.Target,
+		Currency:        campaign.Currency,
 		AmountCollected: campaign.AmountCollected,
+		Totals:          campaign.Totals,
+		DonationCount:   campaign.DonationCount,
 		Image:           campaign.Image,
+		Category:        campaign.Category,
+		Status:          campaign.Status,
+		EndOnTarget:     campaign.EndOnTarget,
 		Deadline:        campaign.Deadline,
 		CreatedAt:       campaign.CreatedAt,
+		Balances:        balances,
 		Payments:        payments,
 	}
 
 	c.JSON(http.StatusOK, resp)
 }
 
+// SearchCampaignHandler searches campaigns with the filters and sort given
+// as query parameters. category may be repeated or comma separated, and
+// deadlines are RFC 3339 times or plain dates.
 func SearchCampaignHandler(c *gin.Context) {
-	keyword := c.Query("q")
+	params := campaign.SearchParams{
+		Query:  c.Query("q"),
+		Status: c.Query("status"),
+		Owner:  c.Query("owner"),
+		Sort:   c.Query("sort"),
+	}
+	if params.Status != "" && !models.IsValidStatus(params.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown status"})
+		return
+	}
+	if params.Sort != "" && !campaign.IsValidSort(params.Sort) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown sort"})
+		return
+	}
+	for _, raw := range c.QueryArray("category") {
+		for _, category := range strings.Split(raw, ",") {
+			category = strings.TrimSpace(category)
+			if category == "" {
+				continue
+			}
+			if !models.IsValidCategory(category) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "unknown category " + category})
+				return
+			}
+			params.Categories = append(params.Categories, category)
+		}
+	}
+
+	var ok bool
+	if params.MinTarget, ok = intParam(c, "min_target"); !ok {
+		return
+	}
+	if params.MaxTarget, ok = intParam(c, "max_target"); !ok {
+		return
+	}
+	if params.MinFunded, ok = floatParam(c, "min_funded"); !ok {
+		return
+	}
+	if params.MaxFunded, ok = floatParam(c, "max_funded"); !ok {
+		return
+	}
+	if params.DeadlineAfter, ok = timeParam(c, "deadline_after"); !ok {
+		return
+	}
+	if params.DeadlineBefore, ok = timeParam(c, "deadline_before"); !ok {
+		return
+	}
+
 	pageStr := c.DefaultQuery("page", "1")
 	perPageStr := c.DefaultQuery("per_page", "10")
 
@@ -133,18 +435,94 @@ func SearchCampaignHandler(c *gin.Context) {
 	if err != nil || perPage < 1 {
 		perPage = 10
 	}
+	params.Page = page
+	params.PerPage = perPage
 
-	result, err := campaignService.SearchCampaign(keyword, page, perPage)
+	result, err := campaignService.SearchCampaign(c, params)
 	if err != nil {
 		log.Println("❌ Search error:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search campaigns"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"total":    result.Total,
 		"data":     result.Data,
+		"facets":   result.Facets,
 		"page":     page,
 		"per_page": perPage,
-	})
+	}
+	if result.DidYouMean != "" {
+		response["did_you_mean"] = result.DidYouMean
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// SuggestCampaignHandler offers campaigns for the search box as the user
+// types, tolerating misspellings.
+func SuggestCampaignHandler(c *gin.Context) {
+	prefix := strings.TrimSpace(c.Query("q"))
+	if prefix == "" {
+		c.JSON(http.StatusOK, gin.H{"suggestions": []campaign.Suggestion{}})
+		return
+	}
+
+	size, err := strconv.Atoi(c.DefaultQuery("size", "5"))
+	if err != nil || size < 1 {
+		size = 5
+	}
+	if size > 10 {
+		size = 10
+	}
+
+	suggestions, err := campaignService.SuggestCampaigns(c, prefix, size)
+	if err != nil {
+		log.Println("❌ Suggest error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to suggest campaigns"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"suggestions": suggestions})
+}
+
+func intParam(c *gin.Context, name string) (*int, bool) {
+	raw := c.Query(name)
+	if raw == "" {
+		return nil, true
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": name + " must be a whole number"})
+		return nil, false
+	}
+	return &v, true
+}
+
+func floatParam(c *gin.Context, name string) (*float64, bool) {
+	raw := c.Query(name)
+	if raw == "" {
+		return nil, true
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": name + " must be a number"})
+		return nil, false
+	}
+	return &v, true
+}
+
+func timeParam(c *gin.Context, name string) (*time.Time, bool) {
+	raw := c.Query(name)
+	if raw == "" {
+		return nil, true
+	}
+	v, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		v, err = time.Parse(time.DateOnly, raw)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": name + " must be a date or an RFC 3339 time"})
+		return nil, false
+	}
+	return &v, true
 }
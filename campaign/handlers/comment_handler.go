@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"go-fundraising/auth/roles"
 	"go-fundraising/campaign/models"
 	"go-fundraising/campaign/services"
 	"log"
@@ -10,6 +12,7 @@ import (
 	"time"
 
 	"go-fundraising/configs"
+	"go-fundraising/middleware"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gocql/gocql"
@@ -81,3 +84,43 @@ func GetCommentsByCampaignIDHandler(c *gin.Context) {
 
 	c.JSON(http.StatusOK, comments)
 }
+
+// DeleteCommentHandler removes a comment. Authors can delete their own
+// comments and moderators can delete any.
+func DeleteCommentHandler(c *gin.Context) {
+	campaignID, err := gocql.ParseUUID(c.Param("campaign_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid UUID format"})
+		return
+	}
+	commentID, err := gocql.ParseUUID(c.Param("comment_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid UUID format"})
+		return
+	}
+
+	comment, err := commentService.GetComment(c, campaignID, commentID)
+	if err != nil {
+		if errors.Is(err, gocql.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
+			return
+		}
+		log.Print(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch comment"})
+		return
+	}
+
+	raw, _ := c.Get("user_id")
+	if comment.UserID != raw.(gocql.UUID) && !middleware.Can(c, roles.ModerateComment) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot delete this comment"})
+		return
+	}
+
+	if err := commentService.DeleteComment(c, comment); err != nil {
+		log.Print(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete comment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Comment successfully deleted"})
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"go-fundraising/currency"
 	"time"
 
 	"github.com/gocql/gocql"
@@ -14,10 +15,100 @@ type Campaign struct {
 	Title           string     `db:"title"`
 	Description     string     `db:"description"`
 	Target          int        `db:"target"`
-	AmountCollected int        `db:"amount_collected"`
+	Currency        string     `db:"currency"`
+	AmountCollected int64      `db:"amount_collected"`
+	DonationCount   int        `db:"donation_count"`
 	Image           string     `db:"image"`
+	Category        string     `db:"category"`
+	Status          string     `db:"status"`
+	EndOnTarget     bool       `db:"end_on_target"`
 	Deadline        time.Time  `db:"deadline"`
 	CreatedAt       time.Time  `db:"created_at"`
+
+	// Totals is the amount collected per donation currency in minor units.
+	// AmountCollected is the part of it expressed in Currency.
+	Totals map[string]int64 `db:"-"`
+}
+
+const (
+	StatusDraft     = "draft"
+	StatusActive    = "active"
+	StatusPaused    = "paused"
+	StatusEnded     = "ended"
+	StatusCancelled = "cancelled"
+
+	// StatusDeleting holds a campaign while it is being deleted, so no
+	// donation can start in the meantime. It is not in statusTransitions
+	// because owners cannot move a campaign to it.
+	StatusDeleting = "deleting"
+)
+
+// CategoryOther is the category of campaigns created without one.
+const CategoryOther = "other"
+
+// Categories are the categories a campaign can be filed under.
+var Categories = []string{
+	"animals",
+	"community",
+	"creative",
+	"education",
+	"emergencies",
+	"environment",
+	"medical",
+	"sports",
+	"technology",
+	CategoryOther,
+}
+
+func IsValidCategory(category string) bool {
+	for _, c := range Categories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+var statusTransitions = map[string][]string{
+	StatusDraft:     {StatusActive, StatusCancelled},
+	StatusActive:    {StatusPaused, StatusEnded, StatusCancelled},
+	StatusPaused:    {StatusActive, StatusEnded, StatusCancelled},
+	StatusEnded:     {},
+	StatusCancelled: {},
+}
+
+func IsValidStatus(status string) bool {
+	_, ok := statusTransitions[status]
+	return ok
+}
+
+func CanTransition(from, to string) bool {
+	for _, next := range statusTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// EffectiveStatus is the status the campaign should be in at now: running
+// campaigns end once the deadline passes, or once the target is reached when
+// EndOnTarget is set. Rows written before statuses existed count as active.
+func (c Campaign) EffectiveStatus(now time.Time) string {
+	status := c.Status
+	if status == "" {
+		status = StatusActive
+	}
+	if status != StatusActive && status != StatusPaused {
+		return status
+	}
+	if !c.Deadline.IsZero() && !now.Before(c.Deadline) {
+		return StatusEnded
+	}
+	if c.EndOnTarget && c.Target > 0 && c.AmountCollected >= currency.ToMinor(int64(c.Target), c.Currency) {
+		return StatusEnded
+	}
+	return status
 }
 
 var CampaignTable = table.Metadata{
@@ -29,9 +120,60 @@ var CampaignTable = table.Metadata{
 		"title",
 		"description",
 		"target",
+		"currency",
 		"amount_collected",
 		"image",
+		"status",
+		"end_on_target",
 		"deadline",
 		"created_at",
+		"category",
 	},
 }
+
+// ApplyTotals fills the per-currency totals of the campaign. Only amounts in
+// the campaign currency, or convertible to it with rates, count towards
+// AmountCollected.
+func (c *Campaign) ApplyTotals(totals []CampaignTotals, rates currency.RateTable) {
+	c.Totals = map[string]int64{}
+	c.AmountCollected = 0
+	c.DonationCount = 0
+
+	for _, t := range totals {
+		c.Totals[t.Currency] = t.AmountCollected
+		c.DonationCount += int(t.DonationCount)
+
+		converted, err := rates.Convert(t.AmountCollected, t.Currency, c.Currency)
+		if err != nil {
+			continue
+		}
+		c.AmountCollected += converted
+	}
+}
+
+// PercentFunded is AmountCollected as a percentage of Target, or zero for
+// campaigns without a target.
+func (c Campaign) PercentFunded() float64 {
+	target := currency.ToMinor(int64(c.Target), c.Currency)
+	if target <= 0 {
+		return 0
+	}
+	return float64(c.AmountCollected) * 100 / float64(target)
+}
+
+// CampaignTotals holds the running totals of a campaign per donation currency
+// in a counter table so concurrent donations can be applied without a
+// read-modify-write. AmountCollected is in minor units of Currency.
+type CampaignTotals struct {
+	CampaignID      gocql.UUID `db:"campaign_id"`
+	Currency        string     `db:"currency"`
+	AmountCollected int64      `db:"amount_collected"`
+	DonationCount   int64      `db:"donation_count"`
+}
+
+var CampaignTotalsTable = table.Metadata{
+	Name:    "campaign_totals",
+	Columns: []string{"campaign_id", "currency", "amount_collected", "donation_count"},
+	PartKey: []string{"campaign_id"},
+	SortKey: []string{"currency"},
+}
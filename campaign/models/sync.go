@@ -0,0 +1,58 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/table"
+)
+
+const (
+	SyncOpUpsert = "upsert"
+	SyncOpDelete = "delete"
+)
+
+// SyncOutboxShards is how many partitions the outbox is spread over, so
+// workers can drain it in parallel without sharing a partition.
+const SyncOutboxShards = 16
+
+// SyncOutboxEntry records that a campaign changed and its search document
+// must catch up. Workers index whatever the campaign row holds when they get
+// to the entry, so Op is informational and entries for the same campaign can
+// be processed in any order.
+type SyncOutboxEntry struct {
+	Shard         int        `db:"shard"`
+	ID            gocql.UUID `db:"id"`
+	CampaignID    gocql.UUID `db:"campaign_id"`
+	Op            string     `db:"op"`
+	Attempts      int        `db:"attempts"`
+	NextAttemptAt time.Time  `db:"next_attempt_at"`
+	LastError     string     `db:"last_error"`
+	CreatedAt     time.Time  `db:"created_at"`
+}
+
+var SyncOutboxTable = table.Metadata{
+	Name:    "search_sync_outbox",
+	Columns: []string{"shard", "id", "campaign_id", "op", "attempts", "next_attempt_at", "last_error", "created_at"},
+	PartKey: []string{"shard"},
+	SortKey: []string{"id"},
+}
+
+// SyncDeadLetter is an outbox entry that could not be synced, either because
+// Elasticsearch rejected the document or because it ran out of retries.
+type SyncDeadLetter struct {
+	CampaignID gocql.UUID `db:"campaign_id"`
+	ID         gocql.UUID `db:"id"`
+	Op         string     `db:"op"`
+	Attempts   int        `db:"attempts"`
+	LastError  string     `db:"last_error"`
+	CreatedAt  time.Time  `db:"created_at"`
+	FailedAt   time.Time  `db:"failed_at"`
+}
+
+var SyncDeadLetterTable = table.Metadata{
+	Name:    "search_sync_dead_letters",
+	Columns: []string{"campaign_id", "id", "op", "attempts", "last_error", "created_at", "failed_at"},
+	PartKey: []string{"campaign_id"},
+	SortKey: []string{"id"},
+}
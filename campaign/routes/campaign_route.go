@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"go-fundraising/auth/roles"
 	"go-fundraising/campaign/handlers"
 	"go-fundraising/middleware"
 
@@ -10,8 +11,13 @@ import (
 func InitCampaignRouter(route *gin.Engine) {
 	campaignGroup := route.Group("/campaign")
 	{
-		campaignGroup.POST("", middleware.AuthMiddleware(), handlers.CreateCampaignHandler)
+		campaignGroup.POST("", middleware.AuthMiddleware(), middleware.RequirePermission(roles.CreateCampaign), middleware.RequireVerifiedEmail(), handlers.CreateCampaignHandler)
 		campaignGroup.GET("", handlers.SearchCampaignHandler)
+		campaignGroup.GET("/suggest", handlers.SuggestCampaignHandler)
 		campaignGroup.GET("/:campaign_id", handlers.GetCampaignHandler)
+		campaignGroup.PUT("/:campaign_id", middleware.AuthMiddleware(), handlers.UpdateCampaignHandler)
+		campaignGroup.PATCH("/:campaign_id", middleware.AuthMiddleware(), handlers.UpdateCampaignHandler)
+		campaignGroup.POST("/:campaign_id/status", middleware.AuthMiddleware(), handlers.UpdateCampaignStatusHandler)
+		campaignGroup.DELETE("/:campaign_id", middleware.AuthMiddleware(), handlers.DeleteCampaignHandler)
 	}
 }
@@ -12,5 +12,6 @@ func InitCommentRouter(route *gin.Engine) {
 	{
 		commentGroup.POST("/:campaign_id", middleware.AuthMiddleware(), handlers.CreateCommentHandler)
 		commentGroup.GET("/:campaign_id", handlers.GetCommentsByCampaignIDHandler)
+		commentGroup.DELETE("/:campaign_id/:comment_id", middleware.AuthMiddleware(), handlers.DeleteCommentHandler)
 	}
 }
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"go-fundraising/campaign/models"
+	"go-fundraising/db"
+	"log"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+)
+
+// EndDueCampaigns stores the end of every running campaign whose deadline or
+// target has been reached and returns how many it ended.
+func (s *CampaignService) EndDueCampaigns(ctx context.Context) (int, error) {
+	stmt, names := qb.Select(models.CampaignTable.Name).
+		Columns("id", "status").
+		ToCql()
+
+	iter := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).Iter()
+
+	ended := 0
+	var row struct {
+		ID     gocql.UUID `db:"id"`
+		Status string     `db:"status"`
+	}
+	for iter.StructScan(&row) {
+		if row.Status != "" && row.Status != models.StatusActive && row.Status != models.StatusPaused {
+			continue
+		}
+
+		ok, err := s.EndCampaignIfDue(ctx, row.ID)
+		if err != nil {
+			iter.Close()
+			return ended, err
+		}
+		if ok {
+			ended++
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return ended, err
+	}
+
+	return ended, nil
+}
+
+// StartCampaignEnder runs EndDueCampaigns every interval until ctx is done, so
+// campaigns past their deadline are stored and indexed as ended.
+func StartCampaignEnder(ctx context.Context, interval time.Duration) {
+	service := CampaignService{}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+
+			ended, err := service.EndDueCampaigns(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				log.Println("❌ Ending due campaigns failed:", err)
+				continue
+			}
+			if ended > 0 {
+				log.Printf("✔️ Ended %d campaigns past their deadline or target\n", ended)
+			}
+		}
+	}()
+
+	log.Println("🚀 Started campaign ender every", interval)
+}
@@ -0,0 +1,484 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-fundraising/campaign/models"
+	"go-fundraising/db"
+	"go-fundraising/worker"
+	"log"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+const (
+	SortRelevance  = "relevance"
+	SortNewest     = "newest"
+	SortMostFunded = "most_funded"
+	SortEndingSoon = "ending_soon"
+	SortTrending   = "trending"
+)
+
+var searchSorts = []string{SortRelevance, SortNewest, SortMostFunded, SortEndingSoon, SortTrending}
+
+func IsValidSort(sort string) bool {
+	for _, s := range searchSorts {
+		if s == sort {
+			return true
+		}
+	}
+	return false
+}
+
+// SearchParams narrows and orders a campaign search. Zero values leave a
+// filter out. Targets are in whole units of each campaign's currency and
+// funding in percent of the target.
+type SearchParams struct {
+	Query          string
+	Categories     []string
+	Status         string
+	MinTarget      *int
+	MaxTarget      *int
+	MinFunded      *float64
+	MaxFunded      *float64
+	DeadlineAfter  *time.Time
+	DeadlineBefore *time.Time
+	// Owner is a user ID or a username.
+	Owner   string
+	Sort    string
+	Page    int
+	PerPage int
+}
+
+// Suggestion is a campaign offered while a search is being typed.
+type Suggestion struct {
+	ID       string `json:"id"`
+	Title    string `json:"title"`
+	Category string `json:"category"`
+	Image    string `json:"image"`
+}
+
+type FacetBucket struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"`
+}
+
+// fundedRanges are the buckets of the funded facet, in percent.
+var fundedRanges = []map[string]any{
+	{"key": "0-25", "to": 25},
+	{"key": "25-50", "from": 25, "to": 50},
+	{"key": "50-75", "from": 50, "to": 75},
+	{"key": "75-100", "from": 75, "to": 100},
+	{"key": "100+", "from": 100},
+}
+
+// SearchCampaign runs params against the search index. Facet counts are
+// computed with every filter except the facet's own, so picking a category
+// still shows how many campaigns the other categories hold.
+func (s CampaignService) SearchCampaign(ctx context.Context, params SearchParams) (SearchResult, error) {
+	now := time.Now()
+
+	var must any = map[string]any{"match_all": map[string]any{}}
+	if params.Query != "" {
+		must = map[string]any{
+			"multi_match": map[string]any{
+				"query":  params.Query,
+				"fields": []string{"title^2", "description"},
+				"type":   "best_fields",
+			},
+		}
+	}
+
+	filters := []any{}
+	if params.MinTarget != nil || params.MaxTarget != nil {
+		filters = append(filters, rangeFilter("target", params.MinTarget, params.MaxTarget))
+	}
+	if params.DeadlineAfter != nil || params.DeadlineBefore != nil {
+		filters = append(filters, rangeFilter("deadline", params.DeadlineAfter, params.DeadlineBefore))
+	}
+	if params.Owner != "" {
+		field := "username"
+		if _, err := gocql.ParseUUID(params.Owner); err == nil {
+			field = "user_id"
+		}
+		filters = append(filters, map[string]any{"term": map[string]any{field: params.Owner}})
+	}
+	if params.Sort == SortEndingSoon {
+		filters = append(filters, map[string]any{"range": map[string]any{"deadline": map[string]any{"gt": now}}})
+	}
+
+	facetFilters := map[string][]any{
+		"status": statusFilter(params.Status, now),
+	}
+	if len(params.Categories) > 0 {
+		facetFilters["category"] = []any{map[string]any{"terms": map[string]any{"category": params.Categories}}}
+	}
+	if params.MinFunded != nil || params.MaxFunded != nil {
+		facetFilters["funded"] = []any{rangeFilter("percent_funded", params.MinFunded, params.MaxFunded)}
+	}
+
+	statusBuckets := map[string]any{}
+	for _, status := range []string{models.StatusActive, models.StatusPaused, models.StatusEnded, models.StatusCancelled} {
+		statusBuckets[status] = map[string]any{"bool": map[string]any{"filter": statusFilter(status, now)}}
+	}
+	facets := map[string]any{
+		"category": map[string]any{"terms": map[string]any{"field": "category", "size": len(models.Categories)}},
+		"status":   map[string]any{"filters": map[string]any{"filters": statusBuckets}},
+		"funded":   map[string]any{"range": map[string]any{"field": "percent_funded", "ranges": fundedRanges}},
+	}
+
+	aggs := map[string]any{}
+	for name, agg := range facets {
+		others := []any{}
+		for other, clauses := range facetFilters {
+			if other != name {
+				others = append(others, clauses...)
+			}
+		}
+		if name == "status" {
+			// Drafts stay hidden even when the status filter is lifted.
+			others = append(others, statusFilter("", now)...)
+		}
+		aggs[name] = map[string]any{
+			"filter": map[string]any{"bool": map[string]any{"filter": others}},
+			"aggs":   map[string]any{"values": agg},
+		}
+	}
+
+	var postFilter []any
+	for _, clauses := range facetFilters {
+		postFilter = append(postFilter, clauses...)
+	}
+
+	var query any = map[string]any{
+		"bool": map[string]any{
+			"must":   must,
+			"filter": filters,
+		},
+	}
+
+	// Relevance means nothing without a query, so it falls back to newest.
+	sortBy := params.Sort
+	if sortBy == "" {
+		sortBy = SortRelevance
+	}
+	if sortBy == SortRelevance && params.Query == "" {
+		sortBy = SortNewest
+	}
+	newest := map[string]any{"created_at": map[string]string{"order": "desc"}}
+	var sort []any
+	switch sortBy {
+	case SortRelevance:
+		sort = []any{"_score", newest}
+	case SortMostFunded:
+		sort = []any{
+			map[string]any{"percent_funded": map[string]string{"order": "desc"}},
+			map[string]any{"amount_collected": map[string]string{"order": "desc"}},
+			newest,
+		}
+	case SortEndingSoon:
+		sort = []any{map[string]any{"deadline": map[string]string{"order": "asc"}}, newest}
+	case SortTrending:
+		// Donations within the trending window, fading out as the last one
+		// ages so campaigns that have gone quiet drop back.
+		query = map[string]any{
+			"function_score": map[string]any{
+				"query": query,
+				"functions": []any{
+					map[string]any{"field_value_factor": map[string]any{
+						"field": "recent_donations", "modifier": "log1p", "missing": 0,
+					}},
+					map[string]any{"gauss": map[string]any{
+						"last_donation_at": map[string]any{"origin": now, "scale": "3d", "decay": 0.5},
+					}},
+				},
+				"score_mode": "multiply",
+				"boost_mode": "replace",
+			},
+		}
+		sort = []any{"_score", newest}
+	default:
+		sort = []any{newest}
+	}
+
+	body := map[string]any{
+		"query":       query,
+		"post_filter": map[string]any{"bool": map[string]any{"filter": postFilter}},
+		"aggs":        aggs,
+		"from":        (params.Page - 1) * params.PerPage,
+		"size":        params.PerPage,
+		"sort":        sort,
+	}
+	if params.Query != "" {
+		body["highlight"] = map[string]any{
+			"pre_tags":  []string{"<em>"},
+			"post_tags": []string{"</em>"},
+			"fields": map[string]any{
+				"title":       map[string]any{"number_of_fragments": 0},
+				"description": map[string]any{"fragment_size": 150, "number_of_fragments": 3},
+			},
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(body); err != nil {
+		return SearchResult{}, fmt.Errorf("encode query: %w", err)
+	}
+
+	res, err := db.ElasticClient.Search(
+		db.ElasticClient.Search.WithContext(ctx),
+		db.ElasticClient.Search.WithIndex(worker.IndexAlias),
+		db.ElasticClient.Search.WithBody(&buf),
+	)
+	if err != nil {
+		return SearchResult{}, fmt.Errorf("es search error: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return SearchResult{}, fmt.Errorf("es search: %s", res.Status())
+	}
+
+	var r struct {
+		Hits struct {
+			Total struct {
+				Value int64 `json:"value"`
+			} `json:"total"`
+			Hits []struct {
+				Source    map[string]any      `json:"_source"`
+				Highlight map[string][]string `json:"highlight"`
+			} `json:"hits"`
+		} `json:"hits"`
+		Aggregations map[string]struct {
+			Values struct {
+				Buckets json.RawMessage `json:"buckets"`
+			} `json:"values"`
+		} `json:"aggregations"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return SearchResult{}, fmt.Errorf("decode es response: %w", err)
+	}
+
+	data := make([]map[string]any, len(r.Hits.Hits))
+	for i, h := range r.Hits.Hits {
+		data[i] = h.Source
+		if len(h.Highlight) > 0 {
+			data[i]["highlight"] = h.Highlight
+		}
+	}
+
+	result := SearchResult{
+		Total:  r.Hits.Total.Value,
+		Data:   data,
+		Facets: map[string][]FacetBucket{},
+	}
+	for name, agg := range r.Aggregations {
+		buckets, err := facetBuckets(agg.Values.Buckets)
+		if err != nil {
+			return SearchResult{}, fmt.Errorf("decode %s facet: %w", name, err)
+		}
+		result.Facets[name] = buckets
+	}
+	if statuses, ok := result.Facets["status"]; ok {
+		result.Facets["status"] = orderBuckets(statuses, []string{models.StatusActive, models.StatusPaused, models.StatusEnded, models.StatusCancelled})
+	}
+
+	if result.Total == 0 && params.Query != "" {
+		correction, err := s.didYouMean(ctx, params.Query)
+		if err != nil {
+			// The search itself worked; going without a correction is fine.
+			log.Println("⚠️ Failed to suggest a correction:", err)
+		}
+		result.DidYouMean = correction
+	}
+
+	return result, nil
+}
+
+// SuggestCampaigns returns up to size campaigns whose title starts with the
+// words of prefix, allowing a typo or two in each. Exact prefixes rank above
+// fuzzy ones.
+func (s CampaignService) SuggestCampaigns(ctx context.Context, prefix string, size int) ([]Suggestion, error) {
+	body := map[string]any{
+		"size":    size,
+		"_source": []string{"id", "title", "category", "image"},
+		"query": map[string]any{
+			"bool": map[string]any{
+				"should": []any{
+					map[string]any{"match": map[string]any{"title.autocomplete": map[string]any{
+						"query": prefix, "operator": "and", "boost": 2,
+					}}},
+					map[string]any{"match": map[string]any{"title.autocomplete": map[string]any{
+						"query": prefix, "operator": "and", "fuzziness": "AUTO", "prefix_length": 1,
+					}}},
+				},
+				"minimum_should_match": 1,
+				"filter":               statusFilter("", time.Now()),
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(body); err != nil {
+		return nil, fmt.Errorf("encode query: %w", err)
+	}
+
+	res, err := db.ElasticClient.Search(
+		db.ElasticClient.Search.WithContext(ctx),
+		db.ElasticClient.Search.WithIndex(worker.IndexAlias),
+		db.ElasticClient.Search.WithBody(&buf),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("es search error: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("es search: %s", res.Status())
+	}
+
+	var r struct {
+		Hits struct {
+			Hits []struct {
+				Source Suggestion `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("decode es response: %w", err)
+	}
+
+	suggestions := make([]Suggestion, len(r.Hits.Hits))
+	for i, h := range r.Hits.Hits {
+		suggestions[i] = h.Source
+	}
+	return suggestions, nil
+}
+
+// didYouMean proposes a respelling of query built from words that appear in
+// campaign titles and descriptions. A correction is only offered if it
+// would find visible campaigns.
+func (s CampaignService) didYouMean(ctx context.Context, query string) (string, error) {
+	collate := map[string]any{
+		"bool": map[string]any{
+			"must": map[string]any{"multi_match": map[string]any{
+				"query":    "{{suggestion}}",
+				"fields":   []string{"title", "description"},
+				"operator": "and",
+			}},
+			"filter": statusFilter("", time.Now()),
+		},
+	}
+	body := map[string]any{
+		"size": 0,
+		"suggest": map[string]any{
+			"did_you_mean": map[string]any{
+				"text": query,
+				"phrase": map[string]any{
+					"field":      "spell",
+					"size":       1,
+					"gram_size":  3,
+					"max_errors": 2,
+					"direct_generator": []any{
+						map[string]any{"field": "spell", "suggest_mode": "always", "min_word_length": 3},
+					},
+					"collate": map[string]any{"query": map[string]any{"source": collate}},
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(body); err != nil {
+		return "", fmt.Errorf("encode query: %w", err)
+	}
+
+	res, err := db.ElasticClient.Search(
+		db.ElasticClient.Search.WithContext(ctx),
+		db.ElasticClient.Search.WithIndex(worker.IndexAlias),
+		db.ElasticClient.Search.WithBody(&buf),
+	)
+	if err != nil {
+		return "", fmt.Errorf("es suggest error: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return "", fmt.Errorf("es suggest: %s", res.Status())
+	}
+
+	var r struct {
+		Suggest map[string][]struct {
+			Options []struct {
+				Text string `json:"text"`
+			} `json:"options"`
+		} `json:"suggest"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return "", fmt.Errorf("decode es response: %w", err)
+	}
+
+	for _, entry := range r.Suggest["did_you_mean"] {
+		for _, option := range entry.Options {
+			if option.Text != "" && option.Text != query {
+				return option.Text, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// facetBuckets reads the buckets of a terms or range aggregation, which
+// come as a list, or of a filters aggregation, which come keyed by name.
+func facetBuckets(raw json.RawMessage) ([]FacetBucket, error) {
+	var list []struct {
+		Key      any   `json:"key"`
+		DocCount int64 `json:"doc_count"`
+	}
+	if err := json.Unmarshal(raw, &list); err == nil {
+		buckets := make([]FacetBucket, len(list))
+		for i, b := range list {
+			buckets[i] = FacetBucket{Key: fmt.Sprint(b.Key), Count: b.DocCount}
+		}
+		return buckets, nil
+	}
+
+	var keyed map[string]struct {
+		DocCount int64 `json:"doc_count"`
+	}
+	if err := json.Unmarshal(raw, &keyed); err != nil {
+		return nil, err
+	}
+	buckets := make([]FacetBucket, 0, len(keyed))
+	for key, b := range keyed {
+		buckets = append(buckets, FacetBucket{Key: key, Count: b.DocCount})
+	}
+	return buckets, nil
+}
+
+func orderBuckets(buckets []FacetBucket, order []string) []FacetBucket {
+	byKey := map[string]FacetBucket{}
+	for _, b := range buckets {
+		byKey[b.Key] = b
+	}
+	ordered := make([]FacetBucket, 0, len(buckets))
+	for _, key := range order {
+		if b, ok := byKey[key]; ok {
+			ordered = append(ordered, b)
+		}
+	}
+	return ordered
+}
+
+// rangeFilter matches field between min and max inclusive, either of which
+// may be nil.
+func rangeFilter[T any](field string, min, max *T) map[string]any {
+	bounds := map[string]any{}
+	if min != nil {
+		bounds["gte"] = *min
+	}
+	if max != nil {
+		bounds["lte"] = *max
+	}
+	return map[string]any{"range": map[string]any{field: bounds}}
+}
@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"go-fundraising/campaign/models"
+	"go-fundraising/currency"
 	"go-fundraising/db"
 	"go-fundraising/worker"
+	"time"
 
 	"github.com/gocql/gocql"
 	"github.com/scylladb/gocqlx"
@@ -16,31 +19,55 @@ import (
 
 type CampaignService struct{}
 
+var (
+	ErrInvalidStatusTransition = errors.New("invalid campaign status transition")
+	ErrCampaignStatusChanged   = errors.New("campaign status changed concurrently")
+)
+
 type SearchResult struct {
-	Total int64            `json:"total"`
-	Data  []map[string]any `json:"data"`
+	Total  int64                    `json:"total"`
+	Data   []map[string]any         `json:"data"`
+	Facets map[string][]FacetBucket `json:"facets,omitempty"`
+	// DidYouMean is a corrected query offered when a search finds nothing.
+	DidYouMean string `json:"did_you_mean,omitempty"`
 }
 
 func (s *CampaignService) CreateCampaign(ctx context.Context, campaign models.Campaign) (models.Campaign, error) {
+	batch := db.ScyllaSession.NewBatch(gocql.LoggedBatch).WithContext(ctx)
 
-	stmt, names := qb.Insert(models.CampaignTable.Name).
+	stmt, _ := qb.Insert(models.CampaignTable.Name).
 		Columns(models.CampaignTable.Columns...).
 		ToCql()
+	batch.Query(stmt,
+		campaign.ID, campaign.UserID, campaign.Username, campaign.Title, campaign.Description,
+		campaign.Target, campaign.Currency, campaign.AmountCollected, campaign.Image,
+		campaign.Status, campaign.EndOnTarget, campaign.Deadline, campaign.CreatedAt, campaign.Category,
+	)
+	entry := worker.BatchSync(batch, campaign.ID, models.SyncOpUpsert)
 
-	err := gocqlx.Query(db.ScyllaSession.Query(stmt).Consistency(gocql.One), names).
-		BindStruct(campaign).
-		ExecRelease()
-
-	if err != nil {
+	if err := db.ScyllaSession.ExecuteBatch(batch); err != nil {
 		return models.Campaign{}, err
-	} else {
-		worker.EnqueueSync(campaign)
 	}
+	worker.Notify(entry)
 
 	return campaign, nil
 }
 
+// GetCampaignByID returns the campaign with its totals. A campaign past its
+// deadline or target reads as ended even before EndCampaignIfDue has stored
+// that, so reads never write.
 func (s *CampaignService) GetCampaignByID(ctx context.Context, campaign_id gocql.UUID) (models.Campaign, error) {
+	campaign, err := s.getStoredCampaign(ctx, campaign_id)
+	if err != nil {
+		return models.Campaign{}, err
+	}
+	campaign.Status = campaign.EffectiveStatus(time.Now())
+	return campaign, nil
+}
+
+// getStoredCampaign returns the campaign with the status it was stored with,
+// which conditional status updates have to be made against.
+func (s *CampaignService) getStoredCampaign(ctx context.Context, campaign_id gocql.UUID) (models.Campaign, error) {
 
 	var campaign models.Campaign
 
@@ -52,121 +79,274 @@ func (s *CampaignService) GetCampaignByID(ctx context.Context, campaign_id gocql
 	if err := q.GetRelease(&campaign); err != nil {
 		return models.Campaign{}, err
 	}
+
+	if campaign.Currency == "" {
+		campaign.Currency = currency.Default
+	}
+
+	totals, err := s.GetCampaignTotals(ctx, campaign_id)
+	if err != nil {
+		return models.Campaign{}, err
+	}
+	campaign.ApplyTotals(totals, currency.Rates())
+
 	return campaign, nil
 }
 
-func (s *CampaignService) UpdateCampaignAmountCollected(
-	ctx context.Context,
-	campaignID gocql.UUID,
-	amount int64,
-) error {
+// EndCampaignIfDue stores the end of a campaign whose deadline or target has
+// been reached, and reports whether this call ended it.
+func (s *CampaignService) EndCampaignIfDue(ctx context.Context, campaign_id gocql.UUID) (bool, error) {
+	campaign, err := s.getStoredCampaign(ctx, campaign_id)
+	if err != nil {
+		return false, err
+	}
+	if campaign.Status == models.StatusEnded || campaign.EffectiveStatus(time.Now()) != models.StatusEnded {
+		return false, nil
+	}
+
+	_, err = s.UpdateCampaignStatus(ctx, campaign, models.StatusEnded)
+	if errors.Is(err, ErrCampaignStatusChanged) {
+		return false, nil
+	}
+	return err == nil, err
+}
 
-	var curr struct {
-		AmountCollected int64 `db:"amount_collected"`
+// UpdateCampaignStatus moves campaign to status if the transition is allowed.
+// The write is conditional on the status campaign was read with, so two
+// concurrent transitions cannot both succeed.
+func (s *CampaignService) UpdateCampaignStatus(ctx context.Context, campaign models.Campaign, status string) (models.Campaign, error) {
+	from := campaign.Status
+	if from == "" {
+		from = models.StatusActive
+	}
+	if !models.CanTransition(from, status) {
+		return models.Campaign{}, ErrInvalidStatusTransition
 	}
-	stmtSel, namesSel := qb.Select(models.CampaignTable.Name).
-		Columns("amount_collected").
-		Where(qb.Eq("id")).Limit(1).
+
+	if err := s.setStatus(ctx, campaign.ID, campaign.Status, status); err != nil {
+		return models.Campaign{}, err
+	}
+
+	campaign.Status = status
+	return campaign, nil
+}
+
+// MarkCampaignDeleting moves a campaign to StatusDeleting unless its status
+// changes concurrently, and returns the campaign as it was before. Donations
+// are refused from then on, so a campaign found to have no payments after
+// this can be deleted without one arriving in between.
+func (s *CampaignService) MarkCampaignDeleting(ctx context.Context, campaignID gocql.UUID) (models.Campaign, error) {
+	campaign, err := s.getStoredCampaign(ctx, campaignID)
+	if err != nil {
+		return models.Campaign{}, err
+	}
+	if campaign.Status == models.StatusDeleting {
+		return models.Campaign{}, ErrCampaignStatusChanged
+	}
+
+	if err := s.setStatus(ctx, campaign.ID, campaign.Status, models.StatusDeleting); err != nil {
+		return models.Campaign{}, err
+	}
+	return campaign, nil
+}
+
+// RestoreCampaignStatus undoes MarkCampaignDeleting for a campaign that is
+// kept after all.
+func (s *CampaignService) RestoreCampaignStatus(ctx context.Context, campaign models.Campaign) error {
+	status := campaign.Status
+	if status == "" {
+		status = models.StatusActive
+	}
+	return s.setStatus(ctx, campaign.ID, models.StatusDeleting, status)
+}
+
+// setStatus writes status on the condition that the stored status is still
+// previous, and re-syncs the search document.
+func (s *CampaignService) setStatus(ctx context.Context, campaignID gocql.UUID, previous, status string) error {
+	var stored interface{}
+	if previous != "" {
+		stored = previous
+	}
+
+	entry, err := worker.RecordSync(ctx, campaignID, models.SyncOpUpsert)
+	if err != nil {
+		return err
+	}
+
+	stmt, names := qb.Update(models.CampaignTable.Name).
+		Set("status").
+		Where(qb.Eq("id")).
+		If(qb.EqNamed("status", "previous")).
 		ToCql()
 
-	err := gocqlx.Query(
-		db.ScyllaSession.Query(stmtSel),
-		namesSel,
-	).BindMap(map[string]interface{}{
-		"id": campaignID,
-	}).GetRelease(&curr)
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).BindMap(map[string]interface{}{
+		"id":       campaignID,
+		"status":   status,
+		"previous": stored,
+	})
+	applied, err := q.MapScanCAS(map[string]interface{}{})
+	q.Release()
 	if err != nil {
 		return err
 	}
+	if !applied {
+		return ErrCampaignStatusChanged
+	}
+
+	worker.Notify(entry)
+	return nil
+}
 
-	newAmount := curr.AmountCollected + amount
+// UpdateCampaign writes the editable fields of campaign and re-syncs the
+// search document.
+func (s *CampaignService) UpdateCampaign(ctx context.Context, campaign models.Campaign) (models.Campaign, error) {
+	entry, err := worker.RecordSync(ctx, campaign.ID, models.SyncOpUpsert)
+	if err != nil {
+		return models.Campaign{}, err
+	}
 
-	stmtUpd, namesUpd := qb.Update(models.CampaignTable.Name).
-		Set("amount_collected").
+	stmt, names := qb.Update(models.CampaignTable.Name).
+		Set("title", "description", "image", "category", "target", "deadline").
 		Where(qb.Eq("id")).
+		Existing().
 		ToCql()
 
-	return gocqlx.Query(
-		db.ScyllaSession.Query(stmtUpd),
-		namesUpd,
-	).BindMap(map[string]interface{}{
-		"id":               campaignID,
-		"amount_collected": newAmount,
-	}).ExecRelease()
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).BindStruct(campaign)
+	applied, err := q.MapScanCAS(map[string]interface{}{})
+	q.Release()
+	if err != nil {
+		return models.Campaign{}, err
+	}
+	if !applied {
+		return models.Campaign{}, gocql.ErrNotFound
+	}
+
+	worker.Notify(entry)
+
+	return campaign, nil
 }
 
-func (s CampaignService) SearchCampaign(keyword string, page, perPage int) (SearchResult, error) {
-	index := "campaigns"
-	from := (page - 1) * perPage
+// DeleteCampaign removes the campaign row, its totals and comments, and drops
+// the search document. Callers must mark it deleting with
+// MarkCampaignDeleting and make sure it has no payments first.
+func (s *CampaignService) DeleteCampaign(ctx context.Context, campaignID gocql.UUID) error {
+	batch := db.ScyllaSession.NewBatch(gocql.LoggedBatch).WithContext(ctx)
 
-	type Query struct {
-		Query any           `json:"query"`
-		From  int           `json:"from"`
-		Size  int           `json:"size"`
-		Sort  []interface{} `json:"sort,omitempty"`
-	}
-
-	var qBody Query
-	if keyword == "" {
-		qBody.Query = map[string]any{"match_all": map[string]any{}}
-	} else {
-		qBody.Query = map[string]any{
-			"multi_match": map[string]any{
-				"query":  keyword,
-				"fields": []string{"title", "description"},
-				"type":   "best_fields",
-			},
-		}
-	}
-	qBody.From = from
-	qBody.Size = perPage
-	qBody.Sort = []interface{}{
-		map[string]any{"created_at": map[string]string{"order": "desc"}},
+	stmt, _ := qb.Delete(models.CampaignTable.Name).Where(qb.Eq("id")).ToCql()
+	batch.Query(stmt, campaignID)
+
+	stmt, _ = qb.Delete(models.CommentTable.Name).Where(qb.Eq("campaign_id")).ToCql()
+	batch.Query(stmt, campaignID)
+
+	entry := worker.BatchSync(batch, campaignID, models.SyncOpDelete)
+
+	if err := db.ScyllaSession.ExecuteBatch(batch); err != nil {
+		return err
 	}
 
-	var buf bytes.Buffer
-	if err := json.NewEncoder(&buf).Encode(qBody); err != nil {
-		return SearchResult{}, fmt.Errorf("encode query: %w", err)
+	// Counter tables cannot take part in a logged batch.
+	stmt, names := qb.Delete(models.CampaignTotalsTable.Name).Where(qb.Eq("campaign_id")).ToCql()
+	err := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"campaign_id": campaignID}).
+		ExecRelease()
+	if err != nil {
+		return err
 	}
 
-	res, err := db.ElasticClient.Search(
-		db.ElasticClient.Search.WithContext(context.Background()),
-		db.ElasticClient.Search.WithIndex(index),
-		db.ElasticClient.Search.WithBody(&buf),
-	)
+	worker.Notify(entry)
+
+	return nil
+}
+
+// AnonymizeOwner replaces the owner's username on all of their campaigns
+// and queues their search documents for re-sync. It returns how many
+// campaigns it changed.
+func (s *CampaignService) AnonymizeOwner(ctx context.Context, userID gocql.UUID, username string) (int, error) {
+	var owned []models.Campaign
+
+	stmt, names := qb.Select(models.CampaignTable.Name).Columns("id").Where(qb.Eq("user_id")).ToCql()
+	err := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"user_id": userID}).
+		SelectRelease(&owned)
 	if err != nil {
-		return SearchResult{}, fmt.Errorf("es search error: %w", err)
+		return 0, err
 	}
-	defer res.Body.Close()
 
-	// Parse response
-	var r struct {
-		Hits struct {
-			Total struct {
-				Value int64 `json:"value"`
-			} `json:"total"`
-			Hits []struct {
-				Source map[string]any `json:"_source"`
-			} `json:"hits"`
-		} `json:"hits"`
+	stmt, _ = qb.Update(models.CampaignTable.Name).Set("username").Where(qb.Eq("id")).ToCql()
+	for i, campaign := range owned {
+		batch := db.ScyllaSession.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+		batch.Query(stmt, username, campaign.ID)
+		entry := worker.BatchSync(batch, campaign.ID, models.SyncOpUpsert)
+
+		if err := db.ScyllaSession.ExecuteBatch(batch); err != nil {
+			return i, err
+		}
+		worker.Notify(entry)
 	}
-	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
-		return SearchResult{}, fmt.Errorf("decode es response: %w", err)
+
+	return len(owned), nil
+}
+
+func (s *CampaignService) GetCampaignTotals(ctx context.Context, campaignID gocql.UUID) ([]models.CampaignTotals, error) {
+	var totals []models.CampaignTotals
+
+	stmt, names := qb.Select(models.CampaignTotalsTable.Name).
+		Columns(models.CampaignTotalsTable.Columns...).
+		Where(qb.Eq("campaign_id")).
+		ToCql()
+
+	err := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"campaign_id": campaignID}).
+		SelectRelease(&totals)
+	if err != nil {
+		return nil, err
 	}
 
-	data := make([]map[string]any, len(r.Hits.Hits))
-	for i, h := range r.Hits.Hits {
-		data[i] = h.Source
+	return totals, nil
+}
+
+// UpdateCampaignAmountCollected applies amount (in minor units of code) and
+// donations as counter deltas, so concurrent donations never overwrite each
+// other. Refunds pass a negative amount and zero donations, since the
+// donation was still made. The search document is re-synced since it
+// carries the totals.
+func (s *CampaignService) UpdateCampaignAmountCollected(
+	ctx context.Context,
+	campaignID gocql.UUID,
+	code string,
+	amount int64,
+	donations int64,
+) error {
+	entry, err := worker.RecordSync(ctx, campaignID, models.SyncOpUpsert)
+	if err != nil {
+		return err
 	}
 
-	return SearchResult{
-		Total: r.Hits.Total.Value,
-		Data:  data,
-	}, nil
+	stmt, names := qb.Update(models.CampaignTotalsTable.Name).
+		Add("amount_collected").
+		Add("donation_count").
+		Where(qb.Eq("campaign_id"), qb.Eq("currency")).
+		ToCql()
+
+	err = gocqlx.Query(
+		db.ScyllaSession.Query(stmt).WithContext(ctx),
+		names,
+	).BindMap(map[string]interface{}{
+		"campaign_id":      campaignID,
+		"currency":         code,
+		"amount_collected": amount,
+		"donation_count":   donations,
+	}).ExecRelease()
+	if err != nil {
+		return err
+	}
+
+	worker.Notify(entry)
+	return nil
 }
 
 func (s CampaignService) GetCampaignByUserID(ctx context.Context, userID string, page, perPage int) (SearchResult, error) {
-	index := "campaigns"
+	index := worker.IndexAlias
 	from := (page - 1) * perPage
 
 	qBody := map[string]any{
@@ -225,3 +405,34 @@ func (s CampaignService) GetCampaignByUserID(ctx context.Context, userID string,
 		Data:  data,
 	}, nil
 }
+
+// statusFilter matches campaigns by effective status. The indexed status is
+// only rewritten when a campaign is read or transitioned, so campaigns whose
+// deadline has passed are treated as ended here as well. Without a status,
+// drafts are hidden from search.
+func statusFilter(status string, now time.Time) []any {
+	running := map[string]any{"terms": map[string]any{"status": []string{models.StatusActive, models.StatusPaused}}}
+	expired := map[string]any{"range": map[string]any{"deadline": map[string]any{"lte": now}}}
+
+	switch status {
+	case "":
+		return []any{map[string]any{"bool": map[string]any{
+			"must_not": map[string]any{"term": map[string]any{"status": models.StatusDraft}},
+		}}}
+	case models.StatusActive, models.StatusPaused:
+		return []any{
+			map[string]any{"term": map[string]any{"status": status}},
+			map[string]any{"bool": map[string]any{"must_not": expired}},
+		}
+	case models.StatusEnded:
+		return []any{map[string]any{"bool": map[string]any{
+			"should": []any{
+				map[string]any{"term": map[string]any{"status": models.StatusEnded}},
+				map[string]any{"bool": map[string]any{"filter": []any{running, expired}}},
+			},
+			"minimum_should_match": 1,
+		}}}
+	default:
+		return []any{map[string]any{"term": map[string]any{"status": status}}}
+	}
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"context"
+	"go-fundraising/campaign/models"
+	"go-fundraising/db/dbtest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+func TestUpdateCampaignAmountCollectedIsExactUnderConcurrency(t *testing.T) {
+	dbtest.Setup(t, "campaign")
+	ctx := context.Background()
+	service := CampaignService{}
+	campaignID := gocql.TimeUUID()
+
+	const donations = 300
+	var wg sync.WaitGroup
+	for i := 1; i <= donations; i++ {
+		wg.Add(1)
+		go func(amount int64) {
+			defer wg.Done()
+			if err := service.UpdateCampaignAmountCollected(ctx, campaignID, "usd", amount, 1); err != nil {
+				t.Errorf("update totals: %v", err)
+			}
+		}(int64(i))
+	}
+	// Refunds racing the donations lower the amount but not the count.
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := service.UpdateCampaignAmountCollected(ctx, campaignID, "usd", -5, 0); err != nil {
+				t.Errorf("update totals: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	totals, err := service.GetCampaignTotals(ctx, campaignID)
+	if err != nil {
+		t.Fatalf("get totals: %v", err)
+	}
+	if len(totals) != 1 {
+		t.Fatalf("got %d totals, want one for usd", len(totals))
+	}
+	wantAmount := int64(donations*(donations+1)/2 - 10*5)
+	if totals[0].AmountCollected != wantAmount || totals[0].DonationCount != donations {
+		t.Errorf("totals = %d over %d donations, want %d over %d",
+			totals[0].AmountCollected, totals[0].DonationCount, wantAmount, donations)
+	}
+}
+
+func TestReadingADueCampaignDoesNotEndIt(t *testing.T) {
+	dbtest.Setup(t, "campaign")
+	ctx := context.Background()
+	service := CampaignService{}
+
+	campaign, err := service.CreateCampaign(ctx, models.Campaign{
+		ID:        gocql.TimeUUID(),
+		UserID:    gocql.TimeUUID(),
+		Title:     "Past its deadline",
+		Target:    100,
+		Currency:  "usd",
+		Category:  models.CategoryOther,
+		Status:    models.StatusActive,
+		Deadline:  time.Now().Add(-time.Hour),
+		CreatedAt: time.Now().Add(-48 * time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("create campaign: %v", err)
+	}
+
+	read, err := service.GetCampaignByID(ctx, campaign.ID)
+	if err != nil {
+		t.Fatalf("get campaign: %v", err)
+	}
+	if read.Status != models.StatusEnded {
+		t.Errorf("read status = %q, want %q", read.Status, models.StatusEnded)
+	}
+	stored, err := service.getStoredCampaign(ctx, campaign.ID)
+	if err != nil {
+		t.Fatalf("get stored campaign: %v", err)
+	}
+	if stored.Status != models.StatusActive {
+		t.Errorf("stored status after a read = %q, want %q", stored.Status, models.StatusActive)
+	}
+
+	for i, want := range []bool{true, false} {
+		ended, err := service.EndCampaignIfDue(ctx, campaign.ID)
+		if err != nil {
+			t.Fatalf("end campaign: %v", err)
+		}
+		if ended != want {
+			t.Errorf("call %d ended = %v, want %v", i+1, ended, want)
+		}
+	}
+}
@@ -54,3 +54,64 @@ func (s *CommentService) GetCommentsByCampaignID(ctx context.Context, CampaignID
 
 	return comments, nil
 }
+
+// GetComment finds a comment by id within its campaign. The id is not part of
+// the partition key, so the lookup filters the campaign's partition.
+func (s *CommentService) GetComment(ctx context.Context, campaignID, commentID gocql.UUID) (models.Comment, error) {
+	var comment models.Comment
+
+	stmt, names := qb.Select(models.CommentTable.Name).
+		Where(qb.Eq("campaign_id"), qb.Eq("id")).
+		AllowFiltering().
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(qb.M{"campaign_id": campaignID, "id": commentID})
+
+	if err := q.GetRelease(&comment); err != nil {
+		return models.Comment{}, err
+	}
+	return comment, nil
+}
+
+func (s *CommentService) DeleteComment(ctx context.Context, comment models.Comment) error {
+	stmt, names := qb.Delete(models.CommentTable.Name).
+		Where(qb.Eq("campaign_id"), qb.Eq("created_at"), qb.Eq("id")).
+		ToCql()
+
+	return gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindStruct(comment).
+		ExecRelease()
+}
+
+// AnonymizeUserComments replaces the author's username on all of their
+// comments and returns how many it changed. The content is kept.
+func (s *CommentService) AnonymizeUserComments(ctx context.Context, userID gocql.UUID, username string) (int, error) {
+	var comments []models.Comment
+
+	stmt, names := qb.Select(models.CommentTable.Name).
+		Columns("campaign_id", "created_at", "id").
+		Where(qb.Eq("user_id")).
+		ToCql()
+	err := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(qb.M{"user_id": userID}).
+		SelectRelease(&comments)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt, names = qb.Update(models.CommentTable.Name).
+		Set("username").
+		Where(qb.Eq("campaign_id"), qb.Eq("created_at"), qb.Eq("id")).
+		ToCql()
+	for i, comment := range comments {
+		comment.Username = username
+		err := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+			BindStruct(comment).
+			ExecRelease()
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(comments), nil
+}
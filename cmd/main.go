@@ -1,15 +1,26 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"expvar"
 	"fmt"
+	"go-fundraising/auth/roles"
 	authRouter "go-fundraising/auth/routes"
 	campaignRouter "go-fundraising/campaign/routes"
+	campaign "go-fundraising/campaign/services"
 	"go-fundraising/configs"
+	ledger "go-fundraising/ledger/services"
+	"go-fundraising/middleware"
 	paymentRouter "go-fundraising/payment/routes"
+	"go-fundraising/token"
 	"go-fundraising/worker"
 
 	"go-fundraising/db"
 	"log"
+	"net/http"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -20,15 +31,37 @@ func main() {
 	configs.LoadEnv()
 	db.InitScylla()
 	db.InitElastic()
-	defer db.CloseScylla()
+
+	// Cancelled by SIGINT or SIGTERM, and on shutdown for the background
+	// jobs that run until then.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
 	r := gin.Default()
 
 	worker.InitSyncWorkers(5)
 
+	reconcileInterval, err := time.ParseDuration(configs.GetEnv("LEDGER_RECONCILE_INTERVAL"))
+	if err != nil || reconcileInterval <= 0 {
+		reconcileInterval = time.Hour
+	}
+	ledger.StartReconciler(ctx, reconcileInterval)
+
+	campaignEndInterval, err := time.ParseDuration(configs.GetEnv("CAMPAIGN_END_INTERVAL"))
+	if err != nil || campaignEndInterval <= 0 {
+		campaignEndInterval = time.Minute
+	}
+	campaign.StartCampaignEnder(ctx, campaignEndInterval)
+
+	keysReloadInterval, err := time.ParseDuration(configs.GetEnv("JWT_KEYS_RELOAD_INTERVAL"))
+	if err != nil || keysReloadInterval <= 0 {
+		keysReloadInterval = time.Minute
+	}
+	token.StartReloader(ctx, keysReloadInterval)
+
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: false,
@@ -44,12 +77,52 @@ func main() {
 		c.JSON(200, gin.H{"message": "pong"})
 	})
 
+	// Runtime and search sync metrics.
+	r.GET("/debug/vars", middleware.AuthMiddleware(), middleware.RequireRole(roles.Admin), gin.WrapH(expvar.Handler()))
+
 	port := configs.GetEnv("APP_PORT")
 	if port == "" {
 		port = "8080"
 	}
 	r.LoadHTMLGlob("./payment/templates/*")
 
-	log.Println("🚀 Server is running at port", port)
-	r.Run(fmt.Sprintf(":%s", port))
+	shutdownTimeout, err := time.ParseDuration(configs.GetEnv("SHUTDOWN_TIMEOUT"))
+	if err != nil || shutdownTimeout <= 0 {
+		shutdownTimeout = 30 * time.Second
+	}
+
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%s", port),
+		Handler:           r,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		log.Println("🚀 Server is running at port", port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("❌ Server failed: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Println("🛑 Shutting down, waiting up to", shutdownTimeout)
+	shutdown(shutdownTimeout, srv)
+}
+
+// shutdown stops taking requests and lets the ones in flight finish, then
+// drains the sync workers they may have handed work to, and only then
+// closes the clients everything else depends on.
+func shutdown(timeout time.Duration, srv *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Println("⚠️ Server did not shut down cleanly:", err)
+	}
+	if err := worker.StopSyncWorkers(ctx); err != nil {
+		log.Println("⚠️ ES sync workers did not drain, the outbox keeps the rest:", err)
+	}
+
+	db.CloseElastic()
+	db.CloseScylla()
 }
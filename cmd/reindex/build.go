@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go-fundraising/db"
+	"go-fundraising/worker"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+func build(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	workers := fs.Int("workers", 8, "concurrent token range scans and bulk writers")
+	ranges := fs.Int("ranges", 256, "token ranges to split the campaigns table into")
+	batch := fs.Int("batch", 500, "documents per bulk request")
+	replicas := fs.Int("replicas", 1, "replicas of the new index once loaded")
+	keep := fs.Bool("keep", false, "keep the indexes the alias pointed to before")
+	fs.Parse(args)
+
+	index := fmt.Sprintf("%s_v%d", worker.IndexAlias, time.Now().Unix())
+
+	// Replicas and refreshes only slow the initial load down.
+	settings := fmt.Sprintf(`{"settings": {"number_of_replicas": 0, "refresh_interval": "-1", "analysis": %s}}`, worker.IndexAnalysis)
+	if err := db.CreateIndex(ctx, index, settings); err != nil {
+		return err
+	}
+	if err := db.PutMapping(ctx, index, worker.IndexMapping); err != nil {
+		return err
+	}
+	log.Println("✔️ Created index", index)
+
+	loaded, err := load(ctx, index, *ranges, *workers, *batch)
+	if err != nil {
+		return fmt.Errorf("load %s, left in place for inspection: %w", index, err)
+	}
+
+	settings = fmt.Sprintf(`{"index": {"number_of_replicas": %d, "refresh_interval": null}}`, *replicas)
+	if err := db.PutSettings(ctx, index, settings); err != nil {
+		return err
+	}
+	if err := refresh(ctx, index); err != nil {
+		return err
+	}
+	count, err := countDocuments(ctx, index)
+	if err != nil {
+		return err
+	}
+	if count != int64(loaded) {
+		return fmt.Errorf("%s holds %d documents but %d were loaded, left in place for inspection", index, count, loaded)
+	}
+	log.Printf("✔️ Loaded %d campaigns into %s\n", loaded, index)
+
+	previous, err := swapAlias(ctx, worker.IndexAlias, index)
+	if err != nil {
+		return err
+	}
+	log.Printf("✔️ %s now points at %s\n", worker.IndexAlias, index)
+
+	// The sync workers kept writing to the previous index during the load.
+	result, err := compare(ctx, worker.IndexAlias, *ranges, *workers, *batch, true)
+	if err != nil {
+		return fmt.Errorf("catch up %s: %w", index, err)
+	}
+	result.print()
+
+	if *keep || len(previous) == 0 {
+		return nil
+	}
+	if err := deleteIndexes(ctx, previous); err != nil {
+		return err
+	}
+	log.Println("✔️ Deleted previous indexes:", strings.Join(previous, ", "))
+	return nil
+}
+
+// load indexes every campaign into index and returns how many it wrote.
+func load(ctx context.Context, index string, ranges, workers, batch int) (int, error) {
+	documents := make(chan document, workers*batch)
+	scanErr := make(chan error, 1)
+	go func() {
+		scanErr <- scanDocuments(ctx, ranges, workers, documents)
+		close(documents)
+	}()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		written  int
+		failed   int
+		firstErr error
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := newBulkWriter(index, batch)
+
+			var err error
+			for doc := range documents {
+				if err == nil {
+					err = w.Index(ctx, doc.id, doc.source)
+				}
+			}
+			if err == nil {
+				err = w.Flush(ctx)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			written += w.written
+			failed += len(w.failed)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := <-scanErr; err != nil {
+		return written, err
+	}
+	if firstErr != nil {
+		return written, firstErr
+	}
+	if failed > 0 {
+		return written, fmt.Errorf("%d documents were rejected", failed)
+	}
+	return written, nil
+}
+
+// swapAlias points alias at index alone in one request and returns the
+// indexes it pointed at before. A concrete index named alias, left from
+// before the alias existed, is deleted in the same request since the two
+// cannot share a name.
+func swapAlias(ctx context.Context, alias, index string) ([]string, error) {
+	previous, err := aliasTargets(ctx, alias)
+	if err != nil {
+		return nil, err
+	}
+
+	actions := []map[string]interface{}{
+		{"add": map[string]interface{}{"index": index, "alias": alias, "is_write_index": true}},
+	}
+	for _, old := range previous {
+		actions = append(actions, map[string]interface{}{"remove": map[string]string{"index": old, "alias": alias}})
+	}
+	if len(previous) == 0 {
+		concrete, err := indexExists(ctx, alias)
+		if err != nil {
+			return nil, err
+		}
+		if concrete {
+			log.Println("⚠️ Replacing concrete index", alias, "with an alias")
+			actions = append(actions, map[string]interface{}{"remove_index": map[string]string{"index": alias}})
+		}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"actions": actions})
+	if err != nil {
+		return nil, err
+	}
+	res, err := db.ElasticClient.Indices.UpdateAliases(strings.NewReader(string(body)),
+		db.ElasticClient.Indices.UpdateAliases.WithContext(ctx),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("update aliases: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("update aliases: %s", res.Status())
+	}
+	return previous, nil
+}
+
+func aliasTargets(ctx context.Context, alias string) ([]string, error) {
+	res, err := db.ElasticClient.Indices.GetAlias(
+		db.ElasticClient.Indices.GetAlias.WithContext(ctx),
+		db.ElasticClient.Indices.GetAlias.WithName(alias),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get alias %s: %w", alias, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 404 {
+		return nil, nil
+	}
+	if res.IsError() {
+		return nil, fmt.Errorf("get alias %s: %s", alias, res.Status())
+	}
+
+	var r map[string]json.RawMessage
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("decode alias %s: %w", alias, err)
+	}
+	targets := make([]string, 0, len(r))
+	for index := range r {
+		targets = append(targets, index)
+	}
+	return targets, nil
+}
+
+func indexExists(ctx context.Context, index string) (bool, error) {
+	res, err := db.ElasticClient.Indices.Exists([]string{index},
+		db.ElasticClient.Indices.Exists.WithContext(ctx),
+	)
+	if err != nil {
+		return false, fmt.Errorf("check index %s: %w", index, err)
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case 200:
+		return true, nil
+	case 404:
+		return false, nil
+	default:
+		return false, fmt.Errorf("check index %s: %s", index, res.Status())
+	}
+}
+
+func deleteIndexes(ctx context.Context, indexes []string) error {
+	res, err := db.ElasticClient.Indices.Delete(indexes,
+		db.ElasticClient.Indices.Delete.WithContext(ctx),
+	)
+	if err != nil {
+		return fmt.Errorf("delete indexes: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("delete indexes: %s", res.Status())
+	}
+	return nil
+}
+
+func refresh(ctx context.Context, index string) error {
+	res, err := db.ElasticClient.Indices.Refresh(
+		db.ElasticClient.Indices.Refresh.WithContext(ctx),
+		db.ElasticClient.Indices.Refresh.WithIndex(index),
+	)
+	if err != nil {
+		return fmt.Errorf("refresh %s: %w", index, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("refresh %s: %s", index, res.Status())
+	}
+	return nil
+}
+
+func countDocuments(ctx context.Context, index string) (int64, error) {
+	res, err := db.ElasticClient.Count(
+		db.ElasticClient.Count.WithContext(ctx),
+		db.ElasticClient.Count.WithIndex(index),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("count %s: %w", index, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return 0, fmt.Errorf("count %s: %s", index, res.Status())
+	}
+
+	var r struct {
+		Count int64 `json:"count"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return 0, fmt.Errorf("decode count: %w", err)
+	}
+	return r.Count, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-fundraising/db"
+	"log"
+)
+
+// bulkWriter batches index and delete actions for one index into bulk
+// requests. It is not safe for concurrent use.
+type bulkWriter struct {
+	index   string
+	size    int
+	buf     bytes.Buffer
+	pending int
+
+	written int
+	failed  map[string]string
+}
+
+func newBulkWriter(index string, size int) *bulkWriter {
+	return &bulkWriter{index: index, size: size, failed: map[string]string{}}
+}
+
+func (w *bulkWriter) Index(ctx context.Context, id string, doc map[string]interface{}) error {
+	return w.add(ctx, "index", id, doc)
+}
+
+func (w *bulkWriter) Delete(ctx context.Context, id string) error {
+	return w.add(ctx, "delete", id, nil)
+}
+
+func (w *bulkWriter) add(ctx context.Context, action, id string, doc map[string]interface{}) error {
+	meta := map[string]interface{}{action: map[string]string{"_index": w.index, "_id": id}}
+	if err := json.NewEncoder(&w.buf).Encode(meta); err != nil {
+		return fmt.Errorf("encode bulk action: %w", err)
+	}
+	if doc != nil {
+		if err := json.NewEncoder(&w.buf).Encode(doc); err != nil {
+			return fmt.Errorf("encode document %s: %w", id, err)
+		}
+	}
+
+	w.pending++
+	if w.pending >= w.size {
+		return w.Flush(ctx)
+	}
+	return nil
+}
+
+// Flush sends the buffered actions. Items Elasticsearch rejects are
+// collected in failed rather than returned, so one bad document does not
+// stop the run.
+func (w *bulkWriter) Flush(ctx context.Context) error {
+	if w.pending == 0 {
+		return nil
+	}
+
+	failed, err := db.Bulk(ctx, &w.buf)
+	if err != nil {
+		return err
+	}
+	for id, reason := range failed {
+		w.failed[id] = reason
+		log.Println("⚠️ Bulk item failed:", id, reason)
+	}
+	w.written += w.pending - len(failed)
+
+	w.buf.Reset()
+	w.pending = 0
+	return nil
+}
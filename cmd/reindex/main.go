@@ -0,0 +1,65 @@
+// Command reindex rebuilds the campaigns search index from Scylla and checks
+// that the two agree.
+//
+//	go run ./cmd/reindex build  [-workers 8] [-ranges 256] [-batch 500] [-replicas 1] [-keep]
+//	go run ./cmd/reindex verify [-workers 8] [-ranges 256] [-index campaigns] [-fix]
+//
+// build creates a new index named campaigns_v<unix time> with the current
+// mapping, loads every campaign into it and then moves the campaigns alias
+// to it in a single request. Changes the sync workers made to the old index
+// while it ran are caught up with a verify -fix pass, after which the old
+// indexes are deleted unless -keep is given. An old index named campaigns
+// itself, from before the alias existed, is always replaced.
+//
+// verify compares every campaign in Scylla with its search document and
+// reports documents that are missing, stale or have no campaign. It exits
+// with status 1 when it finds any, unless -fix repaired them.
+package main
+
+import (
+	"context"
+	"fmt"
+	"go-fundraising/configs"
+	"go-fundraising/db"
+	"log"
+	"os"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	configs.LoadEnv()
+	db.InitScylla()
+	db.InitElastic()
+
+	ctx := context.Background()
+	var (
+		clean bool
+		err   error
+	)
+	switch os.Args[1] {
+	case "build":
+		err = build(ctx, os.Args[2:])
+		clean = err == nil
+	case "verify":
+		clean, err = verify(ctx, os.Args[2:])
+	default:
+		usage()
+	}
+
+	db.CloseElastic()
+	db.CloseScylla()
+	if err != nil {
+		log.Fatalln("❌", err)
+	}
+	if !clean {
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: reindex build|verify [flags]")
+	os.Exit(2)
+}
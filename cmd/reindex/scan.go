@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go-fundraising/campaign/models"
+	"go-fundraising/db"
+	"go-fundraising/worker"
+	"math"
+	"sync"
+
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+)
+
+// scanCampaigns sends every campaign to out. The token ring is split into
+// ranges that are read concurrently, so no single query walks the whole
+// table and a slow range does not hold up the rest.
+func scanCampaigns(ctx context.Context, ranges, workers int, out chan<- models.Campaign) error {
+	stmt, names := qb.Select(models.CampaignTable.Name).
+		Columns(models.CampaignTable.Columns...).
+		Where(qb.Token("id").GtValueNamed("lo"), qb.Token("id").LtOrEqValueNamed("hi")).
+		ToCql()
+
+	bounds := make(chan [2]int64)
+	go func() {
+		defer close(bounds)
+		for _, r := range tokenRanges(ranges) {
+			select {
+			case bounds <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range bounds {
+				q := db.ScyllaSession.Query(stmt).WithContext(ctx).PageSize(1000)
+				iter := gocqlx.Query(q, names).
+					BindMap(map[string]interface{}{"lo": r[0], "hi": r[1]}).
+					Iter()
+
+				var campaign models.Campaign
+				for iter.StructScan(&campaign) {
+					out <- campaign
+					campaign = models.Campaign{}
+				}
+				if err := iter.Close(); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
+type document struct {
+	id     string
+	source map[string]interface{}
+}
+
+// scanDocuments builds the search document of every campaign and sends it
+// to out. Documents are built concurrently since each takes a few queries.
+func scanDocuments(ctx context.Context, ranges, workers int, out chan<- document) error {
+	campaigns := make(chan models.Campaign, 1000)
+	scanErr := make(chan error, 1)
+	go func() {
+		scanErr <- scanCampaigns(ctx, ranges, workers, campaigns)
+		close(campaigns)
+	}()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for campaign := range campaigns {
+				source, err := worker.Document(ctx, campaign)
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = fmt.Errorf("build document %s: %w", campaign.ID, err)
+					}
+					mu.Unlock()
+					continue
+				}
+				out <- document{id: campaign.ID.String(), source: source}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := <-scanErr; err != nil {
+		return fmt.Errorf("scan campaigns: %w", err)
+	}
+	return firstErr
+}
+
+// tokenRanges splits the Murmur3 token ring into n contiguous (lo, hi]
+// ranges. The lowest token is never assigned to a row.
+func tokenRanges(n int) [][2]int64 {
+	if n < 1 {
+		n = 1
+	}
+	step := math.MaxUint64 / uint64(n)
+
+	ranges := make([][2]int64, n)
+	first := int64(math.MinInt64)
+	lo := first
+	for i := range ranges {
+		hi := int64(math.MaxInt64)
+		if i < n-1 {
+			hi = int64(uint64(first) + uint64(i+1)*step)
+		}
+		ranges[i] = [2]int64{lo, hi}
+		lo = hi
+	}
+	return ranges
+}
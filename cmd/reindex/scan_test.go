@@ -0,0 +1,35 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTokenRanges(t *testing.T) {
+	for _, n := range []int{-1, 0, 1, 2, 3, 7, 16, 256, 1000} {
+		ranges := tokenRanges(n)
+
+		want := n
+		if want < 1 {
+			want = 1
+		}
+		if len(ranges) != want {
+			t.Errorf("tokenRanges(%d) has %d ranges, want %d", n, len(ranges), want)
+			continue
+		}
+		if ranges[0][0] != math.MinInt64 {
+			t.Errorf("tokenRanges(%d) starts at %d, want MinInt64", n, ranges[0][0])
+		}
+		if last := ranges[len(ranges)-1]; last[1] != math.MaxInt64 {
+			t.Errorf("tokenRanges(%d) ends at %d, want MaxInt64", n, last[1])
+		}
+		for i, r := range ranges {
+			if r[0] >= r[1] {
+				t.Errorf("tokenRanges(%d)[%d] = %v is empty", n, i, r)
+			}
+			if i > 0 && r[0] != ranges[i-1][1] {
+				t.Errorf("tokenRanges(%d)[%d] starts at %d, want %d where the previous one ends", n, i, r[0], ranges[i-1][1])
+			}
+		}
+	}
+}
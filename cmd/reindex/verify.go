@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go-fundraising/db"
+	"go-fundraising/worker"
+	"log"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// sampleSize is how many ids of each kind of difference are printed.
+const sampleSize = 10
+
+func verify(ctx context.Context, args []string) (bool, error) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	workers := fs.Int("workers", 8, "concurrent token range scans")
+	ranges := fs.Int("ranges", 256, "token ranges to split the campaigns table into")
+	batch := fs.Int("batch", 500, "documents per bulk request when fixing")
+	index := fs.String("index", worker.IndexAlias, "index or alias to check")
+	fix := fs.Bool("fix", false, "reindex missing and stale documents and delete orphaned ones")
+	fs.Parse(args)
+
+	result, err := compare(ctx, *index, *ranges, *workers, *batch, *fix)
+	if err != nil {
+		return false, err
+	}
+	result.print()
+
+	return result.clean() || *fix, nil
+}
+
+type comparison struct {
+	index     string
+	campaigns int
+	documents int
+
+	// missing campaigns have no document, stale ones a document that
+	// differs in the fields listed, and orphaned documents no campaign.
+	missing  []string
+	stale    map[string][]string
+	orphaned []string
+	fixed    bool
+}
+
+func (c comparison) clean() bool {
+	return len(c.missing) == 0 && len(c.stale) == 0 && len(c.orphaned) == 0
+}
+
+func (c comparison) print() {
+	log.Printf("🔎 %s: %d campaigns in Scylla, %d documents indexed\n", c.index, c.campaigns, c.documents)
+	if c.clean() {
+		log.Println("✔️ Search index matches Scylla")
+		return
+	}
+
+	log.Printf("⚠️ %d missing, %d stale, %d orphaned\n", len(c.missing), len(c.stale), len(c.orphaned))
+	for _, id := range sample(c.missing) {
+		log.Println("   missing: ", id)
+	}
+	stale := make([]string, 0, len(c.stale))
+	for id := range c.stale {
+		stale = append(stale, id)
+	}
+	for _, id := range sample(stale) {
+		log.Println("   stale:   ", id, c.stale[id])
+	}
+	for _, id := range sample(c.orphaned) {
+		log.Println("   orphaned:", id)
+	}
+	if c.fixed {
+		log.Println("✔️ Differences fixed")
+	}
+}
+
+func sample(ids []string) []string {
+	sort.Strings(ids)
+	if len(ids) > sampleSize {
+		return ids[:sampleSize]
+	}
+	return ids
+}
+
+// compare checks every campaign against its document in index, and with fix
+// rewrites the documents that differ. Documents are read before campaigns,
+// so a campaign created during the run shows up as missing at worst, which
+// fixing just indexes again.
+func compare(ctx context.Context, index string, ranges, workers, batch int, fix bool) (comparison, error) {
+	result := comparison{index: index, stale: map[string][]string{}}
+
+	documents, err := loadDocuments(ctx, index)
+	if err != nil {
+		return result, err
+	}
+	result.documents = len(documents)
+
+	built := make(chan document, 1000)
+	scanErr := make(chan error, 1)
+	go func() {
+		scanErr <- scanDocuments(ctx, ranges, workers, built)
+		close(built)
+	}()
+
+	repairs := map[string]map[string]interface{}{}
+	var encodeErr error
+	for doc := range built {
+		result.campaigns++
+		id := doc.id
+		want, err := normalize(doc.source)
+		if err != nil {
+			encodeErr = fmt.Errorf("encode document %s: %w", id, err)
+			continue
+		}
+
+		got, ok := documents[id]
+		if !ok {
+			result.missing = append(result.missing, id)
+			repairs[id] = want
+			continue
+		}
+		delete(documents, id)
+		if fields := diffFields(want, got); len(fields) > 0 {
+			result.stale[id] = fields
+			repairs[id] = want
+		}
+	}
+	if err := <-scanErr; err != nil {
+		return result, err
+	}
+	if encodeErr != nil {
+		return result, encodeErr
+	}
+	for id := range documents {
+		result.orphaned = append(result.orphaned, id)
+	}
+
+	if !fix || result.clean() {
+		return result, nil
+	}
+
+	w := newBulkWriter(index, batch)
+	for id, doc := range repairs {
+		if err := w.Index(ctx, id, doc); err != nil {
+			return result, err
+		}
+	}
+	for _, id := range result.orphaned {
+		if err := w.Delete(ctx, id); err != nil {
+			return result, err
+		}
+	}
+	if err := w.Flush(ctx); err != nil {
+		return result, err
+	}
+	if len(w.failed) > 0 {
+		return result, fmt.Errorf("%d fixes were rejected", len(w.failed))
+	}
+	result.fixed = true
+	return result, nil
+}
+
+// normalize gives doc the shape it has when read back from Elasticsearch.
+func normalize(doc map[string]interface{}) (map[string]interface{}, error) {
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]interface{}
+	err = json.Unmarshal(raw, &out)
+	return out, err
+}
+
+func diffFields(want, got map[string]interface{}) []string {
+	var fields []string
+	for field, value := range want {
+		if !reflect.DeepEqual(value, got[field]) {
+			fields = append(fields, field)
+		}
+	}
+	for field := range got {
+		if _, ok := want[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// loadDocuments reads every document in index with a scroll, keyed by id.
+func loadDocuments(ctx context.Context, index string) (map[string]map[string]interface{}, error) {
+	type page struct {
+		ScrollID string `json:"_scroll_id"`
+		Hits     struct {
+			Hits []struct {
+				ID     string                 `json:"_id"`
+				Source map[string]interface{} `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+
+	res, err := db.ElasticClient.Search(
+		db.ElasticClient.Search.WithContext(ctx),
+		db.ElasticClient.Search.WithIndex(index),
+		db.ElasticClient.Search.WithScroll(time.Minute),
+		db.ElasticClient.Search.WithSize(1000),
+		db.ElasticClient.Search.WithSort("_doc"),
+	)
+
+	documents := map[string]map[string]interface{}{}
+	var scrollID string
+	defer func() {
+		if scrollID == "" {
+			return
+		}
+		res, err := db.ElasticClient.ClearScroll(db.ElasticClient.ClearScroll.WithScrollID(scrollID))
+		if err == nil {
+			res.Body.Close()
+		}
+	}()
+
+	for {
+		if err != nil {
+			return nil, fmt.Errorf("scroll %s: %w", index, err)
+		}
+		if res.IsError() {
+			res.Body.Close()
+			return nil, fmt.Errorf("scroll %s: %s", index, res.Status())
+		}
+
+		var p page
+		err = json.NewDecoder(res.Body).Decode(&p)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decode scroll %s: %w", index, err)
+		}
+		scrollID = p.ScrollID
+		if len(p.Hits.Hits) == 0 {
+			return documents, nil
+		}
+		for _, hit := range p.Hits.Hits {
+			documents[hit.ID] = hit.Source
+		}
+
+		res, err = db.ElasticClient.Scroll(
+			db.ElasticClient.Scroll.WithContext(ctx),
+			db.ElasticClient.Scroll.WithScrollID(scrollID),
+			db.ElasticClient.Scroll.WithScroll(time.Minute),
+		)
+	}
+}
@@ -0,0 +1,158 @@
+package currency
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-fundraising/configs"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"sync"
+)
+
+const Default = "usd"
+
+var ErrNoRate = errors.New("no exchange rate for currency pair")
+
+// Currencies whose minor unit is not a hundredth, as listed by Stripe.
+var zeroDecimal = map[string]bool{
+	"bif": true, "clp": true, "djf": true, "gnf": true, "jpy": true, "kmf": true,
+	"krw": true, "mga": true, "pyg": true, "rwf": true, "ugx": true, "vnd": true,
+	"vuv": true, "xaf": true, "xof": true, "xpf": true,
+}
+
+var threeDecimal = map[string]bool{
+	"bhd": true, "jod": true, "kwd": true, "omr": true, "tnd": true,
+}
+
+// Normalize lower-cases an ISO 4217 code and reports whether it looks valid.
+func Normalize(code string) (string, bool) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if len(code) != 3 {
+		return "", false
+	}
+	for _, r := range code {
+		if r < 'a' || r > 'z' {
+			return "", false
+		}
+	}
+	return code, true
+}
+
+// Exponent is the number of decimal places of the currency's minor unit.
+func Exponent(code string) int {
+	switch {
+	case zeroDecimal[code]:
+		return 0
+	case threeDecimal[code]:
+		return 3
+	default:
+		return 2
+	}
+}
+
+// ToMinor converts a whole amount into minor units.
+func ToMinor(amount int64, code string) int64 {
+	for i := 0; i < Exponent(code); i++ {
+		amount *= 10
+	}
+	return amount
+}
+
+// Format renders a minor-unit amount in major units, e.g. 1050 usd as 10.50.
+func Format(amount int64, code string) string {
+	exp := Exponent(code)
+	if exp == 0 {
+		return fmt.Sprintf("%d", amount)
+	}
+	div := int64(math.Pow10(exp))
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/div, exp, amount%div)
+}
+
+// RateTable holds exchange rates relative to Base: one unit of Base buys
+// Rates[code] units of code.
+type RateTable struct {
+	Base  string             `json:"base"`
+	Rates map[string]float64 `json:"rates"`
+}
+
+var (
+	rates     RateTable
+	ratesOnce sync.Once
+)
+
+// Rates returns the table loaded from the JSON file at RATES_FILE. Without a
+// file only same-currency conversions succeed.
+func Rates() RateTable {
+	ratesOnce.Do(func() {
+		path := configs.GetEnv("RATES_FILE")
+		if path == "" {
+			return
+		}
+		table, err := LoadRates(path)
+		if err != nil {
+			log.Println("⚠️ Failed to load exchange rates:", err)
+			return
+		}
+		rates = table
+	})
+	return rates
+}
+
+func LoadRates(path string) (RateTable, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return RateTable{}, err
+	}
+
+	var table RateTable
+	if err := json.Unmarshal(content, &table); err != nil {
+		return RateTable{}, err
+	}
+
+	normalized := RateTable{Base: strings.ToLower(table.Base), Rates: map[string]float64{}}
+	for code, rate := range table.Rates {
+		if rate > 0 {
+			normalized.Rates[strings.ToLower(code)] = rate
+		}
+	}
+	normalized.Rates[normalized.Base] = 1
+	return normalized, nil
+}
+
+func (t RateTable) rate(code string) (float64, bool) {
+	r, ok := t.Rates[code]
+	return r, ok
+}
+
+// Convert converts a minor-unit amount between currencies, rounding to the
+// nearest minor unit of the target currency.
+func (t RateTable) Convert(amount int64, from, to string) (int64, error) {
+	if from == to {
+		return amount, nil
+	}
+	fromRate, ok := t.rate(from)
+	if !ok {
+		return 0, ErrNoRate
+	}
+	toRate, ok := t.rate(to)
+	if !ok {
+		return 0, ErrNoRate
+	}
+
+	major := float64(amount) / math.Pow10(Exponent(from))
+	converted := major / fromRate * toRate
+	return int64(math.Round(converted * math.Pow10(Exponent(to)))), nil
+}
+
+func (t RateTable) CanConvert(from, to string) bool {
+	_, err := t.Convert(0, from, to)
+	return err == nil
+}
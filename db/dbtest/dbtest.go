@@ -0,0 +1,97 @@
+// Package dbtest points db.ScyllaSession at a scratch keyspace built from
+// init.cql, for tests that need a real ScyllaDB. Such tests are skipped
+// unless SCYLLA_TEST_HOST is set.
+package dbtest
+
+import (
+	"fmt"
+	"go-fundraising/configs"
+	"go-fundraising/db"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gocql/gocql"
+)
+
+var (
+	setupOnce sync.Once
+	setupErr  error
+)
+
+// Setup skips t when no test database is configured. Otherwise the first
+// call in a test binary recreates the keyspace go_fundraising_test_<name>
+// and connects db.ScyllaSession to it; later calls reuse that session.
+// Packages pass their own name so that `go test ./...` running packages in
+// parallel never shares a keyspace.
+func Setup(t testing.TB, name string) {
+	t.Helper()
+
+	host := configs.GetEnv("SCYLLA_TEST_HOST")
+	if host == "" {
+		t.Skip("SCYLLA_TEST_HOST is not set")
+	}
+	port := configs.GetEnv("SCYLLA_TEST_PORT")
+	if port == "" {
+		port = "9042"
+	}
+
+	setupOnce.Do(func() {
+		setupErr = connect(fmt.Sprintf("%s:%s", host, port), "go_fundraising_test_"+name)
+	})
+	if setupErr != nil {
+		t.Fatalf("❌ cannot set up test keyspace: %v", setupErr)
+	}
+}
+
+func connect(addr, keyspace string) error {
+	script, err := readSchema()
+	if err != nil {
+		return err
+	}
+	script = strings.ReplaceAll(script, "go_fundraising", keyspace)
+
+	cluster := gocql.NewCluster(addr)
+	cluster.Consistency = gocql.Quorum
+
+	admin, err := cluster.CreateSession()
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	for _, stmt := range strings.Split(script, ";") {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+		if err := admin.Query(stmt).Exec(); err != nil {
+			return fmt.Errorf("%s: %w", strings.TrimSpace(stmt), err)
+		}
+	}
+
+	cluster.Keyspace = keyspace
+	db.ScyllaSession, err = cluster.CreateSession()
+	return err
+}
+
+// readSchema finds init.cql at the module root, above the test's package
+// directory.
+func readSchema() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			content, err := os.ReadFile(filepath.Join(dir, "init.cql"))
+			return string(content), err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("init.cql not found")
+		}
+		dir = parent
+	}
+}
@@ -2,7 +2,11 @@ package db
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"strings"
 
 	"go-fundraising/configs"
@@ -14,6 +18,10 @@ import (
 
 var ElasticClient *elasticsearch.Client
 
+// elasticTransport is kept to close its connections on shutdown; the client
+// itself has nothing to close.
+var elasticTransport *http.Transport
+
 func InitElastic() {
 	err := godotenv.Load()
 	if err != nil {
@@ -22,8 +30,10 @@ func InitElastic() {
 
 	url := configs.GetEnv("ELASTIC_URL")
 
+	elasticTransport = http.DefaultTransport.(*http.Transport).Clone()
 	cfg := elasticsearch.Config{
 		Addresses: []string{url},
+		Transport: elasticTransport,
 	}
 
 	ElasticClient, err = elasticsearch.NewClient(cfg)
@@ -41,6 +51,13 @@ func InitElastic() {
 
 }
 
+func CloseElastic() {
+	if elasticTransport != nil {
+		elasticTransport.CloseIdleConnections()
+		log.Println("🔌Closed Elasticsearch")
+	}
+}
+
 func splitESQueries(text string) []string {
 	return strings.Split(text, "---")
 }
@@ -52,52 +69,90 @@ func truncate(s string, max int) string {
 	return s[:max] + "..."
 }
 
-func doCreateIndex(index string, query string) {
+// CreateIndex creates index with body, which holds its settings and
+// mappings.
+func CreateIndex(ctx context.Context, index string, body string) error {
 	res, err := ElasticClient.Indices.Create(index,
-		ElasticClient.Indices.Create.WithBody(strings.NewReader(query)),
+		ElasticClient.Indices.Create.WithContext(ctx),
+		ElasticClient.Indices.Create.WithBody(strings.NewReader(body)),
 	)
 	if err != nil {
-		log.Println("❌ Error create index:", err)
-		return
+		return fmt.Errorf("create index %s: %w", index, err)
 	}
-	defer res.Body.Close()
-	log.Println("✔️ Create index result:", res.Status())
+	return checkResponse(res, "create index "+index)
 }
 
-func doPutMapping(index string, query string) {
+func PutMapping(ctx context.Context, index string, body string) error {
 	req := esapi.IndicesPutMappingRequest{
 		Index: []string{index},
-		Body:  strings.NewReader(query),
+		Body:  strings.NewReader(body),
 	}
 
-	res, err := req.Do(context.Background(), ElasticClient)
+	res, err := req.Do(ctx, ElasticClient)
 	if err != nil {
-		log.Println("❌ PutMapping error:", err)
-		return
+		return fmt.Errorf("put mapping %s: %w", index, err)
 	}
-	defer res.Body.Close()
-
-	log.Println("✔️ PutMapping:", res.Status())
+	return checkResponse(res, "put mapping "+index)
 }
 
-func doPutSettings(index string, query string) {
-	res, err := ElasticClient.Indices.PutSettings(strings.NewReader(query),
+func PutSettings(ctx context.Context, index string, body string) error {
+	res, err := ElasticClient.Indices.PutSettings(strings.NewReader(body),
+		ElasticClient.Indices.PutSettings.WithContext(ctx),
 		ElasticClient.Indices.PutSettings.WithIndex(index),
 	)
 	if err != nil {
-		log.Println("❌ Error put settings:", err)
-		return
+		return fmt.Errorf("put settings %s: %w", index, err)
 	}
-	defer res.Body.Close()
-	log.Println("✔️ Put settings:", res.Status())
+	return checkResponse(res, "put settings "+index)
 }
 
-func doBulk(query string) {
-	res, err := ElasticClient.Bulk(strings.NewReader(query), ElasticClient.Bulk.WithContext(context.Background()))
+// Bulk sends an NDJSON bulk body and returns one error per failed item,
+// keyed by document id. Elasticsearch answers 200 even when items fail.
+func Bulk(ctx context.Context, body io.Reader) (map[string]string, error) {
+	res, err := ElasticClient.Bulk(body, ElasticClient.Bulk.WithContext(ctx))
 	if err != nil {
-		log.Println("❌ Error bulk:", err)
-		return
+		return nil, fmt.Errorf("bulk: %w", err)
 	}
 	defer res.Body.Close()
-	log.Println("✔️ Bulk:", res.Status())
+	if res.IsError() {
+		return nil, responseError(res, "bulk")
+	}
+
+	var r struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			ID     string          `json:"_id"`
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("decode bulk response: %w", err)
+	}
+
+	failed := map[string]string{}
+	if !r.Errors {
+		return failed, nil
+	}
+	for _, item := range r.Items {
+		for _, result := range item {
+			if result.Status >= 300 && !(result.Status == 404 && len(result.Error) == 0) {
+				failed[result.ID] = truncate(string(result.Error), 300)
+			}
+		}
+	}
+	return failed, nil
+}
+
+func checkResponse(res *esapi.Response, op string) error {
+	defer res.Body.Close()
+	if res.IsError() {
+		return responseError(res, op)
+	}
+	return nil
+}
+
+func responseError(res *esapi.Response, op string) error {
+	body, _ := io.ReadAll(res.Body)
+	return fmt.Errorf("%s: %s: %s", op, res.Status(), truncate(string(body), 500))
 }
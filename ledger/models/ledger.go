@@ -0,0 +1,100 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/table"
+)
+
+// KindRefundReversal puts back a refund that failed after it had succeeded.
+// KindDonationReversal takes back a donation to a campaign that was deleted
+// while it was being paid.
+const (
+	KindDonation         = "donation"
+	KindDonationReversal = "donation_reversal"
+	KindFee              = "fee"
+	KindRefund           = "refund"
+	KindRefundReversal   = "refund_reversal"
+	KindPayout           = "payout"
+)
+
+const (
+	Debit  = "debit"
+	Credit = "credit"
+)
+
+// Accounts outside of campaigns. Money held by the payment provider sits in
+// ProviderAccount until it is paid out or refunded.
+const (
+	ProviderAccount = "provider"
+	FeesAccount     = "fees"
+	PayoutsAccount  = "payouts"
+)
+
+const campaignAccountPrefix = "campaign:"
+
+func CampaignAccount(campaignID gocql.UUID) string {
+	return campaignAccountPrefix + campaignID.String()
+}
+
+// CampaignOfAccount returns the campaign whose account this is, or false for
+// accounts outside of campaigns.
+func CampaignOfAccount(account string) (gocql.UUID, bool) {
+	if !strings.HasPrefix(account, campaignAccountPrefix) {
+		return gocql.UUID{}, false
+	}
+	campaignID, err := gocql.ParseUUID(strings.TrimPrefix(account, campaignAccountPrefix))
+	return campaignID, err == nil
+}
+
+// Entry is one side of a ledger transaction. Entries are only ever inserted;
+// corrections are new transactions. Amount is positive and in minor units.
+type Entry struct {
+	Account       string     `db:"account"`
+	CreatedAt     time.Time  `db:"created_at"`
+	ID            gocql.UUID `db:"id"`
+	TransactionID gocql.UUID `db:"transaction_id"`
+	Kind          string     `db:"kind"`
+	Direction     string     `db:"direction"`
+	Amount        int64      `db:"amount"`
+	Currency      string     `db:"currency"`
+	Reference     string     `db:"reference"`
+}
+
+var EntryTable = table.Metadata{
+	Name:    "ledger_entries",
+	Columns: []string{"account", "created_at", "id", "transaction_id", "kind", "direction", "amount", "currency", "reference"},
+	PartKey: []string{"account"},
+	SortKey: []string{"created_at", "id"},
+}
+
+// TransactionReference makes posting idempotent: a transaction is only
+// written after claiming its kind and external reference.
+type TransactionReference struct {
+	Reference     string     `db:"reference"`
+	TransactionID gocql.UUID `db:"transaction_id"`
+	CreatedAt     time.Time  `db:"created_at"`
+}
+
+var TransactionReferenceTable = table.Metadata{
+	Name:    "ledger_references",
+	Columns: []string{"reference", "transaction_id", "created_at"},
+	PartKey: []string{"reference"},
+}
+
+type Posting struct {
+	Account   string
+	Direction string
+	Amount    int64
+}
+
+type Transaction struct {
+	ID        gocql.UUID
+	Kind      string
+	Reference string
+	Currency  string
+	Postings  []Posting
+	CreatedAt time.Time
+}
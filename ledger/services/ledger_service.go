@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"go-fundraising/db"
+	"go-fundraising/ledger/models"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+)
+
+type LedgerService struct{}
+
+var ErrUnbalancedTransaction = errors.New("ledger transaction is not balanced")
+
+// Post writes a balanced transaction exactly once per kind and reference. It
+// returns false when the transaction had already been posted. Entries are
+// written after the reference is claimed, so a repeated Post re-issues them
+// under the claimed transaction: entry IDs derive from the transaction ID and
+// a retry overwrites whatever an earlier, failed attempt managed to write.
+func (s *LedgerService) Post(ctx context.Context, tx models.Transaction) (bool, error) {
+	if err := validate(tx); err != nil {
+		return false, err
+	}
+	if tx.ID == (gocql.UUID{}) {
+		tx.ID = gocql.TimeUUID()
+	}
+	if tx.CreatedAt.IsZero() {
+		tx.CreatedAt = time.Now()
+	}
+
+	claimStmt, claimNames := qb.Insert(models.TransactionReferenceTable.Name).
+		Columns(models.TransactionReferenceTable.Columns...).
+		Unique().
+		ToCql()
+
+	ref := models.TransactionReference{
+		Reference:     tx.Kind + ":" + tx.Reference,
+		TransactionID: tx.ID,
+		CreatedAt:     tx.CreatedAt,
+	}
+	claim := gocqlx.Query(db.ScyllaSession.Query(claimStmt).WithContext(ctx), claimNames).BindStruct(ref)
+	applied, err := claim.MapScanCAS(map[string]interface{}{})
+	claim.Release()
+	if err != nil {
+		return false, err
+	}
+	if !applied {
+		existing, err := s.getReference(ctx, ref.Reference)
+		if err != nil {
+			return false, err
+		}
+		tx.ID = existing.TransactionID
+		tx.CreatedAt = existing.CreatedAt
+	}
+
+	stmt, _ := qb.Insert(models.EntryTable.Name).Columns(models.EntryTable.Columns...).ToCql()
+	batch := db.ScyllaSession.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+	for i, p := range tx.Postings {
+		batch.Query(stmt,
+			p.Account, tx.CreatedAt, entryID(tx.ID, i), tx.ID, tx.Kind,
+			p.Direction, p.Amount, tx.Currency, tx.Reference,
+		)
+	}
+
+	if err := db.ScyllaSession.ExecuteBatch(batch); err != nil {
+		return false, err
+	}
+	return applied, nil
+}
+
+func (s *LedgerService) getReference(ctx context.Context, reference string) (models.TransactionReference, error) {
+	var ref models.TransactionReference
+
+	stmt, names := qb.Select(models.TransactionReferenceTable.Name).Where(qb.Eq("reference")).ToCql()
+	err := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"reference": reference}).
+		GetRelease(&ref)
+	return ref, err
+}
+
+// entryID is the ID of the i-th posting of transaction txID. It keeps the
+// transaction's timestamp and clock sequence, which already make it unique,
+// and adds i to the node, so the result is still a valid time UUID.
+func entryID(txID gocql.UUID, i int) gocql.UUID {
+	id := txID
+	id[15] += byte(i)
+	return id
+}
+
+func (s *LedgerService) PostDonation(ctx context.Context, campaignID gocql.UUID, checkoutID string, amount int64, currency string) (bool, error) {
+	return s.Post(ctx, transfer(models.KindDonation, checkoutID, currency, amount,
+		models.ProviderAccount, models.CampaignAccount(campaignID)))
+}
+
+func (s *LedgerService) PostDonationReversal(ctx context.Context, campaignID gocql.UUID, checkoutID string, amount int64, currency string) (bool, error) {
+	return s.Post(ctx, transfer(models.KindDonationReversal, checkoutID, currency, amount,
+		models.CampaignAccount(campaignID), models.ProviderAccount))
+}
+
+func (s *LedgerService) PostRefund(ctx context.Context, campaignID gocql.UUID, refundID string, amount int64, currency string) (bool, error) {
+	return s.Post(ctx, transfer(models.KindRefund, refundID, currency, amount,
+		models.CampaignAccount(campaignID), models.ProviderAccount))
+}
+
+func (s *LedgerService) PostRefundReversal(ctx context.Context, campaignID gocql.UUID, refundID string, amount int64, currency string) (bool, error) {
+	return s.Post(ctx, transfer(models.KindRefundReversal, refundID, currency, amount,
+		models.ProviderAccount, models.CampaignAccount(campaignID)))
+}
+
+func (s *LedgerService) PostFee(ctx context.Context, campaignID gocql.UUID, reference string, amount int64, currency string) (bool, error) {
+	return s.Post(ctx, transfer(models.KindFee, reference, currency, amount,
+		models.CampaignAccount(campaignID), models.FeesAccount))
+}
+
+func (s *LedgerService) PostPayout(ctx context.Context, campaignID gocql.UUID, reference string, amount int64, currency string) (bool, error) {
+	return s.Post(ctx, transfer(models.KindPayout, reference, currency, amount,
+		models.CampaignAccount(campaignID), models.PayoutsAccount))
+}
+
+func (s *LedgerService) GetEntries(ctx context.Context, account string) ([]models.Entry, error) {
+	var entries []models.Entry
+
+	stmt, names := qb.Select(models.EntryTable.Name).Where(qb.Eq("account")).ToCql()
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"account": account})
+
+	if err := q.SelectRelease(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// GetBalance returns credits minus debits of account per currency.
+func (s *LedgerService) GetBalance(ctx context.Context, account string) (map[string]int64, error) {
+	entries, err := s.GetEntries(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+
+	balance := map[string]int64{}
+	for _, e := range entries {
+		if e.Direction == models.Credit {
+			balance[e.Currency] += e.Amount
+		} else {
+			balance[e.Currency] -= e.Amount
+		}
+	}
+	return balance, nil
+}
+
+func (s *LedgerService) GetCampaignBalance(ctx context.Context, campaignID gocql.UUID) (map[string]int64, error) {
+	return s.GetBalance(ctx, models.CampaignAccount(campaignID))
+}
+
+// transfer builds a two-legged transaction moving amount from one account to
+// another.
+func transfer(kind, reference, currency string, amount int64, from, to string) models.Transaction {
+	return models.Transaction{
+		Kind:      kind,
+		Reference: reference,
+		Currency:  currency,
+		Postings: []models.Posting{
+			{Account: from, Direction: models.Debit, Amount: amount},
+			{Account: to, Direction: models.Credit, Amount: amount},
+		},
+	}
+}
+
+func validate(tx models.Transaction) error {
+	if tx.Kind == "" || tx.Reference == "" || tx.Currency == "" || len(tx.Postings) < 2 {
+		return errors.New("ledger transaction needs a kind, reference, currency and postings")
+	}
+
+	var debits, credits int64
+	for _, p := range tx.Postings {
+		if p.Amount <= 0 {
+			return errors.New("ledger posting amounts must be positive")
+		}
+		switch p.Direction {
+		case models.Debit:
+			debits += p.Amount
+		case models.Credit:
+			credits += p.Amount
+		default:
+			return errors.New("unknown ledger posting direction")
+		}
+	}
+	if debits != credits {
+		return ErrUnbalancedTransaction
+	}
+	return nil
+}
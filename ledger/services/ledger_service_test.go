@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"go-fundraising/db"
+	"go-fundraising/db/dbtest"
+	"go-fundraising/ledger/models"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+)
+
+func TestValidate(t *testing.T) {
+	balanced := transfer(models.KindDonation, "cs_1", "usd", 500, models.ProviderAccount, "campaign:x")
+	if err := validate(balanced); err != nil {
+		t.Fatalf("balanced transfer: %v", err)
+	}
+
+	unbalanced := balanced
+	unbalanced.Postings = []models.Posting{
+		{Account: models.ProviderAccount, Direction: models.Debit, Amount: 500},
+		{Account: "campaign:x", Direction: models.Credit, Amount: 400},
+	}
+	if err := validate(unbalanced); !errors.Is(err, ErrUnbalancedTransaction) {
+		t.Errorf("unbalanced: err = %v, want ErrUnbalancedTransaction", err)
+	}
+
+	cases := map[string]models.Transaction{
+		"missing reference": transfer(models.KindDonation, "", "usd", 500, "a", "b"),
+		"zero amount":       transfer(models.KindDonation, "cs_1", "usd", 0, "a", "b"),
+		"negative amount":   transfer(models.KindDonation, "cs_1", "usd", -5, "a", "b"),
+		"one posting": {
+			Kind: models.KindDonation, Reference: "cs_1", Currency: "usd",
+			Postings: []models.Posting{{Account: "a", Direction: models.Debit, Amount: 5}},
+		},
+		"unknown direction": {
+			Kind: models.KindDonation, Reference: "cs_1", Currency: "usd",
+			Postings: []models.Posting{
+				{Account: "a", Direction: "sideways", Amount: 5},
+				{Account: "b", Direction: models.Credit, Amount: 5},
+			},
+		},
+	}
+	for name, tx := range cases {
+		if err := validate(tx); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestEntryID(t *testing.T) {
+	txID := gocql.TimeUUID()
+
+	first, second := entryID(txID, 0), entryID(txID, 1)
+	if first == second {
+		t.Fatal("postings of one transaction share an entry ID")
+	}
+	if entryID(txID, 1) != second {
+		t.Error("entry ID is not deterministic")
+	}
+	if second.Version() != 1 || second.Timestamp() != txID.Timestamp() {
+		t.Errorf("entry ID %s is not a time UUID at the transaction's time", second)
+	}
+	if entryID(gocql.TimeUUID(), 1) == second {
+		t.Error("entry IDs of different transactions collide")
+	}
+}
+
+func TestPostIsIdempotent(t *testing.T) {
+	dbtest.Setup(t, "ledger")
+	ctx := context.Background()
+	ledger := LedgerService{}
+	campaignID := gocql.TimeUUID()
+	checkoutID := "cs_" + gocql.TimeUUID().String()
+
+	posted, err := ledger.PostDonation(ctx, campaignID, checkoutID, 2500, "usd")
+	if err != nil || !posted {
+		t.Fatalf("first post = %v, %v; want true", posted, err)
+	}
+	posted, err = ledger.PostDonation(ctx, campaignID, checkoutID, 2500, "usd")
+	if err != nil || posted {
+		t.Fatalf("second post = %v, %v; want false", posted, err)
+	}
+
+	entries, err := ledger.GetEntries(ctx, models.CampaignAccount(campaignID))
+	if err != nil {
+		t.Fatalf("get entries: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("campaign account has %d entries, want 1", len(entries))
+	}
+
+	balance, err := ledger.GetCampaignBalance(ctx, campaignID)
+	if err != nil {
+		t.Fatalf("get balance: %v", err)
+	}
+	if balance["usd"] != 2500 {
+		t.Errorf("balance = %d, want 2500", balance["usd"])
+	}
+}
+
+func TestPostRewritesEntriesOfClaimedReference(t *testing.T) {
+	dbtest.Setup(t, "ledger")
+	ctx := context.Background()
+	ledger := LedgerService{}
+	campaignID := gocql.TimeUUID()
+	refundID := "re_" + gocql.TimeUUID().String()
+
+	// An earlier attempt claimed the reference and failed before its
+	// entries were written.
+	claimed := models.TransactionReference{
+		Reference:     models.KindRefund + ":" + refundID,
+		TransactionID: gocql.TimeUUID(),
+		CreatedAt:     time.Now().Add(-time.Hour).Truncate(time.Millisecond),
+	}
+	stmt, names := qb.Insert(models.TransactionReferenceTable.Name).
+		Columns(models.TransactionReferenceTable.Columns...).
+		ToCql()
+	err := gocqlx.Query(db.ScyllaSession.Query(stmt), names).BindStruct(claimed).ExecRelease()
+	if err != nil {
+		t.Fatalf("claim reference: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := ledger.PostRefund(ctx, campaignID, refundID, 700, "usd"); err != nil {
+			t.Fatalf("post refund: %v", err)
+		}
+	}
+
+	entries, err := ledger.GetEntries(ctx, models.CampaignAccount(campaignID))
+	if err != nil {
+		t.Fatalf("get entries: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("campaign account has %d entries, want 1", len(entries))
+	}
+	if entries[0].TransactionID != claimed.TransactionID || !entries[0].CreatedAt.Equal(claimed.CreatedAt) {
+		t.Errorf("entry belongs to %s at %s, want the claimed %s at %s",
+			entries[0].TransactionID, entries[0].CreatedAt, claimed.TransactionID, claimed.CreatedAt)
+	}
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	campaignModels "go-fundraising/campaign/models"
+	"go-fundraising/db"
+	"go-fundraising/ledger/models"
+	"log"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+)
+
+type ReconcileService struct {
+	ledger LedgerService
+}
+
+// Drift is a campaign whose cached amount_collected no longer matches the
+// balance derived from the ledger.
+type Drift struct {
+	CampaignID gocql.UUID `json:"campaign_id"`
+	Currency   string     `json:"currency"`
+	Cached     int64      `json:"cached"`
+	Ledger     int64      `json:"ledger"`
+}
+
+// Reconcile compares the cached totals of every campaign against its ledger
+// balance in each currency, where a missing total or balance counts as zero.
+// Campaigns are found both by their ledger accounts, which outlive deleted
+// campaigns, and by their totals rows, so drift shows up whichever side is
+// missing.
+func (s *ReconcileService) Reconcile(ctx context.Context) ([]Drift, error) {
+	var drifts []Drift
+	seen := map[gocql.UUID]bool{}
+
+	check := func(campaignID gocql.UUID) error {
+		if seen[campaignID] {
+			return nil
+		}
+		seen[campaignID] = true
+
+		found, err := s.campaignDrifts(ctx, campaignID)
+		drifts = append(drifts, found...)
+		return err
+	}
+
+	stmt, names := qb.Select(models.EntryTable.Name).Distinct("account").ToCql()
+	iter := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).Iter()
+	var account string
+	for iter.Scan(&account) {
+		campaignID, ok := models.CampaignOfAccount(account)
+		if !ok {
+			continue
+		}
+		if err := check(campaignID); err != nil {
+			iter.Close()
+			return nil, err
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	stmt, names = qb.Select(campaignModels.CampaignTotalsTable.Name).Distinct("campaign_id").ToCql()
+	iter = gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).Iter()
+	var campaignID gocql.UUID
+	for iter.Scan(&campaignID) {
+		if err := check(campaignID); err != nil {
+			iter.Close()
+			return nil, err
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	return drifts, nil
+}
+
+// campaignDrifts compares one campaign's totals with its ledger balance.
+func (s *ReconcileService) campaignDrifts(ctx context.Context, campaignID gocql.UUID) ([]Drift, error) {
+	balance, err := s.ledger.GetCampaignBalance(ctx, campaignID)
+	if err != nil {
+		return nil, err
+	}
+
+	var totals []campaignModels.CampaignTotals
+	stmt, names := qb.Select(campaignModels.CampaignTotalsTable.Name).
+		Columns(campaignModels.CampaignTotalsTable.Columns...).
+		Where(qb.Eq("campaign_id")).
+		ToCql()
+	err = gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"campaign_id": campaignID}).
+		SelectRelease(&totals)
+	if err != nil {
+		return nil, err
+	}
+
+	cached := map[string]int64{}
+	for _, total := range totals {
+		cached[total.Currency] = total.AmountCollected
+	}
+
+	var drifts []Drift
+	for code := range cached {
+		if cached[code] != balance[code] {
+			drifts = append(drifts, Drift{CampaignID: campaignID, Currency: code, Cached: cached[code], Ledger: balance[code]})
+		}
+	}
+	for code := range balance {
+		if _, ok := cached[code]; !ok && balance[code] != 0 {
+			drifts = append(drifts, Drift{CampaignID: campaignID, Currency: code, Ledger: balance[code]})
+		}
+	}
+	return drifts, nil
+}
+
+// StartReconciler runs Reconcile every interval and logs any drift found,
+// until ctx is done. A run in progress is abandoned then.
+func StartReconciler(ctx context.Context, interval time.Duration) {
+	service := ReconcileService{}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+
+			drifts, err := service.Reconcile(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				log.Println("❌ Ledger reconciliation failed:", err)
+				continue
+			}
+			for _, d := range drifts {
+				log.Printf("⚠️ Ledger drift on campaign %s (%s): cached %d, ledger %d\n", d.CampaignID, d.Currency, d.Cached, d.Ledger)
+			}
+			log.Printf("✔️ Ledger reconciliation done, %d drifted campaigns\n", len(drifts))
+		}
+	}()
+
+	log.Println("🚀 Started ledger reconciler every", interval)
+}
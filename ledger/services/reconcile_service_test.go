@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	campaignModels "go-fundraising/campaign/models"
+	"go-fundraising/db"
+	"go-fundraising/db/dbtest"
+	"go-fundraising/ledger/models"
+	"testing"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+)
+
+func addTotal(t *testing.T, campaignID gocql.UUID, code string, amount int64) {
+	t.Helper()
+	stmt, names := qb.Update(campaignModels.CampaignTotalsTable.Name).
+		Add("amount_collected").
+		Where(qb.Eq("campaign_id"), qb.Eq("currency")).
+		ToCql()
+	err := gocqlx.Query(db.ScyllaSession.Query(stmt), names).BindMap(map[string]interface{}{
+		"campaign_id":      campaignID,
+		"currency":         code,
+		"amount_collected": amount,
+	}).ExecRelease()
+	if err != nil {
+		t.Fatalf("add total: %v", err)
+	}
+}
+
+func TestReconcileReportsDrift(t *testing.T) {
+	dbtest.Setup(t, "ledger")
+	ctx := context.Background()
+	ledger := LedgerService{}
+
+	balanced, drifted := gocql.TimeUUID(), gocql.TimeUUID()
+	for _, campaignID := range []gocql.UUID{balanced, drifted} {
+		if _, err := ledger.PostDonation(ctx, campaignID, "cs_"+gocql.TimeUUID().String(), 1000, "usd"); err != nil {
+			t.Fatalf("post donation: %v", err)
+		}
+		if _, err := ledger.PostDonation(ctx, campaignID, "cs_"+gocql.TimeUUID().String(), 300, "eur"); err != nil {
+			t.Fatalf("post donation: %v", err)
+		}
+		addTotal(t, campaignID, "usd", 1000)
+		addTotal(t, campaignID, "eur", 300)
+	}
+	// A donation counted twice in the cached totals.
+	addTotal(t, drifted, "eur", 300)
+
+	service := ReconcileService{}
+	drifts, err := service.Reconcile(ctx)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	var found []Drift
+	for _, d := range drifts {
+		if d.CampaignID == balanced || d.CampaignID == drifted {
+			found = append(found, d)
+		}
+	}
+	want := Drift{CampaignID: drifted, Currency: "eur", Cached: 600, Ledger: 300}
+	if len(found) != 1 || found[0] != want {
+		t.Errorf("drifts = %+v, want [%+v]", found, want)
+	}
+}
+
+func TestReconcileTreatsMissingRowsAsZero(t *testing.T) {
+	dbtest.Setup(t, "ledger")
+	ctx := context.Background()
+	ledger := LedgerService{}
+
+	// A donation that never reached the totals, and a total with no ledger
+	// entries behind it.
+	untotalled, unposted := gocql.TimeUUID(), gocql.TimeUUID()
+	if _, err := ledger.PostDonation(ctx, untotalled, "cs_"+gocql.TimeUUID().String(), 500, "usd"); err != nil {
+		t.Fatalf("post donation: %v", err)
+	}
+	addTotal(t, unposted, "usd", 200)
+
+	service := ReconcileService{}
+	drifts, err := service.Reconcile(ctx)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	found := map[gocql.UUID]Drift{}
+	for _, d := range drifts {
+		if d.CampaignID == untotalled || d.CampaignID == unposted {
+			found[d.CampaignID] = d
+		}
+	}
+	want := map[gocql.UUID]Drift{
+		untotalled: {CampaignID: untotalled, Currency: "usd", Cached: 0, Ledger: 500},
+		unposted:   {CampaignID: unposted, Currency: "usd", Cached: 200, Ledger: 0},
+	}
+	if len(found) != len(want) || found[untotalled] != want[untotalled] || found[unposted] != want[unposted] {
+		t.Errorf("drifts = %+v, want %+v", found, want)
+	}
+}
+
+func TestCampaignOfAccount(t *testing.T) {
+	campaignID := gocql.TimeUUID()
+	tests := []struct {
+		account string
+		want    gocql.UUID
+		ok      bool
+	}{
+		{models.CampaignAccount(campaignID), campaignID, true},
+		{models.ProviderAccount, gocql.UUID{}, false},
+		{"campaign:not-a-uuid", gocql.UUID{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := models.CampaignOfAccount(tt.account)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("CampaignOfAccount(%q) = %s, %v, want %s, %v", tt.account, got, ok, tt.want, tt.ok)
+		}
+	}
+}
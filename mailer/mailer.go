@@ -0,0 +1,52 @@
+// Package mailer sends the service's transactional email.
+package mailer
+
+import (
+	"context"
+	"go-fundraising/configs"
+	"log"
+	"sync"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+var (
+	defaultMailer Mailer
+	defaultOnce   sync.Once
+)
+
+// Default returns the mailer selected by MAILER ("smtp", "file" or "log").
+// It is resolved on first use so that .env has been loaded.
+func Default() Mailer {
+	defaultOnce.Do(func() {
+		switch configs.GetEnv("MAILER") {
+		case "smtp":
+			defaultMailer = NewSMTPMailer(
+				configs.GetEnv("SMTP_HOST"),
+				configs.GetEnv("SMTP_PORT"),
+				configs.GetEnv("SMTP_USERNAME"),
+				configs.GetEnv("SMTP_PASSWORD"),
+				configs.GetEnv("MAIL_FROM"),
+			)
+		case "file":
+			dir := configs.GetEnv("MAIL_DIR")
+			if dir == "" {
+				dir = "mail"
+			}
+			log.Println("⚠️ Writing outgoing mail to", dir)
+			defaultMailer = NewFileMailer(dir)
+		default:
+			log.Println("⚠️ Logging outgoing mail instead of sending it")
+			defaultMailer = LogMailer{}
+		}
+	})
+	return defaultMailer
+}
@@ -1,7 +1,10 @@
 package middleware
 
 import (
-	"go-fundraising/configs"
+	"errors"
+	"go-fundraising/auth/roles"
+	"go-fundraising/auth/services"
+	"go-fundraising/token"
 	"net/http"
 	"strings"
 
@@ -10,7 +13,7 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtKey = []byte(configs.GetEnv("JWT_KEY"))
+var sessionService = services.RefreshTokenService{}
 
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -21,26 +24,94 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		tokenStr := strings.TrimPrefix(h, "Bearer ")
+		if !authenticate(c, strings.TrimPrefix(h, "Bearer ")) {
+			return
+		}
 
-		token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
-		})
+		c.Next()
+	}
+}
 
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
-			c.Abort()
+// OptionalAuth sets user_id like AuthMiddleware when a bearer token is sent
+// and lets the request through as a guest when none is. A token that is sent
+// but invalid is still rejected rather than silently ignored.
+func OptionalAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h := c.GetHeader("Authorization")
+		if h == "" {
+			c.Next()
+			return
+		}
+		if !strings.HasPrefix(h, "Bearer ") {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 			return
 		}
 
-		claims := token.Claims.(jwt.MapClaims)
-		uid, err := gocql.ParseUUID(claims["user_id"].(string))
-		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+		if !authenticate(c, strings.TrimPrefix(h, "Bearer ")) {
 			return
 		}
-		c.Set("user_id", uid)
 
 		c.Next()
 	}
 }
+
+// authenticate validates tokenStr and stores its user_id on the context,
+// aborting with 401 and returning false when it cannot.
+func authenticate(c *gin.Context, tokenStr string) bool {
+	claims, err := token.Parse(tokenStr)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		c.Abort()
+		return false
+	}
+
+	uid, err := userIDClaim(claims)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+		return false
+	}
+
+	// Tokens issued before sessions existed carry no sid and simply run out.
+	if raw, ok := claims["sid"].(string); ok {
+		sid, err := gocql.ParseUUID(raw)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+			return false
+		}
+		active, err := sessionService.IsSessionActive(c, sid)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check session"})
+			return false
+		}
+		if !active {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "session has been revoked"})
+			return false
+		}
+		c.Set("session_id", sid)
+	}
+
+	c.Set("user_id", uid)
+	c.Set("roles", rolesClaim(claims))
+	return true
+}
+
+// rolesClaim reads the roles an access token was issued with. Tokens from
+// before roles existed have none and act with the defaults.
+func rolesClaim(claims jwt.MapClaims) []string {
+	raw, _ := claims["roles"].([]interface{})
+	held := make([]string, 0, len(raw))
+	for _, r := range raw {
+		if role, ok := r.(string); ok {
+			held = append(held, role)
+		}
+	}
+	return roles.Effective(held)
+}
+
+func userIDClaim(claims jwt.MapClaims) (gocql.UUID, error) {
+	raw, ok := claims["user_id"].(string)
+	if !ok {
+		return gocql.UUID{}, errors.New("missing user_id claim")
+	}
+	return gocql.ParseUUID(raw)
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"go-fundraising/auth/roles"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireRole lets the request through when the user holds any of the given
+// roles. It must run after AuthMiddleware.
+func RequireRole(required ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		held := Roles(c)
+		for _, role := range required {
+			if roles.Has(held, role) {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient role"})
+	}
+}
+
+// RequirePermission lets the request through when one of the user's roles
+// grants p. It must run after AuthMiddleware.
+func RequirePermission(p roles.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !Can(c, p) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient permissions"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Roles returns the roles of the authenticated user, or none for guests.
+func Roles(c *gin.Context) []string {
+	raw, _ := c.Get("roles")
+	held, _ := raw.([]string)
+	return held
+}
+
+// Can is for handlers that allow either the owner of a resource or a user
+// with p, such as a moderator.
+func Can(c *gin.Context, p roles.Permission) bool {
+	return roles.Can(Roles(c), p)
+}
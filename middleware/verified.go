@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"go-fundraising/auth/services"
+	"go-fundraising/configs"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocql/gocql"
+)
+
+var userService = services.UserService{}
+
+// RequireVerifiedEmail rejects users whose email is unverified when
+// REQUIRE_VERIFIED_EMAIL is "true". It must run after AuthMiddleware.
+func RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if configs.GetEnv("REQUIRE_VERIFIED_EMAIL") != "true" {
+			c.Next()
+			return
+		}
+
+		raw, _ := c.Get("user_id")
+		user, err := userService.GetUserByID(c, raw.(gocql.UUID))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			return
+		}
+		if !user.EmailVerified {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "verify your email address first"})
+			return
+		}
+
+		c.Next()
+	}
+}
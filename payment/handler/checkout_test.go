@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"go-fundraising/db/dbtest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newCheckoutRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/payment/create", CreatePaymentIntentHandler)
+	r.POST("/payment/webhook", PaymentWebhookHandler)
+	r.GET("/payment/fake/pay", FakePayHandler)
+	return r
+}
+
+func serve(r *gin.Engine, method, target, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// TestGuestCheckoutEndToEnd donates as a guest through the same requests a
+// browser and the provider would make, then replays the provider's webhook.
+func TestGuestCheckoutEndToEnd(t *testing.T) {
+	dbtest.Setup(t, "payment")
+	ctx := context.Background()
+	r := newCheckoutRouter()
+	campaign := newTestCampaign(t)
+
+	body, _ := json.Marshal(CreatePaymentIntentRequest{
+		Amount:     2500,
+		CampaignID: campaign.ID.String(),
+		Email:      "Guest@Example.com",
+	})
+	w := serve(r, http.MethodPost, "/payment/create", string(body), nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("create checkout: status %d: %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("decode checkout: %v", err)
+	}
+	payURL, err := url.Parse(created.URL)
+	if err != nil || payURL.Path != "/payment/fake/pay" {
+		t.Fatalf("checkout url = %q, want the fake pay page", created.URL)
+	}
+	sessionID := payURL.Query().Get("session_id")
+
+	w = serve(r, http.MethodGet, payURL.RequestURI(), "", nil)
+	if w.Code != http.StatusSeeOther || !strings.Contains(w.Header().Get("Location"), "session_id="+sessionID) {
+		t.Fatalf("pay: status %d to %q, want a redirect to the success page", w.Code, w.Header().Get("Location"))
+	}
+	assertCollected(t, campaign.ID, 2500, 1, 1)
+
+	history, err := paymentService.GetPaymentsByCampaignID(ctx, campaign.ID)
+	if err != nil {
+		t.Fatalf("get payments: %v", err)
+	}
+	if len(history) == 1 && (history[0].CheckoutID != sessionID || history[0].Amount != 2500 || history[0].Currency != "usd") {
+		t.Errorf("payment = %+v, want 2500 usd for %s", history[0], sessionID)
+	}
+
+	// The provider retries the webhook, and the donor reloads the pay page.
+	_, events, err := fakeProvider(t).Pay(sessionID)
+	if err != nil {
+		t.Fatalf("pay again: %v", err)
+	}
+	for _, event := range events {
+		w = serve(r, http.MethodPost, "/payment/webhook", string(event.Payload), event.Header)
+		if w.Code != http.StatusOK {
+			t.Fatalf("replayed webhook: status %d: %s", w.Code, w.Body.String())
+		}
+	}
+	if w = serve(r, http.MethodGet, payURL.RequestURI(), "", nil); w.Code != http.StatusSeeOther {
+		t.Fatalf("pay again: status %d: %s", w.Code, w.Body.String())
+	}
+	assertCollected(t, campaign.ID, 2500, 1, 1)
+}
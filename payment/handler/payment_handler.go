@@ -3,36 +3,50 @@ package handlers
 import (
 	"context"
 	auth "go-fundraising/auth/services"
+	campaignModels "go-fundraising/campaign/models"
 	campaign "go-fundraising/campaign/services"
-	"go-fundraising/payment/models"
+	"go-fundraising/currency"
+	ledger "go-fundraising/ledger/services"
+	"go-fundraising/payment/providers"
 	payment "go-fundraising/payment/services"
 	"net/http"
+	"net/mail"
 	"os"
-	"time"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gocql/gocql"
-	"github.com/stripe/stripe-go/v74"
-	"github.com/stripe/stripe-go/v74/checkout/session"
 )
 
 var userService = auth.UserService{}
 var paymentService = payment.PaymentService{}
 var campaignService = campaign.CampaignService{}
+var ledgerService = ledger.LedgerService{}
+var recurringService = payment.RecurringService{}
 
+// CreatePaymentIntentRequest takes Amount in minor units of Currency (cents
+// for usd, yen for jpy), which defaults to the campaign currency.
+// Recurring pledges Amount every month. Guests must give an Email;
+// Anonymous hides the donor on campaign pages.
 type CreatePaymentIntentRequest struct {
-	Amount     int64  `json:"amount" binding:"required"`
-	Currency   string `json:"currency"`
-	CampaignID string `json:"campaign_id"`
+	Amount      int64  `json:"amount" binding:"required"`
+	Currency    string `json:"currency"`
+	CampaignID  string `json:"campaign_id"`
+	Recurring   bool   `json:"recurring"`
+	Email       string `json:"email"`
+	DisplayName string `json:"display_name"`
+	Anonymous   bool   `json:"anonymous"`
 }
 
 type PaymentSuccessData struct {
 	CheckoutID string
 	UserID     string
 	CampaignID string
-	Amount     int64
+	Amount     string
 	Currency   string
 	Status     string
+	Recorded   bool
 }
 
 func CreatePaymentIntentHandler(c *gin.Context) {
@@ -42,49 +56,83 @@ func CreatePaymentIntentHandler(c *gin.Context) {
 		return
 	}
 
-	raw, _ := c.Get("user_id")
-	userID := raw.(gocql.UUID)
+	metadata := map[string]string{
+		"campaign_id": req.CampaignID,
+		"donor_name":  strings.TrimSpace(req.DisplayName),
+		"anonymous":   strconv.FormatBool(req.Anonymous),
+	}
 
-	user, _ := userService.GetUserByID(context.Background(), userID)
-	if user.ID == (gocql.UUID{}) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+	if raw, ok := c.Get("user_id"); ok {
+		user, _ := userService.GetUserByID(context.Background(), raw.(gocql.UUID))
+		if user.ID == (gocql.UUID{}) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			return
+		}
+		metadata["user_id"] = user.ID.String()
+	} else {
+		if req.Recurring {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "sign in to set up a monthly donation"})
+			return
+		}
+		addr, err := mail.ParseAddress(req.Email)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a valid email is required to donate as a guest"})
+			return
+		}
+		metadata["donor_email"] = payment.NormalizeEmail(addr.Address)
+	}
+
+	campaignID, err := gocql.ParseUUID(req.CampaignID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid campaign_id"})
+		return
+	}
+	target, err := campaignService.GetCampaignByID(c, campaignID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "campaign not found"})
+		return
+	}
+	if target.Status != campaignModels.StatusActive {
+		c.JSON(http.StatusConflict, gin.H{"error": "campaign is " + target.Status + " and not accepting donations"})
 		return
 	}
 
+	if req.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount must be positive"})
+		return
+	}
 	if req.Currency == "" {
-		req.Currency = "usd"
+		req.Currency = target.Currency
+	}
+	code, ok := currency.Normalize(req.Currency)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid currency"})
+		return
+	}
+	if !currency.Rates().CanConvert(code, target.Currency) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "campaign only accepts " + target.Currency})
+		return
 	}
 
-	stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
 	host := os.Getenv("APP_HOST")
 
 	successURL := host + "/payment/success" + "?session_id={CHECKOUT_SESSION_ID}"
 	failURL := host + "/payment/fail" + "?session_id={CHECKOUT_SESSION_ID}"
 
-	params := &stripe.CheckoutSessionParams{
-		PaymentMethodTypes: stripe.StringSlice([]string{"card"}),
-		LineItems: []*stripe.CheckoutSessionLineItemParams{
-			{
-				PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
-					Currency: stripe.String(req.Currency),
-					ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
-						Name: stripe.String("Donation for campaign " + req.CampaignID),
-					},
-					UnitAmount: stripe.Int64(req.Amount * 100),
-				},
-				Quantity: stripe.Int64(1),
-			},
-		},
-		Mode:       stripe.String(string(stripe.CheckoutSessionModePayment)),
-		SuccessURL: stripe.String(successURL),
-		CancelURL:  stripe.String(failURL),
-	}
-	params.Metadata = map[string]string{
-		"user_id":     user.ID.String(),
-		"campaign_id": req.CampaignID,
+	description := "Donation for campaign " + req.CampaignID
+	if req.Recurring {
+		description = "Monthly donation for campaign " + req.CampaignID
 	}
 
-	s, err := session.New(params)
+	s, err := providers.Default().CreateCheckout(c, providers.CheckoutParams{
+		Amount:      req.Amount,
+		Currency:    code,
+		Description: description,
+		Recurring:   req.Recurring,
+		SuccessURL:  successURL,
+		CancelURL:   failURL,
+		Metadata:    metadata,
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -102,47 +150,30 @@ func PaymentSuccessHandler(c *gin.Context) {
 		return
 	}
 
-	stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
-
-	sess, err := session.Get(checkoutID, nil)
+	sess, err := providers.Default().GetSession(c, checkoutID)
 	if err != nil {
 		c.String(http.StatusInternalServerError, "failed to get checkout session")
 		return
 	}
 
-	isExist, err := paymentService.CheckoutExists(checkoutID)
-	if !isExist {
-		CampaignID, _ := gocql.ParseUUID(sess.Metadata["campaign_id"])
-		UserID, _ := gocql.ParseUUID(sess.Metadata["user_id"])
-		user, _ := userService.GetUserByID(context.Background(), UserID)
-		currentPayment := models.PaymentHistory{
-			ID:         gocql.TimeUUID(),
-			CampaignID: CampaignID,
-			Username:   user.Username,
-			UserID:     UserID,
-			CreatedAt:  time.Now(),
-			CheckoutID: checkoutID,
-			Amount:     sess.AmountTotal / 100,
-		}
-
-		if err := paymentService.NewPayment(context.Background(), currentPayment); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create currentPayment"})
-			return
-		}
-
-		if err := campaignService.UpdateCampaignAmountCollected(context.Background(), CampaignID, sess.AmountTotal/100); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
+	// The webhook is the only writer; this page just reports whether the
+	// donation has been recorded yet.
+	var recorded bool
+	if sess.SubscriptionID != "" {
+		_, err := recurringService.GetRecurringDonation(c, sess.SubscriptionID)
+		recorded = err == nil
+	} else {
+		recorded, _ = paymentService.CheckoutExists(checkoutID)
 	}
 
 	data := PaymentSuccessData{
 		CheckoutID: sess.ID,
 		UserID:     sess.Metadata["user_id"],
 		CampaignID: sess.Metadata["campaign_id"],
-		Amount:     sess.AmountTotal / 10,
-		Currency:   string(sess.Currency),
-		Status:     string(sess.PaymentStatus),
+		Amount:     currency.Format(sess.AmountTotal, sess.Currency),
+		Currency:   sess.Currency,
+		Status:     sess.PaymentStatus,
+		Recorded:   recorded,
 	}
 
 	c.HTML(http.StatusOK, "success.html", data)
@@ -155,9 +186,7 @@ func PaymentFailHandler(c *gin.Context) {
 		return
 	}
 
-	stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
-
-	sess, err := session.Get(checkoutID, nil)
+	sess, err := providers.Default().GetSession(c, checkoutID)
 	if err != nil {
 		c.String(http.StatusInternalServerError, "failed to get checkout session")
 		return
@@ -167,9 +196,9 @@ func PaymentFailHandler(c *gin.Context) {
 		CheckoutID: checkoutID,
 		UserID:     sess.Metadata["user_id"],
 		CampaignID: sess.Metadata["campaign_id"],
-		Amount:     sess.AmountTotal,
-		Currency:   string(sess.Currency),
-		Status:     string(sess.PaymentStatus),
+		Amount:     currency.Format(sess.AmountTotal, sess.Currency),
+		Currency:   sess.Currency,
+		Status:     sess.PaymentStatus,
 	}
 
 	c.HTML(http.StatusOK, "fail.html", data)
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"errors"
+	"go-fundraising/payment/models"
+	"go-fundraising/payment/providers"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocql/gocql"
+)
+
+func GetRecurringDonationsHandler(c *gin.Context) {
+	raw, _ := c.Get("user_id")
+	userID := raw.(gocql.UUID)
+
+	donations, err := recurringService.GetRecurringDonationsByUserID(c, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch recurring donations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recurring_donations": donations})
+}
+
+func CancelRecurringDonationHandler(c *gin.Context) {
+	donation, err := recurringService.GetRecurringDonation(c, c.Param("subscription_id"))
+	if err != nil {
+		if errors.Is(err, gocql.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "recurring donation not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch recurring donation"})
+		}
+		return
+	}
+
+	raw, _ := c.Get("user_id")
+	if donation.UserID != raw.(gocql.UUID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the donor can cancel this recurring donation"})
+		return
+	}
+	if donation.Status != models.RecurringStatusActive {
+		c.JSON(http.StatusConflict, gin.H{"error": "recurring donation is already " + donation.Status})
+		return
+	}
+
+	if err := providers.Default().CancelSubscription(c, donation.SubscriptionID); err != nil {
+		log.Println("❌ Cancel subscription error:", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "payment provider rejected the cancellation"})
+		return
+	}
+
+	// The subscription deleted webhook marks it cancelled as well.
+	if _, err := recurringService.CancelRecurringDonation(c, donation.SubscriptionID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel recurring donation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Recurring donation cancelled"})
+}
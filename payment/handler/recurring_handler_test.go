@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	campaignModels "go-fundraising/campaign/models"
+	"go-fundraising/db/dbtest"
+	"go-fundraising/payment/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newRecurringRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/payment/fake/subscriptions/:subscription_id/advance", FakeAdvanceBillingHandler)
+	r.DELETE("/payment/recurring/:subscription_id", func(c *gin.Context) {
+		donation, err := recurringService.GetRecurringDonation(c, c.Param("subscription_id"))
+		if err == nil {
+			c.Set("user_id", donation.UserID)
+		}
+		CancelRecurringDonationHandler(c)
+	})
+	return r
+}
+
+func TestRecurringDonationAddsOnePaymentPerCycle(t *testing.T) {
+	dbtest.Setup(t, "payment")
+	ctx := context.Background()
+	r := newRecurringRouter()
+	campaign := newTestCampaign(t)
+
+	sess, events := payCheckout(t, campaign.ID, 1000, true)
+	deliver(t, events...)
+	assertCollected(t, campaign.ID, 1000, 1, 1)
+
+	advance := "/payment/fake/subscriptions/" + sess.SubscriptionID + "/advance"
+	for cycle := 2; cycle <= 3; cycle++ {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, advance, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("cycle %d: status %d: %s", cycle, w.Code, w.Body.String())
+		}
+		assertCollected(t, campaign.ID, int64(cycle)*1000, int64(cycle), cycle)
+	}
+
+	// A replayed invoice is the same cycle.
+	deliver(t, events...)
+	assertCollected(t, campaign.ID, 3000, 3, 3)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/payment/recurring/"+sess.SubscriptionID, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("cancel: status %d: %s", w.Code, w.Body.String())
+	}
+	donation, err := recurringService.GetRecurringDonation(ctx, sess.SubscriptionID)
+	if err != nil {
+		t.Fatalf("get recurring donation: %v", err)
+	}
+	if donation.Status != models.RecurringStatusCancelled {
+		t.Errorf("status = %q, want %q", donation.Status, models.RecurringStatusCancelled)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, advance, nil))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("advance after cancel: status %d: %s", w.Code, w.Body.String())
+	}
+	assertCollected(t, campaign.ID, 3000, 3, 3)
+}
+
+func TestInvoiceReplayAfterCampaignEnds(t *testing.T) {
+	dbtest.Setup(t, "payment")
+	ctx := context.Background()
+	campaign := newTestCampaign(t)
+	fake := fakeProvider(t)
+
+	sess, events := payCheckout(t, campaign.ID, 1000, true)
+	deliver(t, events...)
+
+	if _, err := campaignService.UpdateCampaignStatus(ctx, campaign, campaignModels.StatusEnded); err != nil {
+		t.Fatalf("end campaign: %v", err)
+	}
+
+	// The next cycle was already billed when the campaign ended: it is kept,
+	// and the subscription is cancelled.
+	_, invoice, err := fake.AdvanceBillingCycle(sess.SubscriptionID)
+	if err != nil {
+		t.Fatalf("advance billing: %v", err)
+	}
+	deliver(t, invoice)
+	assertCollected(t, campaign.ID, 2000, 2, 2)
+
+	// Redeliveries find the donation cancelled and leave the provider be.
+	deliver(t, invoice, invoice)
+	assertCollected(t, campaign.ID, 2000, 2, 2)
+
+	donation, err := recurringService.GetRecurringDonation(ctx, sess.SubscriptionID)
+	if err != nil {
+		t.Fatalf("get recurring donation: %v", err)
+	}
+	if donation.Status != models.RecurringStatusCancelled {
+		t.Errorf("status = %q, want %q", donation.Status, models.RecurringStatusCancelled)
+	}
+	if _, _, err := fake.AdvanceBillingCycle(sess.SubscriptionID); err == nil {
+		t.Error("the subscription is still billed")
+	}
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"errors"
+	"go-fundraising/auth/roles"
+	"go-fundraising/middleware"
+	"go-fundraising/payment/models"
+	"go-fundraising/payment/providers"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocql/gocql"
+)
+
+// RefundPaymentRequest takes Amount in minor units of the payment currency;
+// zero refunds whatever has not been refunded yet.
+type RefundPaymentRequest struct {
+	CheckoutID string `json:"checkout_id" binding:"required"`
+	Amount     int64  `json:"amount"`
+	Reason     string `json:"reason"`
+}
+
+func RefundPaymentHandler(c *gin.Context) {
+	var req RefundPaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	checkout, ok := loadRefundableCheckout(c, req.CheckoutID)
+	if !ok {
+		return
+	}
+
+	remaining := checkout.Amount - checkout.RefundedAmount
+	amount := req.Amount
+	if amount == 0 {
+		amount = remaining
+	}
+	if amount <= 0 || amount > remaining {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "refund amount must be between 1 and the remaining paid amount"})
+		return
+	}
+
+	raw, _ := c.Get("user_id")
+	userID := raw.(gocql.UUID)
+
+	refund, err := providers.Default().Refund(c, providers.RefundParams{
+		PaymentIntentID: checkout.PaymentIntentID,
+		Amount:          amount,
+		Metadata: map[string]string{
+			"checkout_id":  checkout.CheckoutID,
+			"requested_by": userID.String(),
+		},
+	})
+	if err != nil {
+		log.Println("❌ Refund error:", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "payment provider rejected the refund"})
+		return
+	}
+
+	// The provider's refund webhooks record the refund too and finish
+	// whatever this call leaves undone, but a refund first stored by a
+	// webhook carries no requester or reason.
+	recorded := true
+	if err := recordRefund(c, checkout, refund, userID, req.Reason); err != nil {
+		log.Println("❌ Failed to record refund:", refund.ID, err)
+		recorded = false
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Refund issued",
+		"refund":   refund,
+		"recorded": recorded,
+	})
+}
+
+func GetRefundsHandler(c *gin.Context) {
+	checkout, ok := loadRefundableCheckout(c, c.Query("checkout_id"))
+	if !ok {
+		return
+	}
+
+	refunds, err := paymentService.GetRefundsByCheckoutID(c, checkout.CheckoutID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch refunds"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"checkout_id":     checkout.CheckoutID,
+		"amount":          checkout.Amount,
+		"refunded_amount": checkout.RefundedAmount,
+		"currency":        checkout.Currency,
+		"refunds":         refunds,
+	})
+}
+
+// loadRefundableCheckout resolves a checkout and makes sure the authenticated
+// user owns the campaign it paid into or may refund any payment, writing the
+// error response otherwise.
+func loadRefundableCheckout(c *gin.Context, checkoutID string) (models.PaymentCheckout, bool) {
+	if checkoutID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "checkout_id is required"})
+		return models.PaymentCheckout{}, false
+	}
+
+	checkout, err := paymentService.GetCheckout(c, checkoutID)
+	if err != nil {
+		if errors.Is(err, gocql.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "payment not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payment"})
+		}
+		return models.PaymentCheckout{}, false
+	}
+
+	campaign, err := campaignService.GetCampaignByID(c, checkout.CampaignID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "campaign not found"})
+		return models.PaymentCheckout{}, false
+	}
+
+	raw, _ := c.Get("user_id")
+	if campaign.UserID != raw.(gocql.UUID) && !middleware.Can(c, roles.RefundAnyPayment) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the campaign owner can refund this payment"})
+		return models.PaymentCheckout{}, false
+	}
+
+	return checkout, true
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"go-fundraising/db/dbtest"
+	ledgerModels "go-fundraising/ledger/models"
+	"go-fundraising/payment/providers"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocql/gocql"
+)
+
+// refund issues a refund of amount minor units through RefundPaymentHandler
+// as the campaign owner and returns the provider's refund.
+func refund(t *testing.T, ownerID gocql.UUID, checkoutID string, amount int64) providers.Refund {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	body, _ := json.Marshal(RefundPaymentRequest{CheckoutID: checkoutID, Amount: amount})
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/payment/refund", strings.NewReader(string(body)))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("user_id", ownerID)
+
+	RefundPaymentHandler(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("refund: status %d: %s", w.Code, w.Body.String())
+	}
+
+	var res struct {
+		Refund   providers.Refund `json:"refund"`
+		Recorded bool             `json:"recorded"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("decode refund response: %v", err)
+	}
+	if !res.Recorded {
+		t.Fatal("refund was not recorded")
+	}
+	return res.Refund
+}
+
+// assertRefunded checks the refunded total of a checkout and that the campaign
+// total still agrees with the ledger.
+func assertRefunded(t *testing.T, campaignID gocql.UUID, checkoutID string, refunded int64) {
+	t.Helper()
+	ctx := context.Background()
+
+	checkout, err := paymentService.GetCheckout(ctx, checkoutID)
+	if err != nil {
+		t.Fatalf("get checkout: %v", err)
+	}
+	if checkout.RefundedAmount != refunded {
+		t.Errorf("refunded_amount = %d, want %d", checkout.RefundedAmount, refunded)
+	}
+
+	balance, err := ledgerService.GetBalance(ctx, ledgerModels.CampaignAccount(campaignID))
+	if err != nil {
+		t.Fatalf("get balance: %v", err)
+	}
+	totals, err := campaignService.GetCampaignTotals(ctx, campaignID)
+	if err != nil {
+		t.Fatalf("get totals: %v", err)
+	}
+	for _, total := range totals {
+		if balance[total.Currency] != total.AmountCollected {
+			t.Errorf("%s total %d disagrees with ledger balance %d", total.Currency, total.AmountCollected, balance[total.Currency])
+		}
+	}
+}
+
+func TestRefundStage(t *testing.T) {
+	order := []string{providers.RefundStatusPending, providers.RefundStatusSucceeded, providers.RefundStatusFailed}
+	for i := 1; i < len(order); i++ {
+		if refundStage(order[i]) <= refundStage(order[i-1]) {
+			t.Errorf("%s does not come after %s", order[i], order[i-1])
+		}
+	}
+	if refundStage(providers.RefundStatusCanceled) != refundStage(providers.RefundStatusFailed) {
+		t.Error("canceled and failed refunds should be equally final")
+	}
+}
+
+func TestRefundTakesMinorUnitsAndIsRecordedOnce(t *testing.T) {
+	dbtest.Setup(t, "payment")
+	campaign := newTestCampaign(t)
+
+	sess, events := payCheckout(t, campaign.ID, 2500, false)
+	deliver(t, events...)
+
+	issued := refund(t, campaign.UserID, sess.ID, 1050)
+	if issued.Amount != 1050 {
+		t.Fatalf("refunded %d, want 1050 minor units", issued.Amount)
+	}
+	assertCollected(t, campaign.ID, 1450, 1, 1)
+	assertRefunded(t, campaign.ID, sess.ID, 1050)
+
+	// The charge.refunded webhook reports the same refund again.
+	payload, header, err := fakeProvider(t).RefundedEvent(sess.PaymentIntentID)
+	if err != nil {
+		t.Fatalf("refunded event: %v", err)
+	}
+	deliver(t, providers.SignedEvent{Payload: payload, Header: header})
+	assertCollected(t, campaign.ID, 1450, 1, 1)
+	assertRefunded(t, campaign.ID, sess.ID, 1050)
+}
+
+func TestPendingRefundCountsOnceSucceeded(t *testing.T) {
+	dbtest.Setup(t, "payment")
+	fake := fakeProvider(t)
+	fake.PendingRefunds = true
+	defer func() { fake.PendingRefunds = false }()
+
+	campaign := newTestCampaign(t)
+	sess, events := payCheckout(t, campaign.ID, 2000, false)
+	deliver(t, events...)
+
+	issued := refund(t, campaign.UserID, sess.ID, 0)
+	assertCollected(t, campaign.ID, 2000, 1, 1)
+	assertRefunded(t, campaign.ID, sess.ID, 0)
+
+	settled, err := fake.SettleRefund(issued.ID, providers.RefundStatusSucceeded)
+	if err != nil {
+		t.Fatalf("settle refund: %v", err)
+	}
+	deliver(t, settled, settled)
+	assertCollected(t, campaign.ID, 0, 1, 1)
+	assertRefunded(t, campaign.ID, sess.ID, 2000)
+}
+
+func TestFailedRefundRestoresTotal(t *testing.T) {
+	dbtest.Setup(t, "payment")
+	fake := fakeProvider(t)
+	campaign := newTestCampaign(t)
+
+	sess, events := payCheckout(t, campaign.ID, 3000, false)
+	deliver(t, events...)
+
+	issued := refund(t, campaign.UserID, sess.ID, 1000)
+	assertCollected(t, campaign.ID, 2000, 1, 1)
+
+	failed, err := fake.SettleRefund(issued.ID, providers.RefundStatusFailed)
+	if err != nil {
+		t.Fatalf("settle refund: %v", err)
+	}
+	deliver(t, failed, failed)
+	assertCollected(t, campaign.ID, 3000, 1, 1)
+	assertRefunded(t, campaign.ID, sess.ID, 0)
+
+	// A late copy of the succeeded status must not take it off again.
+	issued.Status = providers.RefundStatusSucceeded
+	if err := recordRefundUpdate(context.Background(), issued); err != nil {
+		t.Fatalf("record refund update: %v", err)
+	}
+	assertCollected(t, campaign.ID, 3000, 1, 1)
+}
+
+func TestPendingRefundThatFailsIsNeverCounted(t *testing.T) {
+	dbtest.Setup(t, "payment")
+	fake := fakeProvider(t)
+	fake.PendingRefunds = true
+	defer func() { fake.PendingRefunds = false }()
+
+	campaign := newTestCampaign(t)
+	sess, events := payCheckout(t, campaign.ID, 1500, false)
+	deliver(t, events...)
+
+	issued := refund(t, campaign.UserID, sess.ID, 500)
+	canceled, err := fake.SettleRefund(issued.ID, providers.RefundStatusCanceled)
+	if err != nil {
+		t.Fatalf("settle refund: %v", err)
+	}
+	deliver(t, canceled)
+
+	assertCollected(t, campaign.ID, 1500, 1, 1)
+	assertRefunded(t, campaign.ID, sess.ID, 0)
+}
@@ -0,0 +1,498 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	campaignModels "go-fundraising/campaign/models"
+	"go-fundraising/payment/models"
+	"go-fundraising/payment/providers"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocql/gocql"
+)
+
+const maxWebhookBodyBytes = int64(65536)
+
+func PaymentWebhookHandler(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxWebhookBodyBytes)
+	payload, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read body"})
+		return
+	}
+
+	if err := processWebhook(c, payload, c.Request.Header); err != nil {
+		if errors.Is(err, errInvalidWebhook) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid signature"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process event"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"received": true})
+}
+
+// FakePayHandler stands in for the hosted checkout page when the fake
+// provider is selected: it pays the session, delivers the webhook in-process
+// and redirects to the success page like the real provider would.
+func FakePayHandler(c *gin.Context) {
+	fake, ok := providers.Default().(*providers.FakeProvider)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+
+	sess, events, err := fake.Pay(c.Query("session_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "checkout session not found"})
+		return
+	}
+
+	for _, event := range events {
+		if err := processWebhook(c, event.Payload, event.Header); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process event"})
+			return
+		}
+	}
+
+	c.Redirect(http.StatusSeeOther, sess.SuccessURL)
+}
+
+// FakeAdvanceBillingHandler bills the next month of a fake subscription and
+// delivers its invoice webhook, so recurring donations can be exercised
+// without waiting for the calendar.
+func FakeAdvanceBillingHandler(c *gin.Context) {
+	fake, ok := providers.Default().(*providers.FakeProvider)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+
+	invoice, event, err := fake.AdvanceBillingCycle(c.Param("subscription_id"))
+	if err != nil {
+		if errors.Is(err, providers.ErrSubscriptionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := processWebhook(c, event.Payload, event.Header); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process event"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invoice": invoice})
+}
+
+var (
+	errInvalidWebhook   = errors.New("invalid webhook")
+	errCampaignDeleting = errors.New("campaign is being deleted")
+)
+
+func processWebhook(ctx context.Context, payload []byte, header http.Header) error {
+	event, err := providers.Default().VerifyWebhook(payload, header)
+	if err != nil {
+		return errInvalidWebhook
+	}
+
+	if err := handlePaymentEvent(ctx, event); err != nil {
+		log.Printf("❌ Webhook %s (%s) failed: %v\n", event.ID, event.Type, err)
+		return err
+	}
+	return nil
+}
+
+func handlePaymentEvent(ctx context.Context, event providers.Event) error {
+	switch event.Type {
+	case providers.EventCheckoutCompleted, providers.EventAsyncPaymentSucceeded:
+		// Delayed payment methods complete the session as unpaid and settle
+		// later through async_payment_succeeded.
+		if event.Session == nil || event.Session.PaymentStatus == providers.PaymentStatusUnpaid {
+			return nil
+		}
+		if event.Session.SubscriptionID != "" {
+			return recordSubscription(ctx, event.Session.SubscriptionID, event.Session.Metadata, event.Session.AmountTotal, event.Session.Currency)
+		}
+		return recordCheckoutSession(ctx, event.Session)
+
+	case providers.EventAsyncPaymentFailed:
+		if event.Session != nil {
+			log.Println("⚠️ Async payment failed for checkout:", event.Session.ID)
+		}
+		return nil
+
+	case providers.EventChargeRefunded:
+		if event.Charge == nil {
+			return nil
+		}
+		return recordChargeRefund(ctx, event.Charge)
+
+	case providers.EventRefundUpdated:
+		if event.Refund == nil {
+			return nil
+		}
+		return recordRefundUpdate(ctx, *event.Refund)
+
+	case providers.EventInvoicePaid:
+		if event.Invoice == nil || event.Invoice.SubscriptionID == "" {
+			return nil
+		}
+		return recordInvoice(ctx, event.Invoice)
+
+	case providers.EventSubscriptionDeleted:
+		if event.Subscription == nil {
+			return nil
+		}
+		_, err := recurringService.CancelRecurringDonation(ctx, event.Subscription.ID)
+		return err
+	}
+
+	return nil
+}
+
+func recordCheckoutSession(ctx context.Context, sess *providers.CheckoutSession) error {
+	return recordPayment(ctx, sess.ID, sess.PaymentIntentID, sess.Metadata, sess.AmountTotal, sess.Currency)
+}
+
+// recordInvoice records one paid cycle of a subscription as a payment keyed
+// by the invoice ID. A campaign that stopped accepting donations since the
+// last cycle keeps this payment but gets no further ones.
+func recordInvoice(ctx context.Context, invoice *providers.Invoice) error {
+	// invoice.paid can arrive before checkout.session.completed.
+	if err := recordSubscription(ctx, invoice.SubscriptionID, invoice.Metadata, invoice.AmountPaid, invoice.Currency); err != nil {
+		return err
+	}
+
+	if err := recordPayment(ctx, invoice.ID, invoice.PaymentIntentID, invoice.Metadata, invoice.AmountPaid, invoice.Currency); err != nil {
+		return err
+	}
+
+	campaignID, _ := gocql.ParseUUID(invoice.Metadata["campaign_id"])
+	target, err := campaignService.GetCampaignByID(ctx, campaignID)
+	reason := "is " + target.Status
+	switch {
+	case errors.Is(err, gocql.ErrNotFound):
+		reason = "was deleted"
+	case err != nil, target.Status == campaignModels.StatusActive:
+		return nil
+	}
+
+	// Only the delivery that cancels the donation asks the provider, so
+	// replays and later invoices of the same subscription do not.
+	cancelled, err := recurringService.CancelRecurringDonation(ctx, invoice.SubscriptionID)
+	if err != nil || !cancelled {
+		return err
+	}
+	log.Printf("⚠️ Cancelling subscription %s: campaign %s %s\n", invoice.SubscriptionID, campaignID, reason)
+	err = providers.Default().CancelSubscription(ctx, invoice.SubscriptionID)
+	if err != nil && !errors.Is(err, providers.ErrSubscriptionNotFound) {
+		if _, reopenErr := recurringService.ReactivateRecurringDonation(ctx, invoice.SubscriptionID); reopenErr != nil {
+			log.Println("❌ Failed to reopen recurring donation:", invoice.SubscriptionID, reopenErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func recordSubscription(ctx context.Context, subscriptionID string, metadata map[string]string, amount int64, code string) error {
+	campaignID, err := gocql.ParseUUID(metadata["campaign_id"])
+	if err != nil {
+		return err
+	}
+	userID, _ := gocql.ParseUUID(metadata["user_id"])
+
+	_, err = recurringService.NewRecurringDonation(ctx, models.RecurringDonation{
+		SubscriptionID: subscriptionID,
+		UserID:         userID,
+		CampaignID:     campaignID,
+		Amount:         amount,
+		Currency:       code,
+		Status:         models.RecurringStatusActive,
+		CreatedAt:      time.Now(),
+	})
+	return err
+}
+
+// recordPayment records a donation of amount into the campaign named in
+// metadata, once per checkoutID.
+func recordPayment(ctx context.Context, checkoutID, paymentIntentID string, metadata map[string]string, amount int64, code string) error {
+	campaignID, err := gocql.ParseUUID(metadata["campaign_id"])
+	if err != nil {
+		return err
+	}
+
+	currentPayment := models.PaymentHistory{
+		ID:              gocql.TimeUUID(),
+		CampaignID:      campaignID,
+		CreatedAt:       time.Now(),
+		CheckoutID:      checkoutID,
+		PaymentIntentID: paymentIntentID,
+		Amount:          amount,
+		Currency:        code,
+		DonorEmail:      metadata["donor_email"],
+		DonorName:       metadata["donor_name"],
+		Anonymous:       metadata["anonymous"] == "true",
+	}
+
+	// Guest checkouts carry no user_id and are recorded with a nil UserID.
+	if userID, err := gocql.ParseUUID(metadata["user_id"]); err == nil {
+		user, _ := userService.GetUserByID(ctx, userID)
+		currentPayment.UserID = userID
+		currentPayment.Username = user.Username
+	}
+
+	// Every step up to the counter update can be repeated, so a delivery
+	// that fails part way is finished by the provider's retry. Concurrent
+	// deliveries are kept apart by StartCounting, and the checkout is only
+	// marked counted once the totals include it. If that last write fails
+	// the retry counts the payment twice, which the reconciler reports
+	// against the ledger.
+	if _, err := ledgerService.PostDonation(ctx, campaignID, checkoutID, amount, code); err != nil {
+		return err
+	}
+
+	recorded, err := paymentService.NewPayment(ctx, currentPayment)
+	if err != nil || !recorded {
+		return err
+	}
+
+	// DeleteCampaignHandler marks a campaign deleting before it looks for
+	// payments, and this one is stored by now, so either the deletion sees
+	// it and backs off or this sees the deletion. The retry finds out which.
+	// A campaign that is gone was deleted before this payment was stored, so
+	// the donation is given back.
+	target, err := campaignService.GetCampaignByID(ctx, campaignID)
+	switch {
+	case errors.Is(err, gocql.ErrNotFound):
+		return refundOrphanedPayment(ctx, campaignID, checkoutID, paymentIntentID, amount, code)
+	case err != nil:
+		return err
+	case target.Status == campaignModels.StatusDeleting:
+		return errCampaignDeleting
+	}
+
+	counting, err := paymentService.StartCounting(ctx, checkoutID)
+	if err != nil || !counting {
+		return err
+	}
+
+	if err := campaignService.UpdateCampaignAmountCollected(ctx, campaignID, code, amount, 1); err != nil {
+		return err
+	}
+	if err := paymentService.MarkCounted(ctx, checkoutID); err != nil {
+		return err
+	}
+
+	// Ending now rather than on the next run of the campaign ender keeps a
+	// campaign that reached its target out of active searches.
+	if _, err := campaignService.EndCampaignIfDue(ctx, campaignID); err != nil {
+		log.Println("⚠️ Failed to end campaign:", campaignID, err)
+	}
+	return nil
+}
+
+// refundOrphanedPayment gives back a payment to a campaign that was deleted
+// while the donor was paying. The checkout is voided so that it is never
+// counted and its refund webhooks leave the totals alone, the donation is
+// taken back off the campaign's ledger account, and the provider refunds
+// whatever has not been refunded yet. Every step can be repeated.
+func refundOrphanedPayment(ctx context.Context, campaignID gocql.UUID, checkoutID, paymentIntentID string, amount int64, code string) error {
+	log.Println("⚠️ Refunding payment for deleted campaign:", campaignID, checkoutID)
+
+	if err := paymentService.VoidCheckout(ctx, checkoutID); err != nil {
+		return err
+	}
+	if _, err := ledgerService.PostDonationReversal(ctx, campaignID, checkoutID, amount, code); err != nil {
+		return err
+	}
+
+	if paymentIntentID == "" {
+		log.Println("❌ Payment for deleted campaign has no payment intent, refund it by hand:", checkoutID)
+		return nil
+	}
+
+	refunds, err := providers.Default().ListRefunds(ctx, paymentIntentID)
+	if err != nil {
+		return err
+	}
+	for _, refund := range refunds {
+		if refund.Status != providers.RefundStatusFailed && refund.Status != providers.RefundStatusCanceled {
+			amount -= refund.Amount
+		}
+	}
+	if amount <= 0 {
+		return nil
+	}
+
+	_, err = providers.Default().Refund(ctx, providers.RefundParams{
+		PaymentIntentID: paymentIntentID,
+		Amount:          amount,
+		Metadata: map[string]string{
+			"checkout_id": checkoutID,
+			"reason":      "campaign deleted",
+		},
+	})
+	return err
+}
+
+func recordChargeRefund(ctx context.Context, charge *providers.ChargeRefund) error {
+	checkout, ok, err := refundedCheckout(ctx, charge.PaymentIntentID)
+	if err != nil || !ok {
+		return err
+	}
+
+	// Charges no longer embed their refunds by default, so ask the provider.
+	refunds := charge.Refunds
+	if len(refunds) == 0 {
+		refunds, err = providers.Default().ListRefunds(ctx, charge.PaymentIntentID)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, refund := range refunds {
+		if err := recordRefund(ctx, checkout, refund, gocql.UUID{}, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordRefundUpdate applies a refund's status change, which is how pending
+// refunds settle and how a refund that later fails is reported.
+func recordRefundUpdate(ctx context.Context, refund providers.Refund) error {
+	checkout, ok, err := refundedCheckout(ctx, refund.PaymentIntentID)
+	if err != nil || !ok {
+		return err
+	}
+	return recordRefund(ctx, checkout, refund, gocql.UUID{}, "")
+}
+
+// refundedCheckout finds the checkout a refund event is about. It returns
+// false for payments this service never recorded.
+func refundedCheckout(ctx context.Context, paymentIntentID string) (models.PaymentCheckout, bool, error) {
+	if paymentIntentID == "" {
+		return models.PaymentCheckout{}, false, nil
+	}
+
+	checkout, err := paymentService.GetCheckoutByPaymentIntent(ctx, paymentIntentID)
+	if err != nil {
+		if errors.Is(err, gocql.ErrNotFound) {
+			log.Println("⚠️ Refund for unknown payment intent:", paymentIntentID)
+			return models.PaymentCheckout{}, false, nil
+		}
+		return models.PaymentCheckout{}, false, err
+	}
+	return checkout, true, nil
+}
+
+// recordRefund stores refund against checkout and keeps the campaign total in
+// step with its status: a refund comes off the total once it has succeeded,
+// and goes back on if it fails after that. Both the refund API and the refund
+// webhooks call it, in any order and any number of times. Every step can be
+// repeated, and StartRefundCounting lets only one caller at a time move the
+// total, so a call that fails part way is finished by the next delivery.
+func recordRefund(ctx context.Context, checkout models.PaymentCheckout, refund providers.Refund, requestedBy gocql.UUID, reason string) error {
+	// A voided payment was never counted and its ledger entry was reversed
+	// when it was refunded.
+	if checkout.Voided {
+		return nil
+	}
+	if refund.Currency == "" {
+		refund.Currency = checkout.Currency
+	}
+
+	stored, err := paymentService.NewRefund(ctx, models.PaymentRefund{
+		RefundID:        refund.ID,
+		CheckoutID:      checkout.CheckoutID,
+		CampaignID:      checkout.CampaignID,
+		PaymentIntentID: checkout.PaymentIntentID,
+		Amount:          refund.Amount,
+		Currency:        refund.Currency,
+		RequestedBy:     requestedBy,
+		Reason:          reason,
+		Status:          refund.Status,
+		CreatedAt:       time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	for {
+		if refundStage(refund.Status) > refundStage(stored.Status) {
+			applied, err := paymentService.UpdateRefundStatus(ctx, refund.ID, stored.Status, refund.Status)
+			if err != nil {
+				return err
+			}
+			if !applied {
+				if stored, err = paymentService.GetRefund(ctx, refund.ID); err != nil {
+					return err
+				}
+				continue
+			}
+			stored.Status = refund.Status
+		}
+
+		counts := stored.Status == providers.RefundStatusSucceeded
+		if counts == stored.Counted {
+			return nil
+		}
+
+		started, err := paymentService.StartRefundCounting(ctx, refund.ID, stored.Counted)
+		if err != nil || !started {
+			return err
+		}
+		if err := countRefund(ctx, stored, counts); err != nil {
+			return err
+		}
+
+		// Another delivery may have moved the status on in the meantime
+		// and left the total to this one.
+		if stored, err = paymentService.GetRefund(ctx, refund.ID); err != nil {
+			return err
+		}
+	}
+}
+
+// countRefund takes refund off the campaign total when counts is set, or
+// puts it back on otherwise. If the final write fails the retry moves the
+// total twice, which the reconciler reports against the ledger.
+func countRefund(ctx context.Context, refund models.PaymentRefund, counts bool) error {
+	delta := refund.Amount
+	if counts {
+		delta = -delta
+		if _, err := ledgerService.PostRefund(ctx, refund.CampaignID, refund.RefundID, refund.Amount, refund.Currency); err != nil {
+			return err
+		}
+	} else {
+		if _, err := ledgerService.PostRefundReversal(ctx, refund.CampaignID, refund.RefundID, refund.Amount, refund.Currency); err != nil {
+			return err
+		}
+	}
+
+	if err := paymentService.SyncRefundedAmount(ctx, refund.CheckoutID); err != nil {
+		return err
+	}
+	if err := campaignService.UpdateCampaignAmountCollected(ctx, refund.CampaignID, refund.Currency, delta, 0); err != nil {
+		return err
+	}
+	return paymentService.SetRefundCounted(ctx, refund.RefundID, counts)
+}
+
+// refundStage orders refund statuses so that a late delivery of an older
+// status never overwrites a newer one.
+func refundStage(status string) int {
+	switch status {
+	case providers.RefundStatusSucceeded:
+		return 1
+	case providers.RefundStatusFailed, providers.RefundStatusCanceled:
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	campaignModels "go-fundraising/campaign/models"
+	"go-fundraising/db/dbtest"
+	"go-fundraising/payment/models"
+	"go-fundraising/payment/providers"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+func TestMain(m *testing.M) {
+	os.Setenv("PAYMENT_PROVIDER", "fake")
+	os.Setenv("FAKE_WEBHOOK_SECRET", "test_webhook_secret")
+	os.Exit(m.Run())
+}
+
+func fakeProvider(t *testing.T) *providers.FakeProvider {
+	t.Helper()
+	fake, ok := providers.Default().(*providers.FakeProvider)
+	if !ok {
+		t.Fatal("fake payment provider is not selected")
+	}
+	return fake
+}
+
+// newTestCampaign creates an active campaign in usd that ends far in the
+// future.
+func newTestCampaign(t *testing.T) campaignModels.Campaign {
+	t.Helper()
+	campaign, err := campaignService.CreateCampaign(context.Background(), campaignModels.Campaign{
+		ID:        gocql.TimeUUID(),
+		UserID:    gocql.TimeUUID(),
+		Username:  "organizer",
+		Title:     "Test campaign",
+		Target:    1000,
+		Currency:  "usd",
+		Category:  campaignModels.CategoryOther,
+		Status:    campaignModels.StatusActive,
+		Deadline:  time.Now().Add(30 * 24 * time.Hour),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("create campaign: %v", err)
+	}
+	return campaign
+}
+
+// payCheckout opens a guest checkout for amount and pays it, returning the
+// session and the signed webhooks the fake provider sent for it.
+func payCheckout(t *testing.T, campaignID gocql.UUID, amount int64, recurring bool) (providers.CheckoutSession, []providers.SignedEvent) {
+	t.Helper()
+	fake := fakeProvider(t)
+
+	metadata := map[string]string{"campaign_id": campaignID.String(), "anonymous": "false"}
+	if recurring {
+		metadata["user_id"] = gocql.TimeUUID().String()
+	} else {
+		metadata["donor_email"] = "guest@example.com"
+	}
+
+	sess, err := fake.CreateCheckout(context.Background(), providers.CheckoutParams{
+		Amount:     amount,
+		Currency:   "usd",
+		Recurring:  recurring,
+		SuccessURL: "http://localhost/payment/success?session_id={CHECKOUT_SESSION_ID}",
+		CancelURL:  "http://localhost/payment/fail?session_id={CHECKOUT_SESSION_ID}",
+		Metadata:   metadata,
+	})
+	if err != nil {
+		t.Fatalf("create checkout: %v", err)
+	}
+
+	paid, events, err := fake.Pay(sess.ID)
+	if err != nil {
+		t.Fatalf("pay checkout: %v", err)
+	}
+	return paid, events
+}
+
+func deliver(t *testing.T, events ...providers.SignedEvent) {
+	t.Helper()
+	for _, event := range events {
+		if err := processWebhook(context.Background(), event.Payload, event.Header); err != nil {
+			t.Fatalf("process webhook: %v", err)
+		}
+	}
+}
+
+// assertCollected checks the usd total, donation count and number of
+// payment_history rows of a campaign.
+func assertCollected(t *testing.T, campaignID gocql.UUID, amount int64, donations int64, rows int) {
+	t.Helper()
+	ctx := context.Background()
+
+	totals, err := campaignService.GetCampaignTotals(ctx, campaignID)
+	if err != nil {
+		t.Fatalf("get totals: %v", err)
+	}
+	var gotAmount, gotDonations int64
+	for _, total := range totals {
+		if total.Currency == "usd" {
+			gotAmount, gotDonations = total.AmountCollected, total.DonationCount
+		}
+	}
+	if gotAmount != amount || gotDonations != donations {
+		t.Errorf("totals = %d over %d donations, want %d over %d", gotAmount, gotDonations, amount, donations)
+	}
+
+	history, err := paymentService.GetPaymentsByCampaignID(ctx, campaignID)
+	if err != nil {
+		t.Fatalf("get payments: %v", err)
+	}
+	if len(history) != rows {
+		t.Errorf("payment_history has %d rows, want %d", len(history), rows)
+	}
+}
+
+func TestWebhookRejectsInvalidSignature(t *testing.T) {
+	_, events := payCheckout(t, gocql.TimeUUID(), 500, false)
+	event := events[0]
+
+	tampered := append([]byte(nil), event.Payload...)
+	tampered[len(tampered)-2] = ' '
+
+	cases := map[string]providers.SignedEvent{
+		"tampered payload": {Payload: tampered, Header: event.Header},
+		"missing header":   {Payload: event.Payload, Header: nil},
+	}
+	for name, c := range cases {
+		err := processWebhook(context.Background(), c.Payload, c.Header)
+		if !errors.Is(err, errInvalidWebhook) {
+			t.Errorf("%s: err = %v, want errInvalidWebhook", name, err)
+		}
+	}
+}
+
+func TestWebhookRecordsPaymentOnce(t *testing.T) {
+	dbtest.Setup(t, "payment")
+	campaign := newTestCampaign(t)
+
+	sess, events := payCheckout(t, campaign.ID, 2500, false)
+	deliver(t, events...)
+	assertCollected(t, campaign.ID, 2500, 1, 1)
+
+	// Providers deliver at least once; a replay must change nothing.
+	deliver(t, events...)
+	assertCollected(t, campaign.ID, 2500, 1, 1)
+
+	checkout, err := paymentService.GetCheckout(context.Background(), sess.ID)
+	if err != nil {
+		t.Fatalf("get checkout: %v", err)
+	}
+	if !checkout.Counted {
+		t.Error("checkout is not marked counted")
+	}
+}
+
+func TestWebhookFinishesUncountedCheckout(t *testing.T) {
+	dbtest.Setup(t, "payment")
+	ctx := context.Background()
+	campaign := newTestCampaign(t)
+
+	sess, events := payCheckout(t, campaign.ID, 1200, false)
+
+	// An earlier delivery claimed the checkout and then failed before the
+	// totals were updated.
+	first := models.PaymentHistory{
+		ID:              gocql.TimeUUID(),
+		CampaignID:      campaign.ID,
+		CreatedAt:       time.Now().Add(-time.Minute).Truncate(time.Millisecond),
+		CheckoutID:      sess.ID,
+		PaymentIntentID: sess.PaymentIntentID,
+		Amount:          1200,
+		Currency:        "usd",
+	}
+	if _, err := paymentService.NewPayment(ctx, first); err != nil {
+		t.Fatalf("claim checkout: %v", err)
+	}
+
+	deliver(t, events...)
+	assertCollected(t, campaign.ID, 1200, 1, 1)
+
+	history, err := paymentService.GetPaymentsByCampaignID(ctx, campaign.ID)
+	if err != nil {
+		t.Fatalf("get payments: %v", err)
+	}
+	if len(history) == 1 && history[0].ID != first.ID {
+		t.Errorf("payment id = %s, want the claimed %s", history[0].ID, first.ID)
+	}
+
+	deliver(t, events...)
+	assertCollected(t, campaign.ID, 1200, 1, 1)
+}
+
+func TestWebhookConcurrentDeliveriesCountOnce(t *testing.T) {
+	dbtest.Setup(t, "payment")
+	campaign := newTestCampaign(t)
+
+	_, events := payCheckout(t, campaign.ID, 900, false)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, event := range events {
+				if err := processWebhook(context.Background(), event.Payload, event.Header); err != nil {
+					t.Errorf("process webhook: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	assertCollected(t, campaign.ID, 900, 1, 1)
+}
+
+func TestWebhookWaitsForCampaignDeletion(t *testing.T) {
+	dbtest.Setup(t, "payment")
+	ctx := context.Background()
+	campaign := newTestCampaign(t)
+
+	_, events := payCheckout(t, campaign.ID, 700, false)
+
+	// The checkout was opened before the owner started deleting the
+	// campaign, and the deletion then finds the payment and backs off.
+	stored, err := campaignService.MarkCampaignDeleting(ctx, campaign.ID)
+	if err != nil {
+		t.Fatalf("mark deleting: %v", err)
+	}
+	for _, event := range events {
+		if err := processWebhook(ctx, event.Payload, event.Header); !errors.Is(err, errCampaignDeleting) {
+			t.Fatalf("process webhook: err = %v, want errCampaignDeleting", err)
+		}
+	}
+	hasPayments, err := paymentService.HasPayments(ctx, campaign.ID)
+	if err != nil || !hasPayments {
+		t.Fatalf("has payments = %v, %v, want the stored payment", hasPayments, err)
+	}
+	assertCollected(t, campaign.ID, 0, 0, 1)
+
+	if err := campaignService.RestoreCampaignStatus(ctx, stored); err != nil {
+		t.Fatalf("restore status: %v", err)
+	}
+	deliver(t, events...)
+	assertCollected(t, campaign.ID, 700, 1, 1)
+}
+
+func TestWebhookRefundsPaymentForDeletedCampaign(t *testing.T) {
+	dbtest.Setup(t, "payment")
+	ctx := context.Background()
+	campaign := newTestCampaign(t)
+
+	// The donor opened the checkout before the campaign was deleted and
+	// paid it afterwards.
+	sess, events := payCheckout(t, campaign.ID, 900, false)
+	if err := campaignService.DeleteCampaign(ctx, campaign.ID); err != nil {
+		t.Fatalf("delete campaign: %v", err)
+	}
+
+	deliver(t, events...)
+	deliver(t, events...)
+
+	checkout, err := paymentService.GetCheckout(ctx, sess.ID)
+	if err != nil {
+		t.Fatalf("get checkout: %v", err)
+	}
+	if !checkout.Voided || checkout.Counted {
+		t.Errorf("checkout voided = %v, counted = %v, want voided and uncounted", checkout.Voided, checkout.Counted)
+	}
+
+	balance, err := ledgerService.GetCampaignBalance(ctx, campaign.ID)
+	if err != nil {
+		t.Fatalf("get balance: %v", err)
+	}
+	if balance["usd"] != 0 {
+		t.Errorf("campaign balance = %d, want 0", balance["usd"])
+	}
+
+	fake := fakeProvider(t)
+	refunds, err := fake.ListRefunds(ctx, sess.PaymentIntentID)
+	if err != nil {
+		t.Fatalf("list refunds: %v", err)
+	}
+	if len(refunds) != 1 || refunds[0].Amount != 900 {
+		t.Fatalf("refunds = %+v, want one refund of 900", refunds)
+	}
+
+	// The provider's refund webhook leaves the voided payment alone.
+	settled, err := fake.SettleRefund(refunds[0].ID, providers.RefundStatusSucceeded)
+	if err != nil {
+		t.Fatalf("settle refund: %v", err)
+	}
+	deliver(t, settled)
+	if balance, err := ledgerService.GetCampaignBalance(ctx, campaign.ID); err != nil || balance["usd"] != 0 {
+		t.Errorf("campaign balance after refund webhook = %v, %v, want 0", balance, err)
+	}
+}
@@ -7,18 +7,134 @@ import (
 	"github.com/scylladb/gocqlx/table"
 )
 
+// PaymentHistory amounts are in minor units of Currency. Guest donations
+// have a nil UserID and are identified by DonorEmail until claimed.
 type PaymentHistory struct {
-	ID         gocql.UUID `db:"id"`
-	CampaignID gocql.UUID `db:"campaign_id"`
-	Username   string     `db:"username"`
-	UserID     gocql.UUID `db:"user_id"`
-	CreatedAt  time.Time  `db:"created_at"`
-	CheckoutID string     `db:"checkout_id"`
-	Amount     int64      `db:"amount"`
+	ID              gocql.UUID `db:"id"`
+	CampaignID      gocql.UUID `db:"campaign_id"`
+	Username        string     `db:"username"`
+	UserID          gocql.UUID `db:"user_id"`
+	CreatedAt       time.Time  `db:"created_at"`
+	CheckoutID      string     `db:"checkout_id"`
+	PaymentIntentID string     `db:"payment_intent_id"`
+	Amount          int64      `db:"amount"`
+	Currency        string     `db:"currency"`
+	DonorEmail      string     `db:"donor_email"`
+	DonorName       string     `db:"donor_name"`
+	Anonymous       bool       `db:"anonymous"`
 }
 
 var PaymentHistoryTable = table.Metadata{
 	Name:    "payment_history",
-	Columns: []string{"id", "campaign_id", "username", "user_id", "created_at", "checkout_id", "amount"},
+	Columns: []string{"id", "campaign_id", "username", "user_id", "created_at", "checkout_id", "payment_intent_id", "amount", "currency", "donor_email", "donor_name", "anonymous"},
 	PartKey: []string{"campaign_id"},
 }
+
+// IsGuest reports whether the payment was made without an account.
+func (p PaymentHistory) IsGuest() bool {
+	return p.UserID == (gocql.UUID{})
+}
+
+// Public returns the payment as campaign pages may show it: the donor email
+// is never included, and anonymous donations carry no identity at all.
+func (p PaymentHistory) Public() PaymentHistory {
+	p.DonorEmail = ""
+	if p.Anonymous {
+		p.UserID = gocql.UUID{}
+		p.Username = ""
+		p.DonorName = ""
+	}
+	return p
+}
+
+// GuestDonation indexes guest payments by donor email so an account that
+// later verifies the same address can claim them.
+type GuestDonation struct {
+	Email      string     `db:"email"`
+	CheckoutID string     `db:"checkout_id"`
+	PaymentID  gocql.UUID `db:"payment_id"`
+	CampaignID gocql.UUID `db:"campaign_id"`
+	CreatedAt  time.Time  `db:"created_at"`
+}
+
+var GuestDonationTable = table.Metadata{
+	Name:    "guest_donations",
+	Columns: []string{"email", "checkout_id", "payment_id", "campaign_id", "created_at"},
+	PartKey: []string{"email"},
+	SortKey: []string{"checkout_id"},
+}
+
+// PaymentCheckout is the idempotency record for a checkout session: a row is
+// claimed with IF NOT EXISTS before the matching payment_history row is
+// written, and Counted is set once the payment is in the campaign totals.
+// Voided is set instead when the campaign was deleted before that and the
+// payment is refunded. The table's counting column is a short-lived mark held while that happens
+// and is left out of the model.
+type PaymentCheckout struct {
+	CheckoutID      string     `db:"checkout_id"`
+	PaymentID       gocql.UUID `db:"payment_id"`
+	CampaignID      gocql.UUID `db:"campaign_id"`
+	UserID          gocql.UUID `db:"user_id"`
+	PaymentIntentID string     `db:"payment_intent_id"`
+	Amount          int64      `db:"amount"`
+	RefundedAmount  int64      `db:"refunded_amount"`
+	Currency        string     `db:"currency"`
+	CreatedAt       time.Time  `db:"created_at"`
+	Counted         bool       `db:"counted"`
+	Voided          bool       `db:"voided"`
+}
+
+var PaymentCheckoutTable = table.Metadata{
+	Name:    "payment_checkouts",
+	Columns: []string{"checkout_id", "payment_id", "campaign_id", "user_id", "payment_intent_id", "amount", "refunded_amount", "currency", "created_at", "counted", "voided"},
+	PartKey: []string{"checkout_id"},
+}
+
+// PaymentRefund is one refund issued against a checkout, claimed by the
+// provider's refund ID. Status is the latest provider status; only succeeded
+// refunds come off the campaign total, and Counted records whether this one
+// currently does. Like payment_checkouts, the table has a counting column
+// held while Counted changes.
+type PaymentRefund struct {
+	RefundID        string     `db:"refund_id"`
+	CheckoutID      string     `db:"checkout_id"`
+	CampaignID      gocql.UUID `db:"campaign_id"`
+	PaymentIntentID string     `db:"payment_intent_id"`
+	Amount          int64      `db:"amount"`
+	Currency        string     `db:"currency"`
+	RequestedBy     gocql.UUID `db:"requested_by"`
+	Reason          string     `db:"reason"`
+	Status          string     `db:"status"`
+	Counted         bool       `db:"counted"`
+	CreatedAt       time.Time  `db:"created_at"`
+}
+
+var PaymentRefundTable = table.Metadata{
+	Name:    "payment_refunds",
+	Columns: []string{"refund_id", "checkout_id", "campaign_id", "payment_intent_id", "amount", "currency", "requested_by", "reason", "status", "counted", "created_at"},
+	PartKey: []string{"refund_id"},
+}
+
+const (
+	RecurringStatusActive    = "active"
+	RecurringStatusCancelled = "cancelled"
+)
+
+// RecurringDonation is a donor's monthly pledge to a campaign, keyed by the
+// provider's subscription ID. Each paid cycle is its own payment_history row.
+type RecurringDonation struct {
+	SubscriptionID string     `db:"subscription_id"`
+	UserID         gocql.UUID `db:"user_id"`
+	CampaignID     gocql.UUID `db:"campaign_id"`
+	Amount         int64      `db:"amount"`
+	Currency       string     `db:"currency"`
+	Status         string     `db:"status"`
+	CreatedAt      time.Time  `db:"created_at"`
+	CancelledAt    time.Time  `db:"cancelled_at"`
+}
+
+var RecurringDonationTable = table.Metadata{
+	Name:    "recurring_donations",
+	Columns: []string{"subscription_id", "user_id", "campaign_id", "amount", "currency", "status", "created_at", "cancelled_at"},
+	PartKey: []string{"subscription_id"},
+}
@@ -0,0 +1,341 @@
+package providers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const fakeSignatureHeader = "Fake-Signature"
+
+var ErrSessionNotFound = errors.New("checkout session not found")
+
+// SignedEvent is a webhook delivery as the fake provider would send it.
+type SignedEvent struct {
+	Payload []byte
+	Header  http.Header
+}
+
+// FakeProvider is an in-process payment provider for development and tests.
+// Checkout URLs point back at this service and nothing leaves the process.
+// Refunds succeed at once unless PendingRefunds is set, in which case they
+// stay pending until SettleRefund.
+type FakeProvider struct {
+	PendingRefunds bool
+
+	mu            sync.Mutex
+	host          string
+	webhookSecret string
+	sessions      map[string]*fakeSession
+	charges       map[string]*fakeCharge
+	subscriptions map[string]*fakeSubscription
+	refunds       map[string][]Refund
+}
+
+type fakeSession struct {
+	CheckoutSession
+	recurring bool
+}
+
+// fakeCharge is a captured payment, keyed by its payment intent.
+type fakeCharge struct {
+	amount   int64
+	currency string
+}
+
+type fakeSubscription struct {
+	Subscription
+	amount   int64
+	currency string
+	metadata map[string]string
+}
+
+func NewFakeProvider(host, webhookSecret string) *FakeProvider {
+	if webhookSecret == "" {
+		webhookSecret = "fake_webhook_secret"
+	}
+	return &FakeProvider{
+		host:          host,
+		webhookSecret: webhookSecret,
+		sessions:      map[string]*fakeSession{},
+		charges:       map[string]*fakeCharge{},
+		subscriptions: map[string]*fakeSubscription{},
+		refunds:       map[string][]Refund{},
+	}
+}
+
+func (p *FakeProvider) CreateCheckout(ctx context.Context, params CheckoutParams) (CheckoutSession, error) {
+	id := fakeID("cs_fake_")
+	sess := &fakeSession{recurring: params.Recurring}
+	sess.CheckoutSession = CheckoutSession{
+		ID:            id,
+		URL:           p.host + "/payment/fake/pay?session_id=" + id,
+		AmountTotal:   params.Amount,
+		Currency:      params.Currency,
+		PaymentStatus: PaymentStatusUnpaid,
+		SuccessURL:    strings.ReplaceAll(params.SuccessURL, "{CHECKOUT_SESSION_ID}", id),
+		CancelURL:     strings.ReplaceAll(params.CancelURL, "{CHECKOUT_SESSION_ID}", id),
+		Metadata:      params.Metadata,
+	}
+	// Subscription sessions are paid through the subscription's invoices
+	// instead of a payment intent of their own.
+	if !params.Recurring {
+		sess.PaymentIntentID = fakeID("pi_fake_")
+	}
+
+	p.mu.Lock()
+	p.sessions[id] = sess
+	p.mu.Unlock()
+
+	return sess.CheckoutSession, nil
+}
+
+func (p *FakeProvider) GetSession(ctx context.Context, id string) (CheckoutSession, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sess, ok := p.sessions[id]
+	if !ok {
+		return CheckoutSession{}, ErrSessionNotFound
+	}
+	return sess.CheckoutSession, nil
+}
+
+func (p *FakeProvider) VerifyWebhook(payload []byte, header http.Header) (Event, error) {
+	expected := p.sign(payload)
+	if !hmac.Equal([]byte(expected), []byte(header.Get(fakeSignatureHeader))) {
+		return Event{}, errors.New("invalid webhook signature")
+	}
+
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return Event{}, err
+	}
+	return event, nil
+}
+
+func (p *FakeProvider) Refund(ctx context.Context, params RefundParams) (Refund, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	charge, ok := p.charges[params.PaymentIntentID]
+	if !ok {
+		return Refund{}, errors.New("payment intent has not been paid")
+	}
+
+	remaining := charge.amount - p.refundedLocked(params.PaymentIntentID)
+	amount := params.Amount
+	if amount == 0 {
+		amount = remaining
+	}
+	if amount <= 0 || amount > remaining {
+		return Refund{}, errors.New("refund amount exceeds remaining charge")
+	}
+
+	refund := Refund{
+		ID:              fakeID("re_fake_"),
+		PaymentIntentID: params.PaymentIntentID,
+		Amount:          amount,
+		Currency:        charge.currency,
+		Status:          RefundStatusSucceeded,
+	}
+	if p.PendingRefunds {
+		refund.Status = RefundStatusPending
+	}
+	p.refunds[params.PaymentIntentID] = append(p.refunds[params.PaymentIntentID], refund)
+
+	return refund, nil
+}
+
+func (p *FakeProvider) ListRefunds(ctx context.Context, paymentIntentID string) ([]Refund, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]Refund(nil), p.refunds[paymentIntentID]...), nil
+}
+
+func (p *FakeProvider) CancelSubscription(ctx context.Context, subscriptionID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sub, ok := p.subscriptions[subscriptionID]
+	if !ok {
+		return ErrSubscriptionNotFound
+	}
+	sub.Status = SubscriptionStatusCanceled
+	return nil
+}
+
+// Pay marks a checkout session as paid and returns the signed webhooks the
+// real provider would have sent: checkout.session.completed, followed by the
+// first invoice.paid when the session started a subscription.
+func (p *FakeProvider) Pay(sessionID string) (CheckoutSession, []SignedEvent, error) {
+	p.mu.Lock()
+	sess, ok := p.sessions[sessionID]
+	if !ok {
+		p.mu.Unlock()
+		return CheckoutSession{}, nil, ErrSessionNotFound
+	}
+
+	var invoice *Invoice
+	if sess.PaymentStatus != PaymentStatusPaid {
+		sess.PaymentStatus = PaymentStatusPaid
+		if !sess.recurring {
+			p.charges[sess.PaymentIntentID] = &fakeCharge{amount: sess.AmountTotal, currency: sess.Currency}
+		} else {
+			sub := &fakeSubscription{
+				Subscription: Subscription{ID: fakeID("sub_fake_"), Status: SubscriptionStatusActive},
+				amount:       sess.AmountTotal,
+				currency:     sess.Currency,
+				metadata:     sess.Metadata,
+			}
+			p.subscriptions[sub.ID] = sub
+			sess.SubscriptionID = sub.ID
+			invoice = p.invoiceLocked(sub)
+		}
+	}
+	paid := sess.CheckoutSession
+	p.mu.Unlock()
+
+	events := []Event{{ID: fakeID("evt_fake_"), Type: EventCheckoutCompleted, Session: &paid}}
+	if invoice != nil {
+		events = append(events, Event{ID: fakeID("evt_fake_"), Type: EventInvoicePaid, Invoice: invoice})
+	}
+
+	signed := make([]SignedEvent, 0, len(events))
+	for _, event := range events {
+		payload, header, err := p.signedEvent(event)
+		if err != nil {
+			return CheckoutSession{}, nil, err
+		}
+		signed = append(signed, SignedEvent{Payload: payload, Header: header})
+	}
+	return paid, signed, nil
+}
+
+// AdvanceBillingCycle bills an active subscription for its next month and
+// returns the signed invoice.paid webhook for it.
+func (p *FakeProvider) AdvanceBillingCycle(subscriptionID string) (Invoice, SignedEvent, error) {
+	p.mu.Lock()
+	sub, ok := p.subscriptions[subscriptionID]
+	if !ok {
+		p.mu.Unlock()
+		return Invoice{}, SignedEvent{}, ErrSubscriptionNotFound
+	}
+	if sub.Status != SubscriptionStatusActive {
+		p.mu.Unlock()
+		return Invoice{}, SignedEvent{}, errors.New("subscription is " + sub.Status)
+	}
+	invoice := p.invoiceLocked(sub)
+	p.mu.Unlock()
+
+	payload, header, err := p.signedEvent(Event{
+		ID:      fakeID("evt_fake_"),
+		Type:    EventInvoicePaid,
+		Invoice: invoice,
+	})
+	return *invoice, SignedEvent{Payload: payload, Header: header}, err
+}
+
+// RefundedEvent returns the signed charge.refunded webhook for the current
+// refund state of a payment intent.
+func (p *FakeProvider) RefundedEvent(paymentIntentID string) ([]byte, http.Header, error) {
+	p.mu.Lock()
+	charge := &ChargeRefund{
+		PaymentIntentID: paymentIntentID,
+		AmountRefunded:  p.refundedLocked(paymentIntentID),
+		Refunds:         append([]Refund(nil), p.refunds[paymentIntentID]...),
+	}
+	if paid, ok := p.charges[paymentIntentID]; ok {
+		charge.Currency = paid.currency
+	}
+	p.mu.Unlock()
+
+	return p.signedEvent(Event{
+		ID:     fakeID("evt_fake_"),
+		Type:   EventChargeRefunded,
+		Charge: charge,
+	})
+}
+
+// SettleRefund moves a refund to status and returns the signed
+// charge.refund.updated webhook for it.
+func (p *FakeProvider) SettleRefund(refundID, status string) (SignedEvent, error) {
+	p.mu.Lock()
+	var settled *Refund
+	for _, refunds := range p.refunds {
+		for i := range refunds {
+			if refunds[i].ID == refundID {
+				refunds[i].Status = status
+				settled = &refunds[i]
+			}
+		}
+	}
+	if settled == nil {
+		p.mu.Unlock()
+		return SignedEvent{}, errors.New("refund not found")
+	}
+	refund := *settled
+	p.mu.Unlock()
+
+	payload, header, err := p.signedEvent(Event{
+		ID:     fakeID("evt_fake_"),
+		Type:   EventRefundUpdated,
+		Refund: &refund,
+	})
+	return SignedEvent{Payload: payload, Header: header}, err
+}
+
+func (p *FakeProvider) signedEvent(event Event) ([]byte, http.Header, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header := http.Header{}
+	header.Set(fakeSignatureHeader, p.sign(payload))
+	return payload, header, nil
+}
+
+func (p *FakeProvider) sign(payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(p.webhookSecret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// invoiceLocked charges one billing cycle of sub. p.mu must be held.
+func (p *FakeProvider) invoiceLocked(sub *fakeSubscription) *Invoice {
+	invoice := &Invoice{
+		ID:              fakeID("in_fake_"),
+		SubscriptionID:  sub.ID,
+		PaymentIntentID: fakeID("pi_fake_"),
+		AmountPaid:      sub.amount,
+		Currency:        sub.currency,
+		Metadata:        sub.metadata,
+	}
+	p.charges[invoice.PaymentIntentID] = &fakeCharge{amount: sub.amount, currency: sub.currency}
+	return invoice
+}
+
+func (p *FakeProvider) refundedLocked(paymentIntentID string) int64 {
+	var total int64
+	for _, r := range p.refunds[paymentIntentID] {
+		if r.Status != RefundStatusFailed && r.Status != RefundStatusCanceled {
+			total += r.Amount
+		}
+	}
+	return total
+}
+
+func fakeID(prefix string) string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return prefix + hex.EncodeToString(b)
+}
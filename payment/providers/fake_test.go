@@ -0,0 +1,78 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func newRecurringSession(t *testing.T, p *FakeProvider) CheckoutSession {
+	t.Helper()
+	sess, err := p.CreateCheckout(context.Background(), CheckoutParams{
+		Amount:     1500,
+		Currency:   "eur",
+		Recurring:  true,
+		SuccessURL: "http://app.test/payment/success?session_id={CHECKOUT_SESSION_ID}",
+		Metadata:   map[string]string{"campaign_id": "c"},
+	})
+	if err != nil {
+		t.Fatalf("create checkout: %v", err)
+	}
+	paid, events, err := p.Pay(sess.ID)
+	if err != nil {
+		t.Fatalf("pay: %v", err)
+	}
+	if paid.SubscriptionID == "" || len(events) != 2 {
+		t.Fatalf("paid session = %+v with %d events, want a subscription and its first invoice", paid, len(events))
+	}
+	return paid
+}
+
+func TestAdvanceBillingCycleInvoicesEachMonth(t *testing.T) {
+	p := NewFakeProvider("http://app.test", "secret")
+	sess := newRecurringSession(t, p)
+
+	seen := map[string]bool{}
+	for cycle := 0; cycle < 3; cycle++ {
+		invoice, signed, err := p.AdvanceBillingCycle(sess.SubscriptionID)
+		if err != nil {
+			t.Fatalf("cycle %d: %v", cycle, err)
+		}
+		if seen[invoice.ID] || seen[invoice.PaymentIntentID] {
+			t.Fatalf("cycle %d reused invoice %s", cycle, invoice.ID)
+		}
+		seen[invoice.ID], seen[invoice.PaymentIntentID] = true, true
+		if invoice.AmountPaid != 1500 || invoice.Currency != "eur" || invoice.Metadata["campaign_id"] != "c" {
+			t.Errorf("cycle %d invoice = %+v", cycle, invoice)
+		}
+
+		event, err := p.VerifyWebhook(signed.Payload, signed.Header)
+		if err != nil {
+			t.Fatalf("cycle %d: verify webhook: %v", cycle, err)
+		}
+		if event.Type != EventInvoicePaid || event.Invoice == nil || event.Invoice.ID != invoice.ID {
+			t.Errorf("cycle %d event = %+v, want invoice.paid for %s", cycle, event, invoice.ID)
+		}
+	}
+}
+
+func TestAdvanceBillingCycleStopsOnceCancelled(t *testing.T) {
+	p := NewFakeProvider("http://app.test", "secret")
+	sess := newRecurringSession(t, p)
+
+	// Cancelling again, as a replayed webhook may, is not an error.
+	for i := 0; i < 2; i++ {
+		if err := p.CancelSubscription(context.Background(), sess.SubscriptionID); err != nil {
+			t.Fatalf("cancel %d: %v", i+1, err)
+		}
+	}
+	if _, _, err := p.AdvanceBillingCycle(sess.SubscriptionID); err == nil {
+		t.Error("a cancelled subscription was billed")
+	}
+	if _, _, err := p.AdvanceBillingCycle("sub_unknown"); !errors.Is(err, ErrSubscriptionNotFound) {
+		t.Errorf("unknown subscription: err = %v, want ErrSubscriptionNotFound", err)
+	}
+	if err := p.CancelSubscription(context.Background(), "sub_unknown"); !errors.Is(err, ErrSubscriptionNotFound) {
+		t.Errorf("cancel unknown subscription: err = %v, want ErrSubscriptionNotFound", err)
+	}
+}
@@ -0,0 +1,153 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"go-fundraising/configs"
+	"log"
+	"net/http"
+	"sync"
+)
+
+type EventType string
+
+const (
+	EventCheckoutCompleted     EventType = "checkout.session.completed"
+	EventAsyncPaymentSucceeded EventType = "checkout.session.async_payment_succeeded"
+	EventAsyncPaymentFailed    EventType = "checkout.session.async_payment_failed"
+	EventChargeRefunded        EventType = "charge.refunded"
+	EventRefundUpdated         EventType = "charge.refund.updated"
+	EventInvoicePaid           EventType = "invoice.paid"
+	EventSubscriptionDeleted   EventType = "customer.subscription.deleted"
+)
+
+const (
+	PaymentStatusPaid              = "paid"
+	PaymentStatusUnpaid            = "unpaid"
+	PaymentStatusNoPaymentRequired = "no_payment_required"
+)
+
+// CheckoutParams describes a one-off payment of Amount, or a monthly
+// subscription for Amount when Recurring is set.
+type CheckoutParams struct {
+	Amount      int64
+	Currency    string
+	Description string
+	SuccessURL  string
+	CancelURL   string
+	Metadata    map[string]string
+	Recurring   bool
+}
+
+type CheckoutSession struct {
+	ID              string            `json:"id"`
+	URL             string            `json:"url"`
+	PaymentIntentID string            `json:"payment_intent_id"`
+	SubscriptionID  string            `json:"subscription_id"`
+	AmountTotal     int64             `json:"amount_total"`
+	Currency        string            `json:"currency"`
+	PaymentStatus   string            `json:"payment_status"`
+	SuccessURL      string            `json:"success_url"`
+	CancelURL       string            `json:"cancel_url"`
+	Metadata        map[string]string `json:"metadata"`
+}
+
+type RefundParams struct {
+	PaymentIntentID string
+	Amount          int64
+	Metadata        map[string]string
+}
+
+const (
+	RefundStatusPending   = "pending"
+	RefundStatusSucceeded = "succeeded"
+	RefundStatusFailed    = "failed"
+	RefundStatusCanceled  = "canceled"
+)
+
+type Refund struct {
+	ID              string `json:"id"`
+	PaymentIntentID string `json:"payment_intent_id"`
+	Amount          int64  `json:"amount"`
+	Currency        string `json:"currency"`
+	Status          string `json:"status"`
+}
+
+// ChargeRefund describes the refund state of a charge after a refund event.
+// AmountRefunded is the cumulative amount refunded so far.
+type ChargeRefund struct {
+	PaymentIntentID string   `json:"payment_intent_id"`
+	AmountRefunded  int64    `json:"amount_refunded"`
+	Currency        string   `json:"currency"`
+	Refunds         []Refund `json:"refunds"`
+}
+
+// Invoice is one paid billing cycle of a subscription. Metadata carries the
+// metadata the subscription was created with.
+type Invoice struct {
+	ID              string            `json:"id"`
+	SubscriptionID  string            `json:"subscription_id"`
+	PaymentIntentID string            `json:"payment_intent_id"`
+	AmountPaid      int64             `json:"amount_paid"`
+	Currency        string            `json:"currency"`
+	Metadata        map[string]string `json:"metadata"`
+}
+
+const (
+	SubscriptionStatusActive   = "active"
+	SubscriptionStatusCanceled = "canceled"
+)
+
+type Subscription struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// Event is a verified webhook event translated out of the provider's own
+// format. Session is set for checkout events, Charge for charge.refunded,
+// Refund for refund status changes, Invoice and Subscription for
+// subscription events.
+type Event struct {
+	ID           string           `json:"id"`
+	Type         EventType        `json:"type"`
+	Session      *CheckoutSession `json:"session,omitempty"`
+	Charge       *ChargeRefund    `json:"charge,omitempty"`
+	Refund       *Refund          `json:"refund,omitempty"`
+	Invoice      *Invoice         `json:"invoice,omitempty"`
+	Subscription *Subscription    `json:"subscription,omitempty"`
+}
+
+// ErrSubscriptionNotFound is returned for a subscription the provider does
+// not know.
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+
+type PaymentProvider interface {
+	CreateCheckout(ctx context.Context, params CheckoutParams) (CheckoutSession, error)
+	GetSession(ctx context.Context, id string) (CheckoutSession, error)
+	VerifyWebhook(payload []byte, header http.Header) (Event, error)
+	Refund(ctx context.Context, params RefundParams) (Refund, error)
+	ListRefunds(ctx context.Context, paymentIntentID string) ([]Refund, error)
+	// CancelSubscription stops billing a subscription. Cancelling one that
+	// is already cancelled succeeds.
+	CancelSubscription(ctx context.Context, subscriptionID string) error
+}
+
+var (
+	defaultProvider PaymentProvider
+	defaultOnce     sync.Once
+)
+
+// Default returns the provider selected by PAYMENT_PROVIDER ("stripe" or
+// "fake"). It is resolved on first use so that .env has been loaded.
+func Default() PaymentProvider {
+	defaultOnce.Do(func() {
+		switch configs.GetEnv("PAYMENT_PROVIDER") {
+		case "fake":
+			log.Println("⚠️ Using fake payment provider")
+			defaultProvider = NewFakeProvider(configs.GetEnv("APP_HOST"), configs.GetEnv("FAKE_WEBHOOK_SECRET"))
+		default:
+			defaultProvider = NewStripeProvider(configs.GetEnv("STRIPE_SECRET_KEY"), configs.GetEnv("STRIPE_WEBHOOK_SECRET"))
+		}
+	})
+	return defaultProvider
+}
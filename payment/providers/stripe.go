@@ -0,0 +1,263 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/stripe/stripe-go/v74"
+	"github.com/stripe/stripe-go/v74/checkout/session"
+	"github.com/stripe/stripe-go/v74/refund"
+	"github.com/stripe/stripe-go/v74/subscription"
+	"github.com/stripe/stripe-go/v74/webhook"
+)
+
+type StripeProvider struct {
+	sessions      session.Client
+	refunds       refund.Client
+	subscriptions subscription.Client
+	webhookSecret string
+}
+
+func NewStripeProvider(secretKey, webhookSecret string) *StripeProvider {
+	backend := stripe.GetBackend(stripe.APIBackend)
+	return &StripeProvider{
+		sessions:      session.Client{B: backend, Key: secretKey},
+		refunds:       refund.Client{B: backend, Key: secretKey},
+		subscriptions: subscription.Client{B: backend, Key: secretKey},
+		webhookSecret: webhookSecret,
+	}
+}
+
+func (p *StripeProvider) CreateCheckout(ctx context.Context, params CheckoutParams) (CheckoutSession, error) {
+	sp := &stripe.CheckoutSessionParams{
+		PaymentMethodTypes: stripe.StringSlice([]string{"card"}),
+		LineItems: []*stripe.CheckoutSessionLineItemParams{
+			{
+				PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
+					Currency: stripe.String(params.Currency),
+					ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
+						Name: stripe.String(params.Description),
+					},
+					UnitAmount: stripe.Int64(params.Amount),
+				},
+				Quantity: stripe.Int64(1),
+			},
+		},
+		Mode:       stripe.String(string(stripe.CheckoutSessionModePayment)),
+		SuccessURL: stripe.String(params.SuccessURL),
+		CancelURL:  stripe.String(params.CancelURL),
+	}
+	sp.Context = ctx
+	sp.Metadata = params.Metadata
+
+	if params.Recurring {
+		sp.LineItems[0].PriceData.Recurring = &stripe.CheckoutSessionLineItemPriceDataRecurringParams{
+			Interval: stripe.String(string(stripe.PriceRecurringIntervalMonth)),
+		}
+		sp.Mode = stripe.String(string(stripe.CheckoutSessionModeSubscription))
+		sp.SubscriptionData = &stripe.CheckoutSessionSubscriptionDataParams{
+			Metadata: params.Metadata,
+		}
+	}
+
+	s, err := p.sessions.New(sp)
+	if err != nil {
+		return CheckoutSession{}, err
+	}
+	return fromStripeSession(s), nil
+}
+
+func (p *StripeProvider) GetSession(ctx context.Context, id string) (CheckoutSession, error) {
+	sp := &stripe.CheckoutSessionParams{}
+	sp.Context = ctx
+
+	s, err := p.sessions.Get(id, sp)
+	if err != nil {
+		return CheckoutSession{}, err
+	}
+	return fromStripeSession(s), nil
+}
+
+func (p *StripeProvider) VerifyWebhook(payload []byte, header http.Header) (Event, error) {
+	event, err := webhook.ConstructEventWithOptions(
+		payload,
+		header.Get("Stripe-Signature"),
+		p.webhookSecret,
+		webhook.ConstructEventOptions{IgnoreAPIVersionMismatch: true},
+	)
+	if err != nil {
+		return Event{}, err
+	}
+
+	result := Event{ID: event.ID, Type: EventType(event.Type)}
+
+	switch result.Type {
+	case EventCheckoutCompleted, EventAsyncPaymentSucceeded, EventAsyncPaymentFailed:
+		var s stripe.CheckoutSession
+		if err := json.Unmarshal(event.Data.Raw, &s); err != nil {
+			return Event{}, err
+		}
+		sess := fromStripeSession(&s)
+		result.Session = &sess
+
+	case EventChargeRefunded:
+		var charge stripe.Charge
+		if err := json.Unmarshal(event.Data.Raw, &charge); err != nil {
+			return Event{}, err
+		}
+		result.Charge = fromStripeCharge(&charge)
+
+	case EventRefundUpdated:
+		var r stripe.Refund
+		if err := json.Unmarshal(event.Data.Raw, &r); err != nil {
+			return Event{}, err
+		}
+		refund := fromStripeRefund(&r)
+		result.Refund = &refund
+
+	case EventInvoicePaid:
+		var inv stripe.Invoice
+		if err := json.Unmarshal(event.Data.Raw, &inv); err != nil {
+			return Event{}, err
+		}
+		result.Invoice = fromStripeInvoice(&inv)
+
+	case EventSubscriptionDeleted:
+		var sub stripe.Subscription
+		if err := json.Unmarshal(event.Data.Raw, &sub); err != nil {
+			return Event{}, err
+		}
+		result.Subscription = &Subscription{ID: sub.ID, Status: string(sub.Status)}
+	}
+
+	return result, nil
+}
+
+func (p *StripeProvider) Refund(ctx context.Context, params RefundParams) (Refund, error) {
+	rp := &stripe.RefundParams{
+		PaymentIntent: stripe.String(params.PaymentIntentID),
+	}
+	if params.Amount > 0 {
+		rp.Amount = stripe.Int64(params.Amount)
+	}
+	rp.Context = ctx
+	rp.Metadata = params.Metadata
+
+	r, err := p.refunds.New(rp)
+	if err != nil {
+		return Refund{}, err
+	}
+	return fromStripeRefund(r), nil
+}
+
+func (p *StripeProvider) ListRefunds(ctx context.Context, paymentIntentID string) ([]Refund, error) {
+	lp := &stripe.RefundListParams{PaymentIntent: stripe.String(paymentIntentID)}
+	lp.Context = ctx
+
+	var refunds []Refund
+	it := p.refunds.List(lp)
+	for it.Next() {
+		refunds = append(refunds, fromStripeRefund(it.Refund()))
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	return refunds, nil
+}
+
+func (p *StripeProvider) CancelSubscription(ctx context.Context, subscriptionID string) error {
+	cp := &stripe.SubscriptionCancelParams{}
+	cp.Context = ctx
+
+	_, err := p.subscriptions.Cancel(subscriptionID, cp)
+	if err == nil {
+		return nil
+	}
+
+	// Stripe refuses to cancel a subscription twice, so look at where it
+	// stands before reporting the failure.
+	gp := &stripe.SubscriptionParams{}
+	gp.Context = ctx
+	sub, getErr := p.subscriptions.Get(subscriptionID, gp)
+	var stripeErr *stripe.Error
+	switch {
+	case getErr == nil && sub.Status == stripe.SubscriptionStatusCanceled:
+		return nil
+	case errors.As(getErr, &stripeErr) && stripeErr.Code == stripe.ErrorCodeResourceMissing:
+		return ErrSubscriptionNotFound
+	}
+	return err
+}
+
+func fromStripeSession(s *stripe.CheckoutSession) CheckoutSession {
+	sess := CheckoutSession{
+		ID:            s.ID,
+		URL:           s.URL,
+		AmountTotal:   s.AmountTotal,
+		Currency:      string(s.Currency),
+		PaymentStatus: string(s.PaymentStatus),
+		SuccessURL:    s.SuccessURL,
+		CancelURL:     s.CancelURL,
+		Metadata:      s.Metadata,
+	}
+	if s.PaymentIntent != nil {
+		sess.PaymentIntentID = s.PaymentIntent.ID
+	}
+	if s.Subscription != nil {
+		sess.SubscriptionID = s.Subscription.ID
+	}
+	return sess
+}
+
+func fromStripeInvoice(i *stripe.Invoice) *Invoice {
+	inv := &Invoice{
+		ID:         i.ID,
+		AmountPaid: i.AmountPaid,
+		Currency:   string(i.Currency),
+	}
+	if i.Subscription != nil {
+		inv.SubscriptionID = i.Subscription.ID
+	}
+	if i.PaymentIntent != nil {
+		inv.PaymentIntentID = i.PaymentIntent.ID
+	}
+	if i.SubscriptionDetails != nil {
+		inv.Metadata = i.SubscriptionDetails.Metadata
+	}
+	return inv
+}
+
+func fromStripeCharge(c *stripe.Charge) *ChargeRefund {
+	charge := &ChargeRefund{
+		AmountRefunded: c.AmountRefunded,
+		Currency:       string(c.Currency),
+	}
+	if c.PaymentIntent != nil {
+		charge.PaymentIntentID = c.PaymentIntent.ID
+	}
+	if c.Refunds != nil {
+		for _, r := range c.Refunds.Data {
+			refund := fromStripeRefund(r)
+			if refund.PaymentIntentID == "" {
+				refund.PaymentIntentID = charge.PaymentIntentID
+			}
+			charge.Refunds = append(charge.Refunds, refund)
+		}
+	}
+	return charge
+}
+
+func fromStripeRefund(r *stripe.Refund) Refund {
+	refund := Refund{
+		ID:       r.ID,
+		Amount:   r.Amount,
+		Currency: string(r.Currency),
+		Status:   string(r.Status),
+	}
+	if r.PaymentIntent != nil {
+		refund.PaymentIntentID = r.PaymentIntent.ID
+	}
+	return refund
+}
@@ -3,6 +3,7 @@ package routes
 import (
 	"go-fundraising/middleware"
 	handlers "go-fundraising/payment/handler"
+	"go-fundraising/payment/providers"
 
 	"github.com/gin-gonic/gin"
 )
@@ -10,8 +11,18 @@ import (
 func InitPaymentRouter(route *gin.Engine) {
 	paymentGroup := route.Group("/payment")
 	{
-		paymentGroup.POST("/create", middleware.AuthMiddleware(), handlers.CreatePaymentIntentHandler)
+		paymentGroup.POST("/create", middleware.OptionalAuth(), handlers.CreatePaymentIntentHandler)
 		paymentGroup.GET("/success", handlers.PaymentSuccessHandler)
 		paymentGroup.GET("/fail", handlers.PaymentFailHandler)
+		paymentGroup.POST("/webhook", handlers.PaymentWebhookHandler)
+		paymentGroup.POST("/refund", middleware.AuthMiddleware(), handlers.RefundPaymentHandler)
+		paymentGroup.GET("/refunds", middleware.AuthMiddleware(), handlers.GetRefundsHandler)
+		paymentGroup.GET("/recurring", middleware.AuthMiddleware(), handlers.GetRecurringDonationsHandler)
+		paymentGroup.DELETE("/recurring/:subscription_id", middleware.AuthMiddleware(), handlers.CancelRecurringDonationHandler)
+
+		if _, ok := providers.Default().(*providers.FakeProvider); ok {
+			paymentGroup.GET("/fake/pay", handlers.FakePayHandler)
+			paymentGroup.POST("/fake/subscriptions/:subscription_id/advance", handlers.FakeAdvanceBillingHandler)
+		}
 	}
 }
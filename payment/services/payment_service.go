@@ -2,9 +2,12 @@ package services
 
 import (
 	"context"
+	"errors"
 	"go-fundraising/db"
 	"go-fundraising/payment/models"
-	"log"
+	"go-fundraising/payment/providers"
+	"strings"
+	"time"
 
 	"github.com/gocql/gocql"
 	"github.com/scylladb/gocqlx"
@@ -13,15 +16,292 @@ import (
 
 type PaymentService struct{}
 
-func (s *PaymentService) NewPayment(ctx context.Context, paymentHistory models.PaymentHistory) error {
+var ErrCheckoutCounted = errors.New("checkout is already counted")
+
+// NewPayment claims the checkout session and writes its payment_history row.
+// It returns false without writing anything once the checkout has been
+// counted, so replayed webhook deliveries are harmless. A claim left
+// uncounted by an earlier failed attempt is taken over instead: its history
+// row is rewritten under the original payment ID and the caller counts it
+// between StartCounting and MarkCounted.
+func (s *PaymentService) NewPayment(ctx context.Context, paymentHistory models.PaymentHistory) (bool, error) {
+	checkout := models.PaymentCheckout{
+		CheckoutID:      paymentHistory.CheckoutID,
+		PaymentID:       paymentHistory.ID,
+		CampaignID:      paymentHistory.CampaignID,
+		UserID:          paymentHistory.UserID,
+		PaymentIntentID: paymentHistory.PaymentIntentID,
+		Amount:          paymentHistory.Amount,
+		Currency:        paymentHistory.Currency,
+		CreatedAt:       paymentHistory.CreatedAt,
+	}
+
+	claimStmt, claimNames := qb.Insert(models.PaymentCheckoutTable.Name).
+		Columns(models.PaymentCheckoutTable.Columns...).
+		Unique().
+		ToCql()
+
+	claim := gocqlx.Query(db.ScyllaSession.Query(claimStmt).WithContext(ctx), claimNames).BindStruct(checkout)
+	applied, err := claim.MapScanCAS(map[string]interface{}{})
+	claim.Release()
+	if err != nil {
+		return false, err
+	}
+	if !applied {
+		existing, err := s.GetCheckout(ctx, paymentHistory.CheckoutID)
+		if err != nil {
+			return false, err
+		}
+		if existing.Counted {
+			return false, nil
+		}
+		// The history row is keyed by the claimed ID and time, so writing
+		// it again is an overwrite rather than a second donation.
+		paymentHistory.ID = existing.PaymentID
+		paymentHistory.CreatedAt = existing.CreatedAt
+	}
+
 	stmt, names := qb.Insert(models.PaymentHistoryTable.Name).
 		Columns(models.PaymentHistoryTable.Columns...).
 		ToCql()
 
-	return gocqlx.Query(db.ScyllaSession.Query(stmt).Consistency(gocql.One), names).
+	err = gocqlx.Query(db.ScyllaSession.Query(stmt).Consistency(gocql.One), names).
 		BindStruct(paymentHistory).
 		ExecRelease()
+	if err != nil {
+		return false, err
+	}
+
+	if paymentHistory.IsGuest() && paymentHistory.DonorEmail != "" {
+		if err := s.indexGuestDonation(ctx, paymentHistory); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// countingLease bounds how long a delivery that died half way through
+// counting a payment holds up the retry that finishes it.
+const countingLease = time.Minute
+
+// StartCounting marks a checkout as being added to the campaign totals. It
+// returns false when the checkout has already been counted or another
+// delivery is counting it right now. The mark expires after countingLease.
+func (s *PaymentService) StartCounting(ctx context.Context, checkoutID string) (bool, error) {
+	stmt, names := qb.Update(models.PaymentCheckoutTable.Name).
+		TTL(countingLease).
+		Set("counting").
+		Where(qb.Eq("checkout_id")).
+		If(qb.EqLit("counted", "false"), qb.EqLit("counting", "null")).
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).BindMap(map[string]interface{}{
+		"checkout_id": checkoutID,
+		"counting":    true,
+	})
+	applied, err := q.MapScanCAS(map[string]interface{}{})
+	q.Release()
+	return applied, err
+}
+
+// MarkCounted records that a checkout's payment has been added to the
+// campaign totals, after which NewPayment and StartCounting ignore it.
+func (s *PaymentService) MarkCounted(ctx context.Context, checkoutID string) error {
+	stmt, names := qb.Update(models.PaymentCheckoutTable.Name).
+		Set("counted").
+		SetLit("counting", "null").
+		Where(qb.Eq("checkout_id")).
+		ToCql()
+
+	return gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{
+			"checkout_id": checkoutID,
+			"counted":     true,
+		}).
+		ExecRelease()
+}
+
+// VoidCheckout marks a checkout that must never be counted, unless it is
+// counted or being counted already, in which case it returns
+// ErrCheckoutCounted.
+func (s *PaymentService) VoidCheckout(ctx context.Context, checkoutID string) error {
+	stmt, names := qb.Update(models.PaymentCheckoutTable.Name).
+		Set("voided").
+		Where(qb.Eq("checkout_id")).
+		If(qb.EqLit("counted", "false"), qb.EqLit("counting", "null")).
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).BindMap(map[string]interface{}{
+		"checkout_id": checkoutID,
+		"voided":      true,
+	})
+	applied, err := q.MapScanCAS(map[string]interface{}{})
+	q.Release()
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrCheckoutCounted
+	}
+	return nil
+}
+
+func (s *PaymentService) indexGuestDonation(ctx context.Context, paymentHistory models.PaymentHistory) error {
+	stmt, names := qb.Insert(models.GuestDonationTable.Name).
+		Columns(models.GuestDonationTable.Columns...).
+		ToCql()
+
+	return gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindStruct(models.GuestDonation{
+			Email:      NormalizeEmail(paymentHistory.DonorEmail),
+			CheckoutID: paymentHistory.CheckoutID,
+			PaymentID:  paymentHistory.ID,
+			CampaignID: paymentHistory.CampaignID,
+			CreatedAt:  paymentHistory.CreatedAt,
+		}).
+		ExecRelease()
+}
+
+// ClaimGuestDonations moves every guest donation made with email onto the
+// given account and returns how many were claimed. Callers must have verified
+// that the account owns email.
+func (s *PaymentService) ClaimGuestDonations(ctx context.Context, userID gocql.UUID, username, email string) (int, error) {
+	email = NormalizeEmail(email)
+
+	var guests []models.GuestDonation
+	stmt, names := qb.Select(models.GuestDonationTable.Name).
+		Where(qb.Eq("email")).
+		ToCql()
+
+	err := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"email": email}).
+		SelectRelease(&guests)
+	if err != nil {
+		return 0, err
+	}
+
+	historyStmt, historyNames := qb.Update(models.PaymentHistoryTable.Name).
+		Set("user_id", "username").
+		Where(qb.Eq("campaign_id"), qb.Eq("created_at"), qb.Eq("id")).
+		ToCql()
+	checkoutStmt, checkoutNames := qb.Update(models.PaymentCheckoutTable.Name).
+		Set("user_id").
+		Where(qb.Eq("checkout_id")).
+		Existing().
+		ToCql()
+	deleteStmt, deleteNames := qb.Delete(models.GuestDonationTable.Name).
+		Where(qb.Eq("email"), qb.Eq("checkout_id")).
+		ToCql()
 
+	claimed := 0
+	for _, guest := range guests {
+		err := gocqlx.Query(db.ScyllaSession.Query(historyStmt).WithContext(ctx), historyNames).
+			BindMap(map[string]interface{}{
+				"user_id":     userID,
+				"username":    username,
+				"campaign_id": guest.CampaignID,
+				"created_at":  guest.CreatedAt,
+				"id":          guest.PaymentID,
+			}).
+			ExecRelease()
+		if err != nil {
+			return claimed, err
+		}
+
+		q := gocqlx.Query(db.ScyllaSession.Query(checkoutStmt).WithContext(ctx), checkoutNames).
+			BindMap(map[string]interface{}{
+				"user_id":     userID,
+				"checkout_id": guest.CheckoutID,
+			})
+		_, err = q.MapScanCAS(map[string]interface{}{})
+		q.Release()
+		if err != nil {
+			return claimed, err
+		}
+
+		err = gocqlx.Query(db.ScyllaSession.Query(deleteStmt).WithContext(ctx), deleteNames).
+			BindMap(map[string]interface{}{
+				"email":       email,
+				"checkout_id": guest.CheckoutID,
+			}).
+			ExecRelease()
+		if err != nil {
+			return claimed, err
+		}
+		claimed++
+	}
+
+	return claimed, nil
+}
+
+// NormalizeEmail is the form donor emails are matched in.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// AnonymizeDonor strips the donor's identity from their payments while
+// keeping the amounts, and returns how many payments it changed. Guest
+// donations made with email are included, so callers must only pass an
+// address the donor has verified.
+func (s *PaymentService) AnonymizeDonor(ctx context.Context, userID gocql.UUID, email, username string) (int, error) {
+	var payments []models.PaymentHistory
+
+	stmt, names := qb.Select(models.PaymentHistoryTable.Name).
+		Columns("campaign_id", "created_at", "id").
+		Where(qb.Eq("user_id")).
+		ToCql()
+	err := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"user_id": userID}).
+		SelectRelease(&payments)
+	if err != nil {
+		return 0, err
+	}
+
+	var guests []models.GuestDonation
+	if email != "" {
+		email = NormalizeEmail(email)
+		stmt, names := qb.Select(models.GuestDonationTable.Name).Where(qb.Eq("email")).ToCql()
+		err := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+			BindMap(map[string]interface{}{"email": email}).
+			SelectRelease(&guests)
+		if err != nil {
+			return 0, err
+		}
+		for _, guest := range guests {
+			payments = append(payments, models.PaymentHistory{
+				CampaignID: guest.CampaignID,
+				CreatedAt:  guest.CreatedAt,
+				ID:         guest.PaymentID,
+			})
+		}
+	}
+
+	stmt, names = qb.Update(models.PaymentHistoryTable.Name).
+		Set("username", "donor_email", "donor_name").
+		Where(qb.Eq("campaign_id"), qb.Eq("created_at"), qb.Eq("id")).
+		ToCql()
+	for i, payment := range payments {
+		payment.Username = username
+		err := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+			BindStruct(payment).
+			ExecRelease()
+		if err != nil {
+			return i, err
+		}
+	}
+
+	if len(guests) > 0 {
+		stmt, names := qb.Delete(models.GuestDonationTable.Name).Where(qb.Eq("email")).ToCql()
+		err := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+			BindMap(map[string]interface{}{"email": email}).
+			ExecRelease()
+		if err != nil {
+			return len(payments), err
+		}
+	}
+
+	return len(payments), nil
 }
 
 func (s *PaymentService) GetPaymentsByCampaignID(
@@ -49,10 +329,10 @@ func (s *PaymentService) GetPaymentsByCampaignID(
 	return results, nil
 }
 
-func (s *PaymentService) CheckoutExists(checkoutID string) (bool, error) {
+func (s *PaymentService) HasPayments(ctx context.Context, campaignID gocql.UUID) (bool, error) {
 	stmt, names := qb.Select(models.PaymentHistoryTable.Name).
 		Columns("id").
-		Where(qb.Eq("checkout_id")).
+		Where(qb.Eq("campaign_id")).
 		Limit(1).
 		ToCql()
 
@@ -60,15 +340,23 @@ func (s *PaymentService) CheckoutExists(checkoutID string) (bool, error) {
 		ID gocql.UUID `db:"id"`
 	}
 
-	q := gocqlx.Query(db.ScyllaSession.Query(stmt), names).
-		BindMap(map[string]interface{}{"checkout_id": checkoutID})
-
-	err := q.Get(&result)
+	err := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"campaign_id": campaignID}).
+		GetRelease(&result)
+	if err != nil {
+		if errors.Is(err, gocql.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
 
-	log.Println(result)
+	return true, nil
+}
 
+func (s *PaymentService) CheckoutExists(checkoutID string) (bool, error) {
+	_, err := s.GetCheckout(context.Background(), checkoutID)
 	if err != nil {
-		if err == gocql.ErrNotFound {
+		if errors.Is(err, gocql.ErrNotFound) {
 			return false, nil
 		}
 		return false, err
@@ -76,3 +364,197 @@ func (s *PaymentService) CheckoutExists(checkoutID string) (bool, error) {
 
 	return true, nil
 }
+
+func (s *PaymentService) GetCheckout(ctx context.Context, checkoutID string) (models.PaymentCheckout, error) {
+	var checkout models.PaymentCheckout
+
+	stmt, names := qb.Select(models.PaymentCheckoutTable.Name).
+		Columns(models.PaymentCheckoutTable.Columns...).
+		Where(qb.Eq("checkout_id")).
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"checkout_id": checkoutID})
+
+	if err := q.GetRelease(&checkout); err != nil {
+		return models.PaymentCheckout{}, err
+	}
+	return checkout, nil
+}
+
+func (s *PaymentService) GetCheckoutByPaymentIntent(ctx context.Context, paymentIntentID string) (models.PaymentCheckout, error) {
+	var checkout models.PaymentCheckout
+
+	stmt, names := qb.Select(models.PaymentCheckoutTable.Name).
+		Columns(models.PaymentCheckoutTable.Columns...).
+		Where(qb.Eq("payment_intent_id")).
+		Limit(1).
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"payment_intent_id": paymentIntentID})
+
+	if err := q.GetRelease(&checkout); err != nil {
+		return models.PaymentCheckout{}, err
+	}
+	return checkout, nil
+}
+
+// NewRefund records a refund once per provider refund ID and returns the
+// stored row, which is the earlier one when it had already been recorded.
+func (s *PaymentService) NewRefund(ctx context.Context, refund models.PaymentRefund) (models.PaymentRefund, error) {
+	stmt, names := qb.Insert(models.PaymentRefundTable.Name).
+		Columns(models.PaymentRefundTable.Columns...).
+		Unique().
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).BindStruct(refund)
+	applied, err := q.MapScanCAS(map[string]interface{}{})
+	q.Release()
+	if err != nil {
+		return models.PaymentRefund{}, err
+	}
+	if !applied {
+		return s.GetRefund(ctx, refund.RefundID)
+	}
+	return refund, nil
+}
+
+func (s *PaymentService) GetRefund(ctx context.Context, refundID string) (models.PaymentRefund, error) {
+	var refund models.PaymentRefund
+
+	stmt, names := qb.Select(models.PaymentRefundTable.Name).
+		Columns(models.PaymentRefundTable.Columns...).
+		Where(qb.Eq("refund_id")).
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"refund_id": refundID})
+
+	if err := q.GetRelease(&refund); err != nil {
+		return models.PaymentRefund{}, err
+	}
+	return refund, nil
+}
+
+// UpdateRefundStatus moves a refund from previous to status and returns false
+// when its status was no longer previous.
+func (s *PaymentService) UpdateRefundStatus(ctx context.Context, refundID, previous, status string) (bool, error) {
+	stmt, names := qb.Update(models.PaymentRefundTable.Name).
+		Set("status").
+		Where(qb.Eq("refund_id")).
+		If(qb.EqNamed("status", "previous")).
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).BindMap(map[string]interface{}{
+		"refund_id": refundID,
+		"status":    status,
+		"previous":  previous,
+	})
+	applied, err := q.MapScanCAS(map[string]interface{}{})
+	q.Release()
+	return applied, err
+}
+
+// StartRefundCounting marks a refund as having its counted state changed
+// from counted. It returns false when the refund is no longer in that state
+// or another caller is changing it right now. The mark expires after
+// countingLease.
+func (s *PaymentService) StartRefundCounting(ctx context.Context, refundID string, counted bool) (bool, error) {
+	stmt, names := qb.Update(models.PaymentRefundTable.Name).
+		TTL(countingLease).
+		Set("counting").
+		Where(qb.Eq("refund_id")).
+		If(qb.EqNamed("counted", "previous"), qb.EqLit("counting", "null")).
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).BindMap(map[string]interface{}{
+		"refund_id": refundID,
+		"counting":  true,
+		"previous":  counted,
+	})
+	applied, err := q.MapScanCAS(map[string]interface{}{})
+	q.Release()
+	return applied, err
+}
+
+// SetRefundCounted records whether a refund is currently taken off the
+// campaign totals and ends the change begun by StartRefundCounting.
+func (s *PaymentService) SetRefundCounted(ctx context.Context, refundID string, counted bool) error {
+	stmt, names := qb.Update(models.PaymentRefundTable.Name).
+		Set("counted").
+		SetLit("counting", "null").
+		Where(qb.Eq("refund_id")).
+		ToCql()
+
+	return gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{
+			"refund_id": refundID,
+			"counted":   counted,
+		}).
+		ExecRelease()
+}
+
+func (s *PaymentService) GetRefundsByCheckoutID(ctx context.Context, checkoutID string) ([]models.PaymentRefund, error) {
+	var refunds []models.PaymentRefund
+
+	stmt, names := qb.Select(models.PaymentRefundTable.Name).
+		Columns(models.PaymentRefundTable.Columns...).
+		Where(qb.Eq("checkout_id")).
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"checkout_id": checkoutID})
+
+	if err := q.SelectRelease(&refunds); err != nil {
+		return nil, err
+	}
+	return refunds, nil
+}
+
+// SyncRefundedAmount sets the refunded total of a checkout to the sum of its
+// succeeded refunds. It is recomputed rather than added to so that repeating
+// it is harmless; the compare-and-set on the total read before the refunds
+// makes a concurrent sync that saw fewer refunds lose to this one.
+func (s *PaymentService) SyncRefundedAmount(ctx context.Context, checkoutID string) error {
+	stmt, names := qb.Update(models.PaymentCheckoutTable.Name).
+		Set("refunded_amount").
+		Where(qb.Eq("checkout_id")).
+		If(qb.EqNamed("refunded_amount", "previous")).
+		ToCql()
+
+	for {
+		checkout, err := s.GetCheckout(ctx, checkoutID)
+		if err != nil {
+			return err
+		}
+		refunds, err := s.GetRefundsByCheckoutID(ctx, checkoutID)
+		if err != nil {
+			return err
+		}
+
+		var refunded int64
+		for _, refund := range refunds {
+			if refund.Status == providers.RefundStatusSucceeded {
+				refunded += refund.Amount
+			}
+		}
+		if refunded == checkout.RefundedAmount {
+			return nil
+		}
+
+		q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).BindMap(map[string]interface{}{
+			"checkout_id":     checkoutID,
+			"refunded_amount": refunded,
+			"previous":        checkout.RefundedAmount,
+		})
+		applied, err := q.MapScanCAS(map[string]interface{}{})
+		q.Release()
+		if err != nil {
+			return err
+		}
+		if applied {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"context"
+	"go-fundraising/db"
+	"go-fundraising/payment/models"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+)
+
+type RecurringService struct{}
+
+// NewRecurringDonation records a subscription once; both the checkout and the
+// first invoice webhook call it, in whichever order they arrive.
+func (s *RecurringService) NewRecurringDonation(ctx context.Context, donation models.RecurringDonation) (bool, error) {
+	stmt, names := qb.Insert(models.RecurringDonationTable.Name).
+		Columns(models.RecurringDonationTable.Columns...).
+		Unique().
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).BindStruct(donation)
+	applied, err := q.MapScanCAS(map[string]interface{}{})
+	q.Release()
+	if err != nil {
+		return false, err
+	}
+	return applied, nil
+}
+
+func (s *RecurringService) GetRecurringDonation(ctx context.Context, subscriptionID string) (models.RecurringDonation, error) {
+	var donation models.RecurringDonation
+
+	stmt, names := qb.Select(models.RecurringDonationTable.Name).
+		Where(qb.Eq("subscription_id")).
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"subscription_id": subscriptionID})
+
+	if err := q.GetRelease(&donation); err != nil {
+		return models.RecurringDonation{}, err
+	}
+	return donation, nil
+}
+
+func (s *RecurringService) GetRecurringDonationsByUserID(ctx context.Context, userID gocql.UUID) ([]models.RecurringDonation, error) {
+	var donations []models.RecurringDonation
+
+	stmt, names := qb.Select(models.RecurringDonationTable.Name).
+		Where(qb.Eq("user_id")).
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"user_id": userID})
+
+	if err := q.SelectRelease(&donations); err != nil {
+		return nil, err
+	}
+	return donations, nil
+}
+
+// CancelRecurringDonation marks an active subscription cancelled and returns
+// false when it was not active any more.
+func (s *RecurringService) CancelRecurringDonation(ctx context.Context, subscriptionID string) (bool, error) {
+	return s.setStatus(ctx, subscriptionID, models.RecurringStatusActive, models.RecurringStatusCancelled, time.Now())
+}
+
+// ReactivateRecurringDonation undoes CancelRecurringDonation when the provider
+// could not be made to stop billing, so that a retry cancels it again.
+func (s *RecurringService) ReactivateRecurringDonation(ctx context.Context, subscriptionID string) (bool, error) {
+	return s.setStatus(ctx, subscriptionID, models.RecurringStatusCancelled, models.RecurringStatusActive, nil)
+}
+
+func (s *RecurringService) setStatus(ctx context.Context, subscriptionID, previous, status string, cancelledAt interface{}) (bool, error) {
+	stmt, names := qb.Update(models.RecurringDonationTable.Name).
+		Set("status", "cancelled_at").
+		Where(qb.Eq("subscription_id")).
+		If(qb.EqNamed("status", "previous")).
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).BindMap(map[string]interface{}{
+		"subscription_id": subscriptionID,
+		"status":          status,
+		"cancelled_at":    cancelledAt,
+		"previous":        previous,
+	})
+	applied, err := q.MapScanCAS(map[string]interface{}{})
+	q.Release()
+	if err != nil {
+		return false, err
+	}
+	return applied, nil
+}
@@ -0,0 +1,272 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// Key is one signing key. Keys without a private half (or secret) can only
+// verify tokens, which is how a key is phased in or out during rotation.
+type Key struct {
+	ID        string
+	Algorithm string
+	secret    []byte
+	private   crypto.Signer
+	public    crypto.PublicKey
+}
+
+func (k Key) CanSign() bool {
+	return k.secret != nil || k.private != nil
+}
+
+func (k Key) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+func (k Key) signingKey() interface{} {
+	if k.secret != nil {
+		return k.secret
+	}
+	return k.private
+}
+
+func (k Key) verificationKey() interface{} {
+	if k.secret != nil {
+		return k.secret
+	}
+	return k.public
+}
+
+// KeySet is every key tokens may be verified with, plus the one new tokens
+// are signed with.
+type KeySet struct {
+	Active string
+	keys   map[string]Key
+}
+
+func (s KeySet) Key(id string) (Key, bool) {
+	k, ok := s.keys[id]
+	return k, ok
+}
+
+// keyFile is the JSON layout of JWT_KEYS_FILE. Key file paths are relative
+// to the directory of the JWT_KEYS_FILE itself.
+//
+//	{
+//	  "active": "2025-01",
+//	  "keys": [
+//	    {"kid": "2025-01", "alg": "RS256", "private_key_file": "2025-01.pem"},
+//	    {"kid": "2024-07", "alg": "HS256", "secret_file": "2024-07.secret"}
+//	  ]
+//	}
+type keyFile struct {
+	Active string `json:"active"`
+	Keys   []struct {
+		ID             string `json:"kid"`
+		Algorithm      string `json:"alg"`
+		SecretFile     string `json:"secret_file"`
+		PrivateKeyFile string `json:"private_key_file"`
+		PublicKeyFile  string `json:"public_key_file"`
+	} `json:"keys"`
+}
+
+// LoadKeys reads a key set from a JWT_KEYS_FILE.
+func LoadKeys(path string) (KeySet, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return KeySet{}, err
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return KeySet{}, err
+	}
+
+	dir := filepath.Dir(path)
+	resolve := func(name string) string {
+		if filepath.IsAbs(name) {
+			return name
+		}
+		return filepath.Join(dir, name)
+	}
+
+	set := KeySet{Active: file.Active, keys: map[string]Key{}}
+	for _, entry := range file.Keys {
+		if entry.ID == "" {
+			return KeySet{}, errors.New("key without kid")
+		}
+		if _, dup := set.keys[entry.ID]; dup {
+			return KeySet{}, fmt.Errorf("duplicate kid %q", entry.ID)
+		}
+
+		key := Key{ID: entry.ID, Algorithm: entry.Algorithm}
+		switch entry.Algorithm {
+		case AlgHS256:
+			if entry.SecretFile == "" {
+				return KeySet{}, fmt.Errorf("key %q: secret_file is required for %s", entry.ID, AlgHS256)
+			}
+			secret, err := os.ReadFile(resolve(entry.SecretFile))
+			if err != nil {
+				return KeySet{}, fmt.Errorf("key %q: %w", entry.ID, err)
+			}
+			key.secret = []byte(strings.TrimSpace(string(secret)))
+			if len(key.secret) == 0 {
+				return KeySet{}, fmt.Errorf("key %q: empty secret", entry.ID)
+			}
+
+		case AlgRS256, AlgEdDSA:
+			switch {
+			case entry.PrivateKeyFile != "":
+				key.private, err = readPrivateKey(resolve(entry.PrivateKeyFile))
+				if err == nil {
+					key.public = key.private.Public()
+				}
+			case entry.PublicKeyFile != "":
+				key.public, err = readPublicKey(resolve(entry.PublicKeyFile))
+			default:
+				err = errors.New("private_key_file or public_key_file is required")
+			}
+			if err != nil {
+				return KeySet{}, fmt.Errorf("key %q: %w", entry.ID, err)
+			}
+			if !matchesAlgorithm(key.public, entry.Algorithm) {
+				return KeySet{}, fmt.Errorf("key %q is not a %s key", entry.ID, entry.Algorithm)
+			}
+
+		default:
+			return KeySet{}, fmt.Errorf("key %q: unsupported alg %q", entry.ID, entry.Algorithm)
+		}
+
+		set.keys[key.ID] = key
+	}
+
+	active, ok := set.keys[set.Active]
+	if !ok {
+		return KeySet{}, fmt.Errorf("active key %q is not in the key set", set.Active)
+	}
+	if !active.CanSign() {
+		return KeySet{}, fmt.Errorf("active key %q has no private key", set.Active)
+	}
+	return set, nil
+}
+
+// secretKeySet is the single-key fallback used when no JWT_KEYS_FILE is set.
+func secretKeySet(secret string) KeySet {
+	return KeySet{
+		Active: defaultKeyID,
+		keys: map[string]Key{
+			defaultKeyID: {ID: defaultKeyID, Algorithm: AlgHS256, secret: []byte(secret)},
+		},
+	}
+}
+
+func readPrivateKey(path string) (crypto.Signer, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("unsupported private key type")
+		}
+		return signer, nil
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+func readPublicKey(path string) (crypto.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return x509.ParsePKCS1PublicKey(block.Bytes)
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	return block, nil
+}
+
+func matchesAlgorithm(public crypto.PublicKey, alg string) bool {
+	switch public.(type) {
+	case *rsa.PublicKey:
+		return alg == AlgRS256
+	case ed25519.PublicKey:
+		return alg == AlgEdDSA
+	}
+	return false
+}
+
+// JWK is a public key in JSON Web Key form.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	ID        string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS publishes the public half of every asymmetric key, including the
+// verify-only ones, so other services keep accepting tokens across a
+// rotation. Shared secrets are never published.
+func (s KeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range s.keys {
+		jwk := JWK{ID: key.ID, Use: "sig", Algorithm: key.Algorithm}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].ID < set.Keys[j].ID })
+	return set
+}
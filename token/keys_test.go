@@ -0,0 +1,183 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newKeyDir writes one key of each algorithm into a temporary directory:
+// hs.secret, and rs.pem, ed.pem with their public halves in rs.pub.pem and
+// ed.pub.pem.
+func newKeyDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+
+	writeFile(t, dir, "hs.secret", []byte("hs-secret\n"))
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate rsa key: %v", err)
+	}
+	writeKeyPair(t, dir, "rs", rsaKey, &rsaKey.PublicKey)
+
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate ed25519 key: %v", err)
+	}
+	writeKeyPair(t, dir, "ed", edPrivate, edPublic)
+
+	return dir
+}
+
+func writeKeyPair(t *testing.T, dir, name string, private, public interface{}) {
+	t.Helper()
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatalf("marshal private key: %v", err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatalf("marshal public key: %v", err)
+	}
+	writeFile(t, dir, name+".pem", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}))
+	writeFile(t, dir, name+".pub.pem", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+}
+
+func writeFile(t *testing.T, dir, name string, content []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+	return path
+}
+
+// allKeys lists every key in a newKeyDir as signing keys, with active
+// filled in.
+const allKeys = `{"active": %q, "keys": [
+	{"kid": "hs", "alg": "HS256", "secret_file": "hs.secret"},
+	{"kid": "rs", "alg": "RS256", "private_key_file": "rs.pem"},
+	{"kid": "ed", "alg": "EdDSA", "private_key_file": "ed.pem"}
+]}`
+
+func TestLoadKeys(t *testing.T) {
+	dir := newKeyDir(t)
+	path := writeFile(t, dir, "keys.json", []byte(`{"active": "rs", "keys": [
+		{"kid": "hs", "alg": "HS256", "secret_file": "hs.secret"},
+		{"kid": "rs", "alg": "RS256", "private_key_file": "rs.pem"},
+		{"kid": "ed", "alg": "EdDSA", "public_key_file": "ed.pub.pem"}
+	]}`))
+
+	set, err := LoadKeys(path)
+	if err != nil {
+		t.Fatalf("LoadKeys: %v", err)
+	}
+	if set.Active != "rs" {
+		t.Errorf("active = %q, want rs", set.Active)
+	}
+
+	tests := []struct {
+		kid     string
+		alg     string
+		canSign bool
+	}{
+		{"hs", AlgHS256, true},
+		{"rs", AlgRS256, true},
+		{"ed", AlgEdDSA, false},
+	}
+	for _, tt := range tests {
+		key, ok := set.Key(tt.kid)
+		if !ok {
+			t.Errorf("key %q missing", tt.kid)
+			continue
+		}
+		if key.Algorithm != tt.alg || key.CanSign() != tt.canSign {
+			t.Errorf("key %q = %s, can sign %v, want %s, %v", tt.kid, key.Algorithm, key.CanSign(), tt.alg, tt.canSign)
+		}
+	}
+	if key, _ := set.Key("hs"); string(key.secret) != "hs-secret" {
+		t.Errorf("secret = %q, want it trimmed", key.secret)
+	}
+}
+
+func TestLoadKeysRejectsMalformedFiles(t *testing.T) {
+	dir := newKeyDir(t)
+	writeFile(t, dir, "empty.secret", []byte(" \n"))
+	writeFile(t, dir, "not.pem", []byte("not a key"))
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"invalid json", `{"active": "hs", "keys": [`},
+		{"key without kid", `{"active": "hs", "keys": [{"alg": "HS256", "secret_file": "hs.secret"}]}`},
+		{"duplicate kid", `{"active": "hs", "keys": [
+			{"kid": "hs", "alg": "HS256", "secret_file": "hs.secret"},
+			{"kid": "hs", "alg": "HS256", "secret_file": "hs.secret"}
+		]}`},
+		{"unsupported alg", `{"active": "hs", "keys": [{"kid": "hs", "alg": "HS512", "secret_file": "hs.secret"}]}`},
+		{"hmac without secret file", `{"active": "hs", "keys": [{"kid": "hs", "alg": "HS256"}]}`},
+		{"missing secret file", `{"active": "hs", "keys": [{"kid": "hs", "alg": "HS256", "secret_file": "missing.secret"}]}`},
+		{"empty secret", `{"active": "hs", "keys": [{"kid": "hs", "alg": "HS256", "secret_file": "empty.secret"}]}`},
+		{"asymmetric without key file", `{"active": "rs", "keys": [{"kid": "rs", "alg": "RS256"}]}`},
+		{"not pem", `{"active": "rs", "keys": [{"kid": "rs", "alg": "RS256", "private_key_file": "not.pem"}]}`},
+		{"key of another alg", `{"active": "rs", "keys": [{"kid": "rs", "alg": "RS256", "private_key_file": "ed.pem"}]}`},
+		{"active not in set", `{"active": "gone", "keys": [{"kid": "hs", "alg": "HS256", "secret_file": "hs.secret"}]}`},
+		{"active verify-only", `{"active": "rs", "keys": [{"kid": "rs", "alg": "RS256", "public_key_file": "rs.pub.pem"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, dir, "keys.json", []byte(tt.content))
+			if _, err := LoadKeys(path); err == nil {
+				t.Error("LoadKeys succeeded, want an error")
+			}
+		})
+	}
+
+	if _, err := LoadKeys(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("LoadKeys of a missing file succeeded, want an error")
+	}
+}
+
+func TestJWKSLeavesOutHMACKeys(t *testing.T) {
+	dir := newKeyDir(t)
+	path := writeFile(t, dir, "keys.json", []byte(`{"active": "hs", "keys": [
+		{"kid": "hs", "alg": "HS256", "secret_file": "hs.secret"},
+		{"kid": "rs", "alg": "RS256", "public_key_file": "rs.pub.pem"},
+		{"kid": "ed", "alg": "EdDSA", "private_key_file": "ed.pem"}
+	]}`))
+	set, err := LoadKeys(path)
+	if err != nil {
+		t.Fatalf("LoadKeys: %v", err)
+	}
+
+	jwks := set.JWKS()
+	if len(jwks.Keys) != 2 || jwks.Keys[0].ID != "ed" || jwks.Keys[1].ID != "rs" {
+		t.Fatalf("jwks = %+v, want ed and rs only", jwks.Keys)
+	}
+
+	ed, rs := jwks.Keys[0], jwks.Keys[1]
+	edKey, _ := set.Key("ed")
+	if ed.KeyType != "OKP" || ed.Curve != "Ed25519" || ed.X != base64.RawURLEncoding.EncodeToString(edKey.public.(ed25519.PublicKey)) {
+		t.Errorf("ed jwk = %+v, want the OKP public key", ed)
+	}
+
+	rsKey, _ := set.Key("rs")
+	public := rsKey.public.(*rsa.PublicKey)
+	n, _ := base64.RawURLEncoding.DecodeString(rs.N)
+	e, _ := base64.RawURLEncoding.DecodeString(rs.E)
+	if rs.KeyType != "RSA" || new(big.Int).SetBytes(n).Cmp(public.N) != 0 || new(big.Int).SetBytes(e).Int64() != int64(public.E) {
+		t.Errorf("rs jwk = %+v, want the RSA public key", rs)
+	}
+	if rs.Use != "sig" || rs.Algorithm != AlgRS256 {
+		t.Errorf("rs jwk use %q alg %q, want sig RS256", rs.Use, rs.Algorithm)
+	}
+}
@@ -0,0 +1,127 @@
+// Package token issues and verifies the service's JWTs. Every token carries
+// the kid of the key that signed it, so keys can be rotated by adding the new
+// key, switching "active" to it and dropping the old one once its tokens
+// have expired.
+package token
+
+import (
+	"context"
+	"errors"
+	"go-fundraising/configs"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// defaultKeyID signs when no JWT_KEYS_FILE is configured. Tokens issued
+// before kids were introduced have no kid and verify against it.
+const defaultKeyID = "default"
+
+var (
+	ErrUnknownKey = errors.New("unknown signing key")
+
+	keys     atomic.Pointer[KeySet]
+	keysOnce sync.Once
+)
+
+// Keys returns the current key set, loading it on first use from
+// JWT_KEYS_FILE, or building an HS256 set from JWT_SECRET without one.
+func Keys() KeySet {
+	keysOnce.Do(func() {
+		if keys.Load() != nil {
+			return
+		}
+		set, err := loadConfiguredKeys()
+		if err != nil {
+			log.Fatalln("❌ Failed to load JWT keys:", err)
+		}
+		keys.Store(&set)
+	})
+	return *keys.Load()
+}
+
+func loadConfiguredKeys() (KeySet, error) {
+	if path := configs.GetEnv("JWT_KEYS_FILE"); path != "" {
+		return LoadKeys(path)
+	}
+
+	secret := configs.GetEnv("JWT_SECRET")
+	if secret == "" {
+		secret = configs.GetEnv("JWT_KEY")
+	}
+	if secret == "" {
+		return KeySet{}, errors.New("JWT_KEYS_FILE or JWT_SECRET must be set")
+	}
+	return secretKeySet(secret), nil
+}
+
+// Reload re-reads the configured keys. A broken file keeps the current set.
+func Reload() error {
+	set, err := loadConfiguredKeys()
+	if err != nil {
+		return err
+	}
+	keys.Store(&set)
+	return nil
+}
+
+// StartReloader reloads the key set every interval so a rotation only needs
+// the key file to change, not a restart. It stops when ctx is done.
+func StartReloader(ctx context.Context, interval time.Duration) {
+	Keys()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+
+			if err := Reload(); err != nil {
+				log.Println("⚠️ Failed to reload JWT keys:", err)
+			}
+		}
+	}()
+}
+
+// Issue signs claims with the active key.
+func Issue(claims jwt.MapClaims) (string, error) {
+	set := Keys()
+	key := set.keys[set.Active]
+
+	t := jwt.NewWithClaims(key.method(), claims)
+	t.Header["kid"] = key.ID
+	return t.SignedString(key.signingKey())
+}
+
+// Parse verifies tokenStr against the key named by its kid and returns its
+// claims. The token's alg must be the one configured for that key.
+func Parse(tokenStr string) (jwt.MapClaims, error) {
+	set := Keys()
+
+	t, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		if kid == "" {
+			kid = defaultKeyID
+		}
+		key, ok := set.Key(kid)
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		if t.Method.Alg() != key.Algorithm {
+			return nil, jwt.ErrTokenSignatureInvalid
+		}
+		return key.verificationKey(), nil
+	}, jwt.WithValidMethods([]string{AlgHS256, AlgRS256, AlgEdDSA}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+
+	return t.Claims.(jwt.MapClaims), nil
+}
@@ -0,0 +1,214 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// useKeys configures the key file at path and loads it. Later Reloads
+// pick up whatever the file then holds.
+func useKeys(t *testing.T, path string) {
+	t.Helper()
+	t.Setenv("JWT_KEYS_FILE", path)
+	if err := Reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+}
+
+// sign builds a token by hand, with a kid header unless kid is empty.
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		tok.Header["kid"] = kid
+	}
+	signed, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return signed
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{"sub": "user-1", "exp": time.Now().Add(time.Hour).Unix()}
+}
+
+func TestIssueParseRoundTrip(t *testing.T) {
+	dir := newKeyDir(t)
+
+	for _, kid := range []string{"hs", "rs", "ed"} {
+		t.Run(kid, func(t *testing.T) {
+			useKeys(t, writeFile(t, dir, "keys.json", []byte(fmt.Sprintf(allKeys, kid))))
+
+			signed, err := Issue(validClaims())
+			if err != nil {
+				t.Fatalf("issue: %v", err)
+			}
+
+			unverified, _, err := jwt.NewParser().ParseUnverified(signed, jwt.MapClaims{})
+			if err != nil {
+				t.Fatalf("parse unverified: %v", err)
+			}
+			key, _ := Keys().Key(kid)
+			if unverified.Header["kid"] != kid || unverified.Method.Alg() != key.Algorithm {
+				t.Errorf("header = %v, want kid %s and alg %s", unverified.Header, kid, key.Algorithm)
+			}
+
+			claims, err := Parse(signed)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if claims["sub"] != "user-1" {
+				t.Errorf("sub = %v, want user-1", claims["sub"])
+			}
+		})
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	dir := newKeyDir(t)
+	useKeys(t, writeFile(t, dir, "keys.json", []byte(fmt.Sprintf(allKeys, "hs"))))
+
+	secret := []byte("hs-secret")
+	rsPublic, err := os.ReadFile(filepath.Join(dir, "rs.pub.pem"))
+	if err != nil {
+		t.Fatalf("read public key: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{
+			// The RSA public key is no secret, so an HMAC token keyed
+			// with it must not pass as one signed by the RSA key.
+			name:  "alg that does not match the kid's key",
+			token: sign(t, jwt.SigningMethodHS256, "rs", rsPublic, validClaims()),
+			want:  jwt.ErrTokenSignatureInvalid,
+		},
+		{
+			name:  "unsigned token",
+			token: sign(t, jwt.SigningMethodNone, "hs", jwt.UnsafeAllowNoneSignatureType, validClaims()),
+			want:  jwt.ErrTokenSignatureInvalid,
+		},
+		{
+			name:  "unknown kid",
+			token: sign(t, jwt.SigningMethodHS256, "gone", secret, validClaims()),
+			want:  ErrUnknownKey,
+		},
+		{
+			// Without a "default" key in the set a legacy token has no
+			// key to verify against.
+			name:  "legacy token against a key file",
+			token: sign(t, jwt.SigningMethodHS256, "", secret, validClaims()),
+			want:  ErrUnknownKey,
+		},
+		{
+			name:  "wrong secret",
+			token: sign(t, jwt.SigningMethodHS256, "hs", []byte("other-secret"), validClaims()),
+			want:  jwt.ErrTokenSignatureInvalid,
+		},
+		{
+			name:  "missing exp",
+			token: sign(t, jwt.SigningMethodHS256, "hs", secret, jwt.MapClaims{"sub": "user-1"}),
+			want:  jwt.ErrTokenRequiredClaimMissing,
+		},
+		{
+			name:  "expired",
+			token: sign(t, jwt.SigningMethodHS256, "hs", secret, jwt.MapClaims{"sub": "user-1", "exp": time.Now().Add(-time.Minute).Unix()}),
+			want:  jwt.ErrTokenExpired,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.token); !errors.Is(err, tt.want) {
+				t.Errorf("Parse error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLegacyTokenWithoutKid(t *testing.T) {
+	t.Setenv("JWT_KEYS_FILE", "")
+	t.Setenv("JWT_SECRET", "legacy-secret")
+	if err := Reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+
+	legacy := sign(t, jwt.SigningMethodHS256, "", []byte("legacy-secret"), validClaims())
+	if _, err := Parse(legacy); err != nil {
+		t.Errorf("parse legacy token: %v", err)
+	}
+
+	issued, err := Issue(validClaims())
+	if err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+	unverified, _, _ := jwt.NewParser().ParseUnverified(issued, jwt.MapClaims{})
+	if unverified.Header["kid"] != defaultKeyID {
+		t.Errorf("kid = %v, want %s", unverified.Header["kid"], defaultKeyID)
+	}
+}
+
+func TestRotationKeepsOldTokensValid(t *testing.T) {
+	dir := newKeyDir(t)
+	path := writeFile(t, dir, "keys.json", []byte(`{"active": "rs", "keys": [
+		{"kid": "rs", "alg": "RS256", "private_key_file": "rs.pem"}
+	]}`))
+	useKeys(t, path)
+
+	old, err := Issue(validClaims())
+	if err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+
+	// The new key signs while the old one only verifies.
+	writeFile(t, dir, "keys.json", []byte(`{"active": "ed", "keys": [
+		{"kid": "ed", "alg": "EdDSA", "private_key_file": "ed.pem"},
+		{"kid": "rs", "alg": "RS256", "public_key_file": "rs.pub.pem"}
+	]}`))
+	if err := Reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if _, err := Parse(old); err != nil {
+		t.Errorf("parse old token after rotation: %v", err)
+	}
+	issued, err := Issue(validClaims())
+	if err != nil {
+		t.Fatalf("issue after rotation: %v", err)
+	}
+	unverified, _, _ := jwt.NewParser().ParseUnverified(issued, jwt.MapClaims{})
+	if unverified.Header["kid"] != "ed" {
+		t.Errorf("kid after rotation = %v, want ed", unverified.Header["kid"])
+	}
+
+	// A broken file keeps the keys in use.
+	writeFile(t, dir, "keys.json", []byte(`{"active": `))
+	if err := Reload(); err == nil {
+		t.Error("reload of a broken file succeeded, want an error")
+	}
+	if _, err := Parse(old); err != nil {
+		t.Errorf("parse old token after failed reload: %v", err)
+	}
+
+	// Once the old key is dropped its tokens stop verifying.
+	writeFile(t, dir, "keys.json", []byte(`{"active": "ed", "keys": [
+		{"kid": "ed", "alg": "EdDSA", "private_key_file": "ed.pem"}
+	]}`))
+	if err := Reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if _, err := Parse(old); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("parse after the old key was dropped: err = %v, want ErrUnknownKey", err)
+	}
+	if _, err := Parse(issued); err != nil {
+		t.Errorf("parse new token: %v", err)
+	}
+}
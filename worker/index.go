@@ -0,0 +1,55 @@
+package worker
+
+// IndexAlias is the name campaigns are indexed and searched under. It is an
+// alias for the versioned index last built by cmd/reindex, so the index can
+// be rebuilt with a new mapping and swapped in without downtime.
+const IndexAlias = "campaigns"
+
+// IndexAnalysis defines the analyzers IndexMapping refers to. Analyzers
+// can only be set when an index is created.
+//
+// autocomplete indexes every prefix of each word so titles can be matched
+// as they are typed; spell indexes word pairs and triples so corrections
+// can be judged by the phrases campaigns actually use.
+const IndexAnalysis = `{
+  "filter": {
+    "autocomplete_edge": {"type": "edge_ngram", "min_gram": 1, "max_gram": 20},
+    "spell_shingle":     {"type": "shingle", "min_shingle_size": 2, "max_shingle_size": 3}
+  },
+  "analyzer": {
+    "autocomplete":        {"type": "custom", "tokenizer": "standard", "filter": ["lowercase", "asciifolding", "autocomplete_edge"]},
+    "autocomplete_search": {"type": "custom", "tokenizer": "standard", "filter": ["lowercase", "asciifolding"]},
+    "spell":               {"type": "custom", "tokenizer": "standard", "filter": ["lowercase", "spell_shingle"]}
+  }
+}`
+
+// IndexMapping describes the fields of Document. Changing it takes a
+// reindex to apply to an existing index.
+const IndexMapping = `{
+  "properties": {
+    "id":               {"type": "keyword"},
+    "user_id":          {"type": "keyword"},
+    "username":         {"type": "keyword"},
+    "title": {
+      "type": "text",
+      "copy_to": "spell",
+      "fields": {
+        "autocomplete": {"type": "text", "analyzer": "autocomplete", "search_analyzer": "autocomplete_search"}
+      }
+    },
+    "description":      {"type": "text", "copy_to": "spell"},
+    "spell":            {"type": "text", "analyzer": "spell"},
+    "image":            {"type": "keyword", "index": false},
+    "category":         {"type": "keyword"},
+    "target":           {"type": "long"},
+    "currency":         {"type": "keyword"},
+    "amount_collected": {"type": "long"},
+    "percent_funded":   {"type": "double"},
+    "donation_count":   {"type": "integer"},
+    "recent_donations": {"type": "integer"},
+    "last_donation_at": {"type": "date"},
+    "status":           {"type": "keyword"},
+    "deadline":         {"type": "date"},
+    "created_at":       {"type": "date"}
+  }
+}`
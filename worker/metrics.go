@@ -0,0 +1,63 @@
+package worker
+
+import (
+	"expvar"
+	"go-fundraising/campaign/models"
+	"sync/atomic"
+	"time"
+)
+
+// Search sync metrics, published with expvar under "search_sync". Pending
+// and lag come from the latest scan of each shard, which stops once it has
+// found scanLimit due entries.
+var (
+	syncedTotal       = new(expvar.Int)
+	retriedTotal      = new(expvar.Int)
+	deadLetteredTotal = new(expvar.Int)
+
+	pendingByShard [models.SyncOutboxShards]atomic.Int64
+	// oldestByShard is the creation time in unix nanoseconds of the oldest
+	// entry in each shard, or zero when the shard is empty.
+	oldestByShard [models.SyncOutboxShards]atomic.Int64
+)
+
+func init() {
+	m := expvar.NewMap("search_sync")
+	m.Set("synced_total", syncedTotal)
+	m.Set("retried_total", retriedTotal)
+	m.Set("dead_lettered_total", deadLetteredTotal)
+	m.Set("pending", expvar.Func(func() any {
+		var total int64
+		for i := range pendingByShard {
+			total += pendingByShard[i].Load()
+		}
+		return total
+	}))
+	m.Set("lag_seconds", expvar.Func(func() any {
+		return Lag().Seconds()
+	}))
+}
+
+// Lag is the age of the oldest change not yet in the search index.
+func Lag() time.Duration {
+	var oldest int64
+	for i := range oldestByShard {
+		if t := oldestByShard[i].Load(); t != 0 && (oldest == 0 || t < oldest) {
+			oldest = t
+		}
+	}
+	if oldest == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, oldest))
+}
+
+func recordScan(shard int, scan shardScan) {
+	pendingByShard[shard].Store(int64(scan.pending))
+
+	var oldest int64
+	if !scan.oldest.IsZero() {
+		oldest = scan.oldest.UnixNano()
+	}
+	oldestByShard[shard].Store(oldest)
+}
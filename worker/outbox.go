@@ -0,0 +1,154 @@
+package worker
+
+import (
+	"context"
+	"go-fundraising/campaign/models"
+	"go-fundraising/db"
+	"hash/fnv"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+)
+
+// settleDelay holds back entries recorded ahead of a write that cannot share
+// a batch with them, so the write has landed before the entry is picked up.
+// Notify skips the wait once the write is known to have committed.
+const settleDelay = 30 * time.Second
+
+// BatchSync adds an outbox entry for campaignID to a logged batch that also
+// writes the campaign, so both are applied or neither is. Call Notify with
+// the entry once the batch has been executed.
+func BatchSync(batch *gocql.Batch, campaignID gocql.UUID, op string) models.SyncOutboxEntry {
+	entry := newEntry(campaignID, op, time.Now())
+
+	stmt, _ := qb.Insert(models.SyncOutboxTable.Name).
+		Columns("shard", "id", "campaign_id", "op", "attempts", "next_attempt_at", "created_at").
+		ToCql()
+	batch.Query(stmt, entry.Shard, entry.ID, entry.CampaignID, entry.Op, entry.Attempts, entry.NextAttemptAt, entry.CreatedAt)
+	return entry
+}
+
+// RecordSync writes an outbox entry ahead of a write that cannot be batched
+// with it, such as a lightweight transaction. If the process dies after the
+// write the entry is still there; if the write fails the entry only re-syncs
+// what is already indexed. Call Notify once the write has succeeded.
+func RecordSync(ctx context.Context, campaignID gocql.UUID, op string) (models.SyncOutboxEntry, error) {
+	now := time.Now()
+	entry := newEntry(campaignID, op, now.Add(settleDelay))
+	entry.CreatedAt = now
+
+	stmt, names := qb.Insert(models.SyncOutboxTable.Name).
+		Columns(models.SyncOutboxTable.Columns...).
+		ToCql()
+
+	err := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindStruct(entry).
+		ExecRelease()
+	return entry, err
+}
+
+func newEntry(campaignID gocql.UUID, op string, due time.Time) models.SyncOutboxEntry {
+	return models.SyncOutboxEntry{
+		Shard:         shardOf(campaignID),
+		ID:            gocql.TimeUUID(),
+		CampaignID:    campaignID,
+		Op:            op,
+		NextAttemptAt: due,
+		CreatedAt:     time.Now(),
+	}
+}
+
+// shardOf hashes the whole id: time-based UUIDs share their trailing bytes.
+func shardOf(campaignID gocql.UUID) int {
+	h := fnv.New32a()
+	h.Write(campaignID[:])
+	return int(h.Sum32() % models.SyncOutboxShards)
+}
+
+// shardScan is what one pass over an outbox shard found.
+type shardScan struct {
+	due     []models.SyncOutboxEntry
+	pending int       // entries read, due or not
+	oldest  time.Time // earliest created_at among them
+}
+
+// scanShard pages through a shard in id order until it has found limit
+// entries due by now. Entries still backing off are read past rather than
+// counted against limit, so a run of them at the head of the shard cannot
+// hold back the newer entries behind it. Each entry is dead-lettered after
+// maxAttempts, which bounds how many there are to read past.
+func scanShard(ctx context.Context, shard int, now time.Time, limit int) (shardScan, error) {
+	stmt, names := qb.Select(models.SyncOutboxTable.Name).
+		Where(qb.Eq("shard")).
+		ToCql()
+
+	iter := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx).PageSize(limit), names).
+		BindMap(map[string]interface{}{"shard": shard}).
+		Iter()
+
+	var scan shardScan
+	for len(scan.due) < limit {
+		var entry models.SyncOutboxEntry
+		if !iter.StructScan(&entry) {
+			break
+		}
+		scan.pending++
+		if scan.oldest.IsZero() || entry.CreatedAt.Before(scan.oldest) {
+			scan.oldest = entry.CreatedAt
+		}
+		if !entry.NextAttemptAt.After(now) {
+			scan.due = append(scan.due, entry)
+		}
+	}
+	return scan, iter.Close()
+}
+
+func deleteEntry(ctx context.Context, entry models.SyncOutboxEntry) error {
+	stmt, names := qb.Delete(models.SyncOutboxTable.Name).
+		Where(qb.Eq("shard"), qb.Eq("id")).
+		ToCql()
+
+	return gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindStruct(entry).
+		ExecRelease()
+}
+
+// reschedule records a failed attempt. It only updates an entry that still
+// exists, so a retry racing another instance's delete cannot resurrect it.
+func reschedule(ctx context.Context, entry models.SyncOutboxEntry) error {
+	stmt, names := qb.Update(models.SyncOutboxTable.Name).
+		Set("attempts", "next_attempt_at", "last_error").
+		Where(qb.Eq("shard"), qb.Eq("id")).
+		Existing().
+		ToCql()
+
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).BindStruct(entry)
+	_, err := q.MapScanCAS(map[string]interface{}{})
+	q.Release()
+	return err
+}
+
+// deadLetter moves an entry out of the outbox.
+func deadLetter(ctx context.Context, entry models.SyncOutboxEntry) error {
+	letter := models.SyncDeadLetter{
+		CampaignID: entry.CampaignID,
+		ID:         entry.ID,
+		Op:         entry.Op,
+		Attempts:   entry.Attempts,
+		LastError:  entry.LastError,
+		CreatedAt:  entry.CreatedAt,
+		FailedAt:   time.Now(),
+	}
+
+	batch := db.ScyllaSession.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+	stmt, _ := qb.Insert(models.SyncDeadLetterTable.Name).
+		Columns(models.SyncDeadLetterTable.Columns...).
+		ToCql()
+	batch.Query(stmt, letter.CampaignID, letter.ID, letter.Op, letter.Attempts, letter.LastError, letter.CreatedAt, letter.FailedAt)
+	stmt, _ = qb.Delete(models.SyncOutboxTable.Name).Where(qb.Eq("shard"), qb.Eq("id")).ToCql()
+	batch.Query(stmt, entry.Shard, entry.ID)
+
+	return db.ScyllaSession.ExecuteBatch(batch)
+}
@@ -0,0 +1,63 @@
+package worker
+
+import (
+	"context"
+	"go-fundraising/campaign/models"
+	"go-fundraising/db"
+	"go-fundraising/db/dbtest"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+)
+
+func insertEntry(t *testing.T, shard int, due time.Time) models.SyncOutboxEntry {
+	t.Helper()
+	entry := newEntry(gocql.TimeUUID(), "update", due)
+	entry.Shard = shard
+
+	stmt, names := qb.Insert(models.SyncOutboxTable.Name).
+		Columns(models.SyncOutboxTable.Columns...).
+		ToCql()
+	err := gocqlx.Query(db.ScyllaSession.Query(stmt), names).BindStruct(entry).ExecRelease()
+	if err != nil {
+		t.Fatalf("insert entry: %v", err)
+	}
+	return entry
+}
+
+func TestScanShardReadsPastBackedOffEntries(t *testing.T) {
+	dbtest.Setup(t, "worker")
+	now := time.Now()
+	const shard = 3
+
+	// The oldest entries are all waiting out a backoff.
+	for i := 0; i < 5; i++ {
+		insertEntry(t, shard, now.Add(time.Minute))
+	}
+	var due []models.SyncOutboxEntry
+	for i := 0; i < 3; i++ {
+		due = append(due, insertEntry(t, shard, now.Add(-time.Second)))
+	}
+
+	scan, err := scanShard(context.Background(), shard, now, 2)
+	if err != nil {
+		t.Fatalf("scan shard: %v", err)
+	}
+	if len(scan.due) != 2 || scan.due[0].ID != due[0].ID || scan.due[1].ID != due[1].ID {
+		t.Errorf("due = %+v, want the first two due entries", scan.due)
+	}
+	if scan.pending != 7 {
+		t.Errorf("pending = %d, want the 7 entries read", scan.pending)
+	}
+
+	scan, err = scanShard(context.Background(), shard, now, 10)
+	if err != nil {
+		t.Fatalf("scan shard: %v", err)
+	}
+	if len(scan.due) != 3 || scan.pending != 8 {
+		t.Errorf("scan = %d due of %d pending, want 3 of 8", len(scan.due), scan.pending)
+	}
+}
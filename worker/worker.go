@@ -4,73 +4,391 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"go-fundraising/campaign/models"
+	"go-fundraising/currency"
 	"go-fundraising/db"
+	payment "go-fundraising/payment/models"
 	"log"
+	"math/rand"
+	"sync"
+	"time"
 
 	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
 )
 
-var syncJobs chan models.Campaign
+const (
+	pollInterval = time.Second
+	scanLimit    = 500
 
+	// maxAttempts is how often an entry is tried before it is dead-lettered.
+	// With the backoff below that is roughly half an hour of retries.
+	maxAttempts = 12
+	baseBackoff = time.Second
+	maxBackoff  = 5 * time.Minute
+)
+
+var (
+	// notify holds one channel per worker. Worker i owns the outbox shards
+	// congruent to i, so every shard is drained by exactly one goroutine.
+	notify []chan models.SyncOutboxEntry
+
+	// stop asks the workers to drain and exit; workCtx is cancelled when
+	// the drain runs out of time, abandoning the entries in flight.
+	stop       chan struct{}
+	workCtx    context.Context
+	cancelWork context.CancelFunc
+	running    sync.WaitGroup
+)
+
+// InitSyncWorkers starts the workers that drain the search sync outbox.
 func InitSyncWorkers(workerCount int) {
-	syncJobs = make(chan models.Campaign, 1000)
+	if workerCount > models.SyncOutboxShards {
+		workerCount = models.SyncOutboxShards
+	}
+	if workerCount < 1 {
+		workerCount = 1
+	}
+
+	notify = make([]chan models.SyncOutboxEntry, workerCount)
+	for i := range notify {
+		notify[i] = make(chan models.SyncOutboxEntry, 1000)
+	}
+	stop = make(chan struct{})
+	workCtx, cancelWork = context.WithCancel(context.Background())
 
 	for i := 0; i < workerCount; i++ {
-		go syncWorker(i)
+		var shards []int
+		for shard := i; shard < models.SyncOutboxShards; shard += workerCount {
+			shards = append(shards, shard)
+		}
+		running.Add(1)
+		go syncWorker(i, shards, notify[i])
 	}
 
 	log.Printf("🚀 Started %d ES sync workers\n", workerCount)
 }
 
-func syncWorker(id int) {
-	for campaign := range syncJobs {
-		syncToES(id, campaign)
+// StopSyncWorkers stops polling the outbox and waits for the workers to
+// finish the entries they were handed, until ctx is done. Whatever is left
+// stays in the outbox for the next start, so stopping early loses nothing
+// but time.
+func StopSyncWorkers(ctx context.Context) error {
+	if stop == nil {
+		return nil
+	}
+	close(stop)
+
+	finished := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		cancelWork()
+		log.Println("🛑 ES sync workers drained")
+		return nil
+	case <-ctx.Done():
+		cancelWork()
+		<-finished
+		return ctx.Err()
+	}
+}
+
+func stopping() bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Notify hands entry to its worker once the write it records has committed,
+// so it is synced right away instead of on the next poll. It never blocks:
+// if the worker is busy the poll picks the entry up.
+func Notify(entry models.SyncOutboxEntry) {
+	if len(notify) == 0 {
+		return
+	}
+	select {
+	case notify[entry.Shard%len(notify)] <- entry:
+	default:
+	}
+}
+
+func syncWorker(id int, shards []int, entries <-chan models.SyncOutboxEntry) {
+	defer running.Done()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case entry := <-entries:
+			process(id, entry)
+		case <-ticker.C:
+			for _, shard := range shards {
+				if stopping() {
+					break
+				}
+				poll(id, shard)
+			}
+		case <-stop:
+			drain(id, entries)
+			return
+		}
+	}
+}
+
+// drain processes the notifications already queued for a stopping worker.
+func drain(workerID int, entries <-chan models.SyncOutboxEntry) {
+	for workCtx.Err() == nil {
+		select {
+		case entry := <-entries:
+			process(workerID, entry)
+		default:
+			return
+		}
+	}
+}
+
+func poll(workerID, shard int) {
+	scan, err := scanShard(workCtx, shard, time.Now(), scanLimit)
+	if err != nil {
+		log.Printf("❌ Worker %d failed to read outbox shard %d: %v\n", workerID, shard, err)
+		return
+	}
+	recordScan(shard, scan)
+
+	for _, entry := range scan.due {
+		if stopping() {
+			return
+		}
+		process(workerID, entry)
+	}
+}
+
+// process brings the search document of entry's campaign in line with the
+// campaign row as it is now, then settles the entry.
+func process(workerID int, entry models.SyncOutboxEntry) {
+	ctx := workCtx
+
+	err := syncCampaign(ctx, entry.CampaignID)
+	if err == nil {
+		if err := deleteEntry(ctx, entry); err != nil {
+			log.Printf("❌ Worker %d failed to clear outbox entry %s: %v\n", workerID, entry.ID, err)
+		}
+		syncedTotal.Add(1)
+		log.Printf("✔️ Worker %d synced campaign %s\n", workerID, entry.CampaignID)
+		return
+	}
+
+	if ctx.Err() != nil {
+		// Shutting down; the entry is retried as it is on the next start.
+		return
+	}
+
+	entry.Attempts++
+	entry.LastError = err.Error()
+
+	if isPermanent(err) || entry.Attempts >= maxAttempts {
+		if err := deadLetter(ctx, entry); err != nil {
+			log.Printf("❌ Worker %d failed to dead-letter outbox entry %s: %v\n", workerID, entry.ID, err)
+			return
+		}
+		deadLetteredTotal.Add(1)
+		log.Printf("❌ Worker %d gave up on campaign %s after %d attempts: %v\n", workerID, entry.CampaignID, entry.Attempts, err)
+		return
+	}
+
+	entry.NextAttemptAt = time.Now().Add(backoff(entry.Attempts))
+	if err := reschedule(ctx, entry); err != nil {
+		log.Printf("❌ Worker %d failed to reschedule outbox entry %s: %v\n", workerID, entry.ID, err)
+		return
+	}
+	retriedTotal.Add(1)
+	log.Printf("⚠️ Worker %d will retry campaign %s (attempt %d): %v\n", workerID, entry.CampaignID, entry.Attempts, err)
+}
+
+// backoff doubles the delay with every attempt, up to maxBackoff, and adds
+// up to half again as jitter so failed entries do not retry in lockstep.
+func backoff(attempts int) time.Duration {
+	delay := maxBackoff
+	if attempts < 20 {
+		delay = min(baseBackoff<<(attempts-1), maxBackoff)
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func syncCampaign(ctx context.Context, campaignID gocql.UUID) error {
+	var campaign models.Campaign
+
+	stmt, names := qb.Select(models.CampaignTable.Name).Where(qb.Eq("id")).ToCql()
+	err := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"id": campaignID}).
+		GetRelease(&campaign)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return deleteFromES(ctx, campaignID)
+	}
+	if err != nil {
+		return err
+	}
+
+	doc, err := Document(ctx, campaign)
+	if err != nil {
+		return err
 	}
+	return syncToES(ctx, campaign.ID, doc)
 }
 
-func syncToES(workerID int, campaign models.Campaign) {
-	index := "campaigns"
+// TrendingWindow is how far back donations count towards a campaign's
+// recent_donations, which the trending sort ranks by.
+const TrendingWindow = 7 * 24 * time.Hour
+
+// Document builds the search document for campaign as read from its row,
+// adding its totals and recent donation activity.
+func Document(ctx context.Context, campaign models.Campaign) (map[string]interface{}, error) {
+	if campaign.Currency == "" {
+		campaign.Currency = currency.Default
+	}
+	if campaign.Category == "" {
+		campaign.Category = models.CategoryOther
+	}
+
+	var totals []models.CampaignTotals
+	stmt, names := qb.Select(models.CampaignTotalsTable.Name).
+		Columns(models.CampaignTotalsTable.Columns...).
+		Where(qb.Eq("campaign_id")).
+		ToCql()
+	err := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{"campaign_id": campaign.ID}).
+		SelectRelease(&totals)
+	if err != nil {
+		return nil, fmt.Errorf("load totals: %w", err)
+	}
+	campaign.ApplyTotals(totals, currency.Rates())
+
+	var (
+		recent int64
+		last   time.Time
+	)
+	stmt, names = qb.Select(payment.PaymentHistoryTable.Name).
+		CountAll().
+		Max("created_at").
+		Where(qb.Eq("campaign_id"), qb.GtOrEq("created_at")).
+		ToCql()
+	q := gocqlx.Query(db.ScyllaSession.Query(stmt).WithContext(ctx), names).
+		BindMap(map[string]interface{}{
+			"campaign_id": campaign.ID,
+			"created_at":  time.Now().Add(-TrendingWindow),
+		})
+	err = q.Scan(&recent, &last)
+	q.Release()
+	if err != nil {
+		return nil, fmt.Errorf("load recent donations: %w", err)
+	}
+
+	return map[string]interface{}{
+		"id":               campaign.ID.String(),
+		"username":         campaign.Username,
+		"user_id":          campaign.UserID,
+		"title":            campaign.Title,
+		"description":      campaign.Description,
+		"image":            campaign.Image,
+		"category":         campaign.Category,
+		"target":           campaign.Target,
+		"currency":         campaign.Currency,
+		"amount_collected": campaign.AmountCollected,
+		"percent_funded":   campaign.PercentFunded(),
+		"donation_count":   campaign.DonationCount,
+		"recent_donations": recent,
+		"last_donation_at": optionalTime(last),
+		"status":           campaign.Status,
+		"deadline":         optionalTime(campaign.Deadline),
+		"created_at":       campaign.CreatedAt,
+	}, nil
+}
 
-	body := map[string]interface{}{
-		"id":          campaign.ID.String(),
-		"username":    campaign.Username,
-		"user_id":     campaign.UserID,
-		"title":       campaign.Title,
-		"description": campaign.Description,
-		"image":       campaign.Image,
-		"deadline":    campaign.Deadline,
-		"created_at":  campaign.CreatedAt,
+// optionalTime leaves unset times out of range queries and sorts.
+func optionalTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
 	}
+	return t
+}
 
+func syncToES(ctx context.Context, campaignID gocql.UUID, doc map[string]interface{}) error {
 	var buf bytes.Buffer
-	_ = json.NewEncoder(&buf).Encode(body)
+	if err := json.NewEncoder(&buf).Encode(doc); err != nil {
+		return permanentError{fmt.Errorf("encode document: %w", err)}
+	}
 
 	req := esapi.IndexRequest{
-		Index:      index,
-		DocumentID: campaign.ID.String(),
+		Index:      IndexAlias,
+		DocumentID: campaignID.String(),
 		Body:       &buf,
 		Refresh:    "false",
 	}
 
-	res, err := req.Do(context.Background(), db.ElasticClient)
+	res, err := req.Do(ctx, db.ElasticClient)
 	if err != nil {
-		log.Printf("❌ Worker %d error indexing: %v\n", workerID, err)
-		return
+		return fmt.Errorf("es index error: %w", err)
 	}
 	defer res.Body.Close()
 
 	if res.IsError() {
-		log.Printf("❌ Worker %d ES index error: %s\n", workerID, res.Status())
-	} else {
-		log.Printf("✔️ Worker %d synced campaign %s\n", workerID, campaign.ID.String())
+		return responseError("es index", res)
 	}
+	return nil
 }
 
-func EnqueueSync(campaign models.Campaign) {
-	select {
-	case syncJobs <- campaign:
+func deleteFromES(ctx context.Context, campaignID gocql.UUID) error {
+	req := esapi.DeleteRequest{
+		Index:      IndexAlias,
+		DocumentID: campaignID.String(),
+		Refresh:    "false",
+	}
+
+	res, err := req.Do(ctx, db.ElasticClient)
+	if err != nil {
+		return fmt.Errorf("es delete error: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() && res.StatusCode != 404 {
+		return responseError("es delete", res)
+	}
+	return nil
+}
+
+// permanentError marks failures that retrying cannot fix.
+type permanentError struct{ error }
+
+func (e permanentError) Unwrap() error { return e.error }
+
+func isPermanent(err error) bool {
+	var permanent permanentError
+	return errors.As(err, &permanent)
+}
+
+// responseError describes a failed Elasticsearch response. Client errors
+// mean the request itself was rejected, except for timeouts, version
+// conflicts and throttling, which can succeed later.
+func responseError(op string, res *esapi.Response) error {
+	err := fmt.Errorf("%s: %s", op, res.Status())
+	switch {
+	case res.StatusCode == 408, res.StatusCode == 409, res.StatusCode == 429:
+		return err
+	case res.StatusCode >= 400 && res.StatusCode < 500:
+		return permanentError{err}
 	default:
-		log.Println("⚠️ Sync queue full, dropping job:", campaign.ID)
+		return err
 	}
 }